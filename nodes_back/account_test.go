@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	useMemoryStore(t)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"new user", `{"username": "alice", "password": "correct horse"}`, http.StatusCreated},
+		{"taken username", `{"username": "alice", "password": "correct horse"}`, http.StatusConflict},
+		{"short password", `{"username": "bob", "password": "123"}`, http.StatusUnprocessableEntity},
+		{"invalid username", `{"username": "a b", "password": "correct horse"}`, http.StatusUnprocessableEntity},
+		{"missing fields", `{}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := serveAs(Register, "POST", tt.body, nil); w.Code != tt.want {
+			t.Errorf("%s: status %d (%s), want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+}
+
+// accountUsers stores alice with a password and olga, created by the OpenID
+// provider with a password she never saw.
+func accountUsers(t *testing.T) (*User, *User) {
+	s := useMemoryStore(t)
+	for _, username := range []string{"alice", "olga"} {
+		hash, err := hashPassword("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.CreateUser(username, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetUserIdentity("olga", "https://id.example.com", "sub-1"); err != nil {
+		t.Fatal(err)
+	}
+	return &User{Username: "alice"}, &User{Username: "olga"}
+}
+
+func TestChangePassword(t *testing.T) {
+	alice, olga := accountUsers(t)
+
+	tests := []struct {
+		name string
+		user *User
+		body string
+		want int
+	}{
+		{"wrong current password", alice, `{"current_password": "wrong", "new_password": "battery staple"}`, http.StatusUnprocessableEntity},
+		{"missing current password", alice, `{"new_password": "battery staple"}`, http.StatusUnprocessableEntity},
+		{"short new password", alice, `{"current_password": "correct horse", "new_password": "123"}`, http.StatusUnprocessableEntity},
+		{"password account", alice, `{"current_password": "correct horse", "new_password": "battery staple"}`, http.StatusAccepted},
+		{"provider account", olga, `{"new_password": "battery staple"}`, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		if w := serveAs(ChangePassword, "PUT", tt.body, tt.user); w.Code != tt.want {
+			t.Errorf("%s: status %d (%s), want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	alice, olga := accountUsers(t)
+
+	tests := []struct {
+		name string
+		user *User
+		body string
+		want int
+	}{
+		{"missing password", alice, `{}`, http.StatusUnprocessableEntity},
+		{"wrong password", alice, `{"password": "wrong"}`, http.StatusUnprocessableEntity},
+		{"password account", alice, `{"password": "correct horse"}`, http.StatusAccepted},
+		{"provider account", olga, `{}`, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		if w := serveAs(DeleteAccount, "DELETE", tt.body, tt.user); w.Code != tt.want {
+			t.Errorf("%s: status %d (%s), want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+	users, err := store.ListUsers()
+	if err != nil || len(users) != 0 {
+		t.Errorf("users left: %v, %v", users, err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// authenticated serves a request with the given Authorization token
+// through Authenticator and returns the response and the user it let in.
+func authenticated(method string, token string) (*httptest.ResponseRecorder, *User) {
+	var user *User
+	handler := Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = authUser(r)
+	}))
+	r := httptest.NewRequest(method, "/modules", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w, user
+}
+
+func TestAuthenticatorAPIKey(t *testing.T) {
+	s := useMemoryStore(t)
+	captureAudit(t)
+	for _, username := range []string{"alice", "bob"} {
+		if _, err := s.CreateUser(username, "hash"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, full, err := newAPIKey("alice", "deploy", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read_only_key, read_only, err := newAPIKey("alice", "stats", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, bobs, err := newAPIKey("bob", "backup", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{"key", "POST", full, http.StatusOK},
+		{"read-only key, GET", "GET", read_only, http.StatusOK},
+		{"read-only key, HEAD", "HEAD", read_only, http.StatusOK},
+		{"read-only key, POST", "POST", read_only, http.StatusForbidden},
+		{"read-only key, PUT", "PUT", read_only, http.StatusForbidden},
+		{"read-only key, DELETE", "DELETE", read_only, http.StatusForbidden},
+		{"wrong secret", "GET", read_only[:strings.IndexByte(read_only, '.')] + ".wrong", http.StatusUnauthorized},
+		{"no secret", "GET", apiKeyPrefix + read_only_key.Uid, http.StatusUnauthorized},
+		{"malformed uid", "GET", apiKeyPrefix + "zzz.secret", http.StatusUnauthorized},
+		{"missing key", "GET", apiKeyPrefix + "0xdead.secret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w, user := authenticated(tt.method, tt.token)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d (%s), want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+		if tt.want == http.StatusOK && (user == nil || user.Username != "alice" || user.Password != "") {
+			t.Errorf("%s: user %+v, want alice without her password", tt.name, user)
+		}
+	}
+
+	// Revoked keys and the keys of deleted users stop working
+	if err := s.DeleteAPIKey(read_only_key.Uid); err != nil {
+		t.Fatal(err)
+	}
+	if w, _ := authenticated("GET", read_only); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: status %d, want 401", w.Code)
+	}
+	if err := s.DeleteUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if w, _ := authenticated("GET", bobs); w.Code != http.StatusUnauthorized {
+		t.Errorf("key of a deleted user: status %d, want 401", w.Code)
+	}
+}
+
+func TestSessionOnly(t *testing.T) {
+	s := useMemoryStore(t)
+	captureAudit(t)
+	if _, err := s.CreateUser("alice", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := newAPIKey("alice", "deploy", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := startSession("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := Authenticator(SessionOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for token, want := range map[string]int{key: http.StatusForbidden, tokens.Access: http.StatusOK} {
+		r := httptest.NewRequest("PUT", "/user/password", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("token %.8s...: status %d, want %d", token, w.Code, want)
+		}
+	}
+}
+
+// withKeyUID adds the {keyUID} of the route to the context of user.
+func withKeyUID(user *User, uid string) context.Context {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("keyUID", uid)
+	return context.WithValue(withUser(user), chi.RouteCtxKey, rctx)
+}
+
+func TestAPIKeyHandlers(t *testing.T) {
+	s := useMemoryStore(t)
+	captureAudit(t)
+	alice, bob := &User{Username: "alice"}, &User{Username: "bob"}
+	for _, user := range []*User{alice, bob} {
+		if _, err := s.CreateUser(user.Username, "hash"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := serveAs(CreateAPIKey, "POST", `{"name": "stats", "read_only": true}`, alice)
+	var created APIKeyResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("CreateAPIKey: status %d (%s), %v", w.Code, w.Body, err)
+	}
+	if !strings.HasPrefix(created.Key, apiKeyPrefix+created.Uid+".") || !created.ReadOnly || created.KeyHash != "" {
+		t.Errorf("created key = %+v, want the read-only secret without the hash", created)
+	}
+	if w := serveAs(CreateAPIKey, "POST", `{"name": " "}`, alice); w.Code != http.StatusBadRequest {
+		t.Errorf("key without name: status %d, want 400", w.Code)
+	}
+
+	w = serveAs(ListAPIKeys, "GET", "", alice)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Key[len(apiKeyPrefix+created.Uid+"."):]) || strings.Contains(w.Body.String(), "key_hash") {
+		t.Errorf("ListAPIKeys: status %d, %s, want the keys without secrets", w.Code, w.Body)
+	}
+	if w := serveAs(ListAPIKeys, "GET", "", bob); w.Body.String() != "[]\n" {
+		t.Errorf("keys of bob = %s, want none", w.Body)
+	}
+
+	// Only its owner revokes a key
+	revoke := func(user *User) int {
+		r := httptest.NewRequest("DELETE", "/", nil).WithContext(withKeyUID(user, created.Uid))
+		w := httptest.NewRecorder()
+		RevokeAPIKey(w, r)
+		return w.Code
+	}
+	if code := revoke(bob); code != http.StatusNotFound {
+		t.Errorf("bob revoking alice's key: status %d, want 404", code)
+	}
+	if code := revoke(alice); code != http.StatusAccepted {
+		t.Errorf("alice revoking her key: status %d, want 202", code)
+	}
+	if code := revoke(alice); code != http.StatusNotFound {
+		t.Errorf("revoking it again: status %d, want 404", code)
+	}
+}
+
+func TestAPIKeyLimit(t *testing.T) {
+	s := useMemoryStore(t)
+	captureAudit(t)
+	alice := &User{Username: "alice"}
+	if _, err := s.CreateUser("alice", "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxAPIKeys; i++ {
+		if w := serveAs(CreateAPIKey, "POST", fmt.Sprintf(`{"name": "key %d"}`, i), alice); w.Code != http.StatusCreated {
+			t.Fatalf("key %d: status %d (%s)", i, w.Code, w.Body)
+		}
+	}
+	if w := serveAs(CreateAPIKey, "POST", `{"name": "one more"}`, alice); w.Code != http.StatusConflict {
+		t.Errorf("key over the limit: status %d, want 409", w.Code)
+	}
+}
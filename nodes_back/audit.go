@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// audit writes the security events (failed logins, lockouts) to standard
+// error or to the file given with -audit-log.
+var audit = log.New(os.Stderr, "audit: ", log.LstdFlags|log.LUTC)
+
+// openAuditLog sends the audit entries to path, appending to it. The file
+// is returned so main() can close it.
+func openAuditLog(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	audit.SetOutput(f)
+	return f, nil
+}
+
+// auditf logs one event as "event key=value ...".
+func auditf(format string, v ...interface{}) {
+	audit.Printf(format, v...)
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errUnauthorized = errors.New("unauthorized")
+
+// hashPassword returns the bcrypt hash stored in User.Password.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword compares a password with the stored one. Accounts created
+// before passwords were hashed still hold the plain text; those match with
+// legacy set so the caller can hash them.
+func checkPassword(stored string, password string) (ok bool, legacy bool) {
+	if strings.HasPrefix(stored, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, true
+}
+
+// unknownUserHash is compared with the password of unknown usernames, so
+// they take as long to reject as a wrong password.
+const unknownUserHash = "$2a$10$R8NWKZiPVZIRWZ/xW4bT9OkfNEReQIhXBI2jHHeD.C45Fr9/OHpny"
+
+// tokenClaims are the claims of the access token. The subject is the
+// username and the ID the uid of its session.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+}
+
+const tokenIssuer = "nodes"
+
+// issueToken signs an access token of the session sid that expires after
+// the configured TTL.
+func issueToken(username string, sid string) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(config.TokenTTL)
+	claims := tokenClaims{jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		Subject:   username,
+		ID:        sid,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expires),
+	}}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.AuthSecret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// parseToken checks the signature and expiry of a token and returns its
+// username and session.
+func parseToken(token string) (string, string, error) {
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(config.AuthSecret), nil
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", errUnauthorized, err)
+	}
+	if claims.Issuer != tokenIssuer || claims.Subject == "" || claims.ID == "" {
+		return "", "", fmt.Errorf("%w: token has no user or session", errUnauthorized)
+	}
+	return claims.Subject, claims.ID, nil
+}
+
+/***************** Sessions ******************/
+// loginTokens are handed out at login and on every refresh.
+type loginTokens struct {
+	Access         string
+	AccessExpires  time.Time
+	Refresh        string
+	RefreshExpires time.Time
+}
+
+// randomString returns 32 random bytes in hex, for secrets and ids that
+// must not be guessed.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newRefreshSecret returns the random part of a refresh token and the hash
+// kept in the session.
+func newRefreshSecret() (string, string, error) {
+	secret, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	return secret, hashRefreshSecret(secret), nil
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionTokens issues the access token of session and its refresh token,
+// "<session uid>.<secret>".
+func sessionTokens(session *Session, secret string) (*loginTokens, error) {
+	access, expires, err := issueToken(session.Username, session.Uid)
+	if err != nil {
+		return nil, err
+	}
+	return &loginTokens{
+		Access:         access,
+		AccessExpires:  expires,
+		Refresh:        session.Uid + "." + secret,
+		RefreshExpires: session.ExpiresAt,
+	}, nil
+}
+
+// startSession opens a session for username after a login.
+func startSession(username string) (*loginTokens, error) {
+	secret, hash, err := newRefreshSecret()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	session := &Session{
+		Username:  username,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.RefreshTTL),
+	}
+	if session.Uid, err = store.CreateSession(session); err != nil {
+		return nil, err
+	}
+	return sessionTokens(session, secret)
+}
+
+// findSession returns the live session of a refresh token. A refresh token
+// that was already used means it leaked: the session is ended.
+func findSession(refresh_token string) (*Session, error) {
+	sid, secret := refresh_token, ""
+	if i := strings.IndexByte(refresh_token, '.'); i >= 0 {
+		sid, secret = refresh_token[:i], refresh_token[i+1:]
+	}
+	if checkUid(sid) != nil || secret == "" {
+		return nil, fmt.Errorf("%w: malformed refresh token", errUnauthorized)
+	}
+
+	session, err := store.GetSession(sid)
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("%w: session %s has ended", errUnauthorized, sid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, fmt.Errorf("%w: session %s has expired", errUnauthorized, sid)
+	}
+	if subtle.ConstantTimeCompare([]byte(session.TokenHash), []byte(hashRefreshSecret(secret))) != 1 {
+		auditf("refresh_token_reused user=%q session=%s", session.Username, sid)
+		if err := store.DeleteSession(sid); err != nil && !errors.Is(err, errNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: refresh token of session %s was already used", errUnauthorized, sid)
+	}
+	return session, nil
+}
+
+// refreshSession trades a refresh token for a new access token and a new
+// refresh token; the old one stops working. The session is extended.
+func refreshSession(refresh_token string) (*Session, *loginTokens, error) {
+	session, err := findSession(refresh_token)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, hash, err := newRefreshSecret()
+	if err != nil {
+		return nil, nil, err
+	}
+	session.TokenHash = hash
+	session.ExpiresAt = time.Now().UTC().Add(config.RefreshTTL)
+	if err := store.UpdateSession(session); err != nil {
+		return nil, nil, err
+	}
+	tokens, err := sessionTokens(session, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, tokens, nil
+}
+
+// endSession revokes the session of a refresh token.
+func endSession(refresh_token string) (*Session, error) {
+	session, err := findSession(refresh_token)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.DeleteSession(session.Uid); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+/***************** API keys ******************/
+// apiKeyPrefix starts every API key, "nk_<key uid>.<secret>", so the
+// Authenticator tells them from access tokens.
+const apiKeyPrefix = "nk_"
+
+// maxAPIKeys is how many API keys a user may have at once.
+const maxAPIKeys = 20
+
+// newAPIKey creates an API key of username and returns it with the only
+// copy of its secret.
+func newAPIKey(username string, name string, read_only bool) (*APIKey, string, error) {
+	secret, hash, err := newRefreshSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	key := &APIKey{
+		Username:  username,
+		Name:      name,
+		KeyHash:   hash,
+		ReadOnly:  read_only,
+		CreatedAt: time.Now().UTC(),
+	}
+	if key.Uid, err = store.CreateAPIKey(key); err != nil {
+		return nil, "", err
+	}
+	return key, apiKeyPrefix + key.Uid + "." + secret, nil
+}
+
+// findAPIKey returns the stored key of an "nk_..." API key.
+func findAPIKey(token string) (*APIKey, error) {
+	uid, secret := strings.TrimPrefix(token, apiKeyPrefix), ""
+	if i := strings.IndexByte(uid, '.'); i >= 0 {
+		uid, secret = uid[:i], uid[i+1:]
+	}
+	if checkUid(uid) != nil || secret == "" {
+		return nil, fmt.Errorf("%w: malformed api key", errUnauthorized)
+	}
+
+	key, err := store.GetAPIKey(uid)
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("%w: api key %s was revoked", errUnauthorized, uid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashRefreshSecret(secret))) != 1 {
+		auditf("api_key_rejected user=%q key=%s", key.Username, uid)
+		return nil, fmt.Errorf("%w: wrong secret for api key %s", errUnauthorized, uid)
+	}
+	return key, nil
+}
+
+// readOnlyMethod tells whether a request with a read-only API key may use
+// method.
+func readOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+type ctxKey int
+
+const (
+	userCtxKey ctxKey = iota
+	apiKeyCtxKey
+	debugSessionCtxKey
+)
+
+// Authenticator checks the "Authorization: Bearer <token>" header and puts
+// the user into the request context. The token is an access token or an
+// API key; read-only keys may only GET. Tokens of deleted users and of
+// ended sessions are rejected.
+func Authenticator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			render.Render(w, r, ErrUnauthorized(fmt.Errorf("%w: missing bearer token", errUnauthorized)))
+			return
+		}
+		token := strings.TrimPrefix(header, "Bearer ")
+
+		var username string
+		var key *APIKey
+		if strings.HasPrefix(token, apiKeyPrefix) {
+			var err error
+			if key, err = findAPIKey(token); err != nil {
+				if errors.Is(err, errUnauthorized) {
+					render.Render(w, r, ErrUnauthorized(err))
+				} else {
+					render.Render(w, r, ErrStore(err))
+				}
+				return
+			}
+			if key.ReadOnly && !readOnlyMethod(r.Method) {
+				render.Render(w, r, ErrStore(fmt.Errorf("api key %s is read-only: %w", key.Uid, errForbidden)))
+				return
+			}
+			username = key.Username
+		} else {
+			var sid string
+			var err error
+			username, sid, err = parseToken(token)
+			if err != nil {
+				render.Render(w, r, ErrUnauthorized(err))
+				return
+			}
+
+			session, err := store.GetSession(sid)
+			if errors.Is(err, errNotFound) || err == nil && (session.Username != username || time.Now().After(session.ExpiresAt)) {
+				render.Render(w, r, ErrUnauthorized(fmt.Errorf("%w: session %s has ended", errUnauthorized, sid)))
+				return
+			}
+			if err != nil {
+				render.Render(w, r, ErrStore(err))
+				return
+			}
+		}
+
+		users, err := store.GetUsersByUsername(username)
+		if err != nil {
+			render.Render(w, r, ErrStore(err))
+			return
+		}
+		if len(users) == 0 {
+			render.Render(w, r, ErrUnauthorized(fmt.Errorf("%w: user %s no longer exists", errUnauthorized, username)))
+			return
+		}
+		user := users[0]
+		user.Password = ""
+
+		ctx := context.WithValue(r.Context(), userCtxKey, &user)
+		if key != nil {
+			ctx = context.WithValue(ctx, apiKeyCtxKey, key)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authUser returns the user set by Authenticator.
+func authUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userCtxKey).(*User)
+	return user
+}
+
+// authAPIKey returns the API key the request was authenticated with, nil
+// for an access token.
+func authAPIKey(r *http.Request) *APIKey {
+	key, _ := r.Context().Value(apiKeyCtxKey).(*APIKey)
+	return key
+}
+
+// SessionOnly keeps API keys out of the account settings: managing keys,
+// changing the password and deleting the account need a login.
+func SessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := authAPIKey(r); key != nil {
+			render.Render(w, r, ErrStore(fmt.Errorf("api key %s cannot manage the account: %w", key.Uid, errForbidden)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/***************** Ownership ******************/
+// canEdit tells whether user may change module.
+func canEdit(user *User, module *Module) bool {
+	return user != nil && module.Owner == user.Username
+}
+
+// authorize checks that the authenticated user owns the modules the given
+// module, node, data, input/output or connection uids belong to.
+func authorize(r *http.Request, uids ...string) error {
+	user := authUser(r)
+	for _, uid := range uids {
+		module, err := store.ModuleOf(uid)
+		if err != nil {
+			return err
+		}
+		if !canEdit(user, module) {
+			return fmt.Errorf("%s belongs to another user: %w", uid, errForbidden)
+		}
+	}
+	return nil
+}
+
+// OwnerOnly runs authorize on the uid in the given URL parameter.
+func OwnerOnly(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := authorize(r, chi.URLParam(r, param)); err != nil {
+				render.Render(w, r, ErrStore(err))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/***************** Roles ******************/
+const (
+	roleStudent = "student"
+	roleTeacher = "teacher"
+	roleAdmin   = "admin"
+)
+
+var roles = []string{roleStudent, roleTeacher, roleAdmin}
+
+// roleOf returns the role of user. Accounts created before roles existed
+// have none and are students.
+func roleOf(user *User) string {
+	if user == nil {
+		return ""
+	}
+	if user.Role == "" {
+		return roleStudent
+	}
+	return user.Role
+}
+
+func validRole(role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole lets through only the users with one of the given roles.
+func RequireRole(allowed ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := roleOf(authUser(r))
+			for _, a := range allowed {
+				if role == a {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			render.Render(w, r, ErrStore(fmt.Errorf("role %s: %w", role, errForbidden)))
+		})
+	}
+}
+
+// canView tells whether user may read module: its owner, an admin, or the
+// teacher of a group the owner belongs to.
+func canView(user *User, module *Module) (bool, error) {
+	if canEdit(user, module) || roleOf(user) == roleAdmin {
+		return true, nil
+	}
+	if roleOf(user) != roleTeacher {
+		return false, nil
+	}
+	students, err := teacherStudents(user.Username)
+	if err != nil {
+		return false, err
+	}
+	for _, student := range students {
+		if student == module.Owner {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// canManageGroup tells whether user may see the join code, members and
+// modules of group: its teacher or an admin.
+func canManageGroup(user *User, group *Group) bool {
+	return user != nil && (group.Teacher == user.Username || roleOf(user) == roleAdmin)
+}
+
+// teacherStudents returns the members of the groups taught by teacher,
+// without duplicates.
+func teacherStudents(teacher string) ([]string, error) {
+	groups, err := store.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	var students []string
+	seen := map[string]bool{}
+	for _, group := range groups {
+		if group.Teacher != teacher {
+			continue
+		}
+		for _, member := range group.Members {
+			if !seen[member] {
+				seen[member] = true
+				students = append(students, member)
+			}
+		}
+	}
+	return students, nil
+}
+
+// ViewerOnly checks that the authenticated user can read the module in the
+// given URL parameter.
+func ViewerOnly(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uid := chi.URLParam(r, param)
+			module, err := store.ModuleOf(uid)
+			if err == nil {
+				var ok bool
+				if ok, err = canView(authUser(r), module); err == nil && !ok {
+					err = fmt.Errorf("%s belongs to another user: %w", uid, errForbidden)
+				}
+			}
+			if err != nil {
+				render.Render(w, r, ErrStore(err))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+// classroom is a store where alice and bob are students, tina teaches a
+// group alice belongs to, tom teaches nobody and ada is an admin. alice and
+// bob have a module each, alice's with a connected node.
+type classroom struct {
+	alice, bob, tina, tom, ada *User
+	aliceModule, bobModule     string
+	node                       Node
+}
+
+func newClassroom(t *testing.T) *classroom {
+	s := useMemoryStore(t)
+	c := &classroom{
+		alice: &User{Username: "alice"},
+		bob:   &User{Username: "bob", Role: roleStudent},
+		tina:  &User{Username: "tina", Role: roleTeacher},
+		tom:   &User{Username: "tom", Role: roleTeacher},
+		ada:   &User{Username: "ada", Role: roleAdmin},
+	}
+	var err error
+	if c.aliceModule, err = s.CreateModule(&Module{Name: "loops", Owner: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.bobModule, err = s.CreateModule(&Module{Name: "loops", Owner: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	c.node, err = s.CreateNode(&Node{ModuleUID: c.aliceModule, Id: 1, Name: "number", InputsOutputs: []*InputOutput{
+		testPort("", "output_1", "output", testConnection("", "2", "input_1")),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateGroup(&Group{Name: "1A", Teacher: "tina", Members: []string{"alice"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateGroup(&Group{Name: "1B", Teacher: "tom"}); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestAuthorize(t *testing.T) {
+	c := newClassroom(t)
+	port := c.node.InputsOutputs[0]
+
+	tests := []struct {
+		name string
+		user *User
+		uids []string
+		want error
+	}{
+		{"owner, module", c.alice, []string{c.aliceModule}, nil},
+		{"owner, node", c.alice, []string{c.node.Uid}, nil},
+		{"owner, data", c.alice, []string{c.node.Data.Uid}, nil},
+		{"owner, port and connection", c.alice, []string{port.Uid, port.Connections[0].Uid}, nil},
+		{"other student", c.bob, []string{c.aliceModule}, errForbidden},
+		{"other student, node", c.bob, []string{c.node.Uid}, errForbidden},
+		{"teacher of the owner", c.tina, []string{c.aliceModule}, errForbidden},
+		{"admin", c.ada, []string{c.node.Uid}, errForbidden},
+		{"one module of another user", c.alice, []string{c.aliceModule, c.bobModule}, errForbidden},
+		{"missing uid", c.alice, []string{"0xdead"}, errNotFound},
+		{"anonymous", nil, []string{c.aliceModule}, errForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil).WithContext(withUser(tt.user))
+		err := authorize(r, tt.uids...)
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: authorize = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCanView(t *testing.T) {
+	c := newClassroom(t)
+	alice_module, err := store.ModuleOf(c.aliceModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob_module, err := store.ModuleOf(c.bobModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		user   *User
+		module *Module
+		want   bool
+	}{
+		{"owner", c.alice, alice_module, true},
+		{"admin", c.ada, alice_module, true},
+		{"teacher of a group of the owner", c.tina, alice_module, true},
+		{"teacher of another group", c.tom, alice_module, false},
+		{"teacher, owner in no group", c.tina, bob_module, false},
+		{"other student", c.bob, alice_module, false},
+		{"anonymous", nil, alice_module, false},
+	}
+	for _, tt := range tests {
+		ok, err := canView(tt.user, tt.module)
+		if err != nil {
+			t.Errorf("%s: canView: %v", tt.name, err)
+		} else if ok != tt.want {
+			t.Errorf("%s: canView = %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+}
+
+func TestRoleOf(t *testing.T) {
+	tests := []struct {
+		user *User
+		want string
+	}{
+		{nil, ""},
+		{&User{}, roleStudent},
+		{&User{Role: roleTeacher}, roleTeacher},
+		{&User{Role: roleAdmin}, roleAdmin},
+	}
+	for _, tt := range tests {
+		if got := roleOf(tt.user); got != tt.want {
+			t.Errorf("roleOf(%+v) = %q, want %q", tt.user, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,1223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore keeps everything in a single bbolt file, so the server can run
+// without the docker-compose Dgraph cluster. Nodes are stored as whole
+// documents (with their Data, InputsOutputs and Connections embedded) and
+// the "parents" bucket maps every embedded uid back to its node.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+var (
+	bucketMeta        = []byte("meta")
+	bucketUsers       = []byte("users")
+	bucketModules     = []byte("modules")
+	bucketNodes       = []byte("nodes")
+	bucketConnections = []byte("connections") // left unattached by older versions, for the gc
+	bucketParents     = []byte("parents")     // data/port/connection uid -> node uid
+	bucketGroups      = []byte("groups")
+	bucketComments    = []byte("comments")
+	bucketSessions    = []byte("sessions")
+	bucketAPIKeys     = []byte("api_keys")
+)
+
+// boltSchemaVersion is the bucket layout written by this version. It is kept
+// under the "schema_version" key of the meta bucket. Version 2 added the
+// groups and comments buckets, version 3 the sessions, version 4 the API
+// keys.
+const boltSchemaVersion = 4
+
+var keySchemaVersion = []byte("schema_version")
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketMeta, bucketUsers, bucketModules, bucketNodes, bucketConnections, bucketParents, bucketGroups, bucketComments, bucketSessions, bucketAPIKeys} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// update and view run fn in a read-write or read-only transaction.
+func (s *BoltStore) update(fn func(tx *bolt.Tx) error) error {
+	return boltError(s.db.Update(fn))
+}
+
+func (s *BoltStore) view(fn func(tx *bolt.Tx) error) error {
+	return boltError(s.db.View(fn))
+}
+
+func (s *BoltStore) LatestSchemaVersion() int {
+	return boltSchemaVersion
+}
+
+func (s *BoltStore) SchemaVersion() (int, error) {
+	version := 0
+	err := s.view(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketMeta).Get(keySchemaVersion); v != nil {
+			var err error
+			version, err = strconv.Atoi(string(v))
+			return err
+		}
+		return nil
+	})
+	return version, err
+}
+
+// Migrate records the layout version. The buckets themselves are created
+// when the file is opened.
+func (s *BoltStore) Migrate() error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMeta).Put(keySchemaVersion, []byte(strconv.Itoa(boltSchemaVersion)))
+	})
+}
+
+// boltNewUid returns a new uid in the same "0x.." format Dgraph uses.
+func boltNewUid(tx *bolt.Tx) (string, error) {
+	seq, err := tx.Bucket(bucketMeta).NextSequence()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("0x%x", seq), nil
+}
+
+// bucketObject names what a bucket holds, for not found errors.
+var bucketObject = map[string]string{
+	"users":       "user",
+	"modules":     "module",
+	"nodes":       "node",
+	"connections": "connection",
+	"groups":      "group",
+	"comments":    "comment",
+	"sessions":    "session",
+	"api_keys":    "api key",
+}
+
+func boltGet(tx *bolt.Tx, bucket []byte, key string, v interface{}) error {
+	b := tx.Bucket(bucket).Get([]byte(key))
+	if b == nil {
+		return fmt.Errorf("%s %s: %w", bucketObject[string(bucket)], key, errNotFound)
+	}
+	return json.Unmarshal(b, v)
+}
+
+func boltPut(tx *bolt.Tx, bucket []byte, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put([]byte(key), b)
+}
+
+// boltNodeOf loads the node owning the given data, port or connection uid.
+func boltNodeOf(tx *bolt.Tx, uid string) (*Node, error) {
+	parent := tx.Bucket(bucketParents).Get([]byte(uid))
+	if parent == nil {
+		return nil, fmt.Errorf("%s: %w", uid, errNotFound)
+	}
+	node := &Node{}
+	if err := boltGet(tx, bucketNodes, string(parent), node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// boltDeleteNode removes a node document and all its parents entries.
+func boltDeleteNode(tx *bolt.Tx, node *Node) error {
+	parents := tx.Bucket(bucketParents)
+	if node.Data.Uid != "" {
+		if err := parents.Delete([]byte(node.Data.Uid)); err != nil {
+			return err
+		}
+	}
+	for _, input_output := range node.InputsOutputs {
+		for _, connection := range input_output.Connections {
+			if err := parents.Delete([]byte(connection.Uid)); err != nil {
+				return err
+			}
+		}
+		if err := parents.Delete([]byte(input_output.Uid)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(bucketNodes).Delete([]byte(node.Uid))
+}
+
+func boltModuleNodes(tx *bolt.Tx, module_uid string) ([]*Node, error) {
+	var nodes []*Node
+	err := tx.Bucket(bucketNodes).ForEach(func(k, v []byte) error {
+		node := &Node{}
+		if err := json.Unmarshal(v, node); err != nil {
+			return err
+		}
+		if node.ModuleUID == module_uid {
+			nodes = append(nodes, node)
+		}
+		return nil
+	})
+	sort.Slice(nodes, func(i, j int) bool { return uidLess(nodes[i].Uid, nodes[j].Uid) })
+	return nodes, err
+}
+
+// uidLess orders "0x.." uids numerically, the way Dgraph returns them.
+func uidLess(a string, b string) bool {
+	ai, _ := strconv.ParseUint(a, 0, 64)
+	bi, _ := strconv.ParseUint(b, 0, 64)
+	return ai < bi
+}
+
+func (s *BoltStore) CreateUser(username string, password string) (string, error) {
+	if err := validateUser(username, password); err != nil {
+		return "", err
+	}
+
+	var result string
+	err := s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketUsers).Get([]byte(username)) != nil {
+			return fmt.Errorf("user %s: %w", username, errConflict)
+		}
+		uid, err := boltNewUid(tx)
+		if err != nil {
+			return err
+		}
+		user := User{
+			Uid:        uid,
+			Username:   username,
+			Password:   password,
+			DgraphType: "User",
+		}
+		if err := boltPut(tx, bucketUsers, username, user); err != nil {
+			return err
+		}
+		result = uid
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+func (s *BoltStore) GetUsersByUsername(username string) ([]User, error) {
+	var users []User
+	err := s.view(func(tx *bolt.Tx) error {
+		user := User{}
+		if err := boltGet(tx, bucketUsers, username, &user); err != nil {
+			return err
+		}
+		users = append(users, user)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNotFound) {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *BoltStore) UpdatePassword(username string, password string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		user := User{}
+		if err := boltGet(tx, bucketUsers, username, &user); err != nil {
+			return err
+		}
+		user.Password = password
+		return boltPut(tx, bucketUsers, username, user)
+	})
+}
+
+func (s *BoltStore) UpdateRole(username string, role string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		user := User{}
+		if err := boltGet(tx, bucketUsers, username, &user); err != nil {
+			return err
+		}
+		user.Role = role
+		return boltPut(tx, bucketUsers, username, user)
+	})
+}
+
+func (s *BoltStore) SetUserIdentity(username string, issuer string, subject string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		user := User{}
+		if err := boltGet(tx, bucketUsers, username, &user); err != nil {
+			return err
+		}
+		user.OIDCIssuer = issuer
+		user.OIDCSubject = subject
+		return boltPut(tx, bucketUsers, username, user)
+	})
+}
+
+func (s *BoltStore) UserByIdentity(issuer string, subject string) (*User, error) {
+	users, err := s.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if user.OIDCIssuer == issuer && user.OIDCSubject == subject {
+			return &user, nil
+		}
+	}
+	return nil, fmt.Errorf("user of %s %s: %w", issuer, subject, errNotFound)
+}
+
+func (s *BoltStore) ListUsers() ([]User, error) {
+	var users []User
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketUsers).ForEach(func(k, v []byte) error {
+			user := User{}
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DeleteUser removes a user with all its modules, their nodes and comments,
+// the groups it teaches and its membership in the others.
+func (s *BoltStore) DeleteUser(username string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketUsers).Get([]byte(username)) == nil {
+			return fmt.Errorf("user %s: %w", username, errNotFound)
+		}
+		var modules []string
+		err := tx.Bucket(bucketModules).ForEach(func(k, v []byte) error {
+			module := Module{}
+			if err := json.Unmarshal(v, &module); err != nil {
+				return err
+			}
+			if module.Owner == username {
+				modules = append(modules, module.Uid)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, uid := range modules {
+			if err := boltDeleteModule(tx, uid); err != nil {
+				return err
+			}
+		}
+
+		groups, err := boltGroups(tx)
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			if group.Teacher == username {
+				if err := tx.Bucket(bucketGroups).Delete([]byte(group.Uid)); err != nil {
+					return err
+				}
+				continue
+			}
+			members := len(group.Members)
+			if group.Members = removeString(group.Members, username); len(group.Members) != members {
+				if err := boltPut(tx, bucketGroups, group.Uid, group); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := boltDeleteUserSessions(tx, username); err != nil {
+			return err
+		}
+		keys, err := boltUserAPIKeys(tx, username)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := tx.Bucket(bucketAPIKeys).Delete([]byte(key.Uid)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketUsers).Delete([]byte(username))
+	})
+}
+
+// boltSessions returns the sessions for which keep is true.
+func boltSessions(tx *bolt.Tx, keep func(session *Session) bool) ([]*Session, error) {
+	var sessions []*Session
+	err := tx.Bucket(bucketSessions).ForEach(func(k, v []byte) error {
+		session := &Session{}
+		if err := json.Unmarshal(v, session); err != nil {
+			return err
+		}
+		if keep(session) {
+			sessions = append(sessions, session)
+		}
+		return nil
+	})
+	return sessions, err
+}
+
+func boltDeleteUserSessions(tx *bolt.Tx, username string) (int, error) {
+	sessions, err := boltSessions(tx, func(session *Session) bool { return session.Username == username })
+	if err != nil {
+		return 0, err
+	}
+	for _, session := range sessions {
+		if err := tx.Bucket(bucketSessions).Delete([]byte(session.Uid)); err != nil {
+			return 0, err
+		}
+	}
+	return len(sessions), nil
+}
+
+func (s *BoltStore) CreateSession(session *Session) (string, error) {
+	if err := validateSession(session); err != nil {
+		return "", err
+	}
+
+	var uid string
+	err := s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketUsers).Get([]byte(session.Username)) == nil {
+			return fmt.Errorf("user %s: %w", session.Username, errNotFound)
+		}
+		var err error
+		if uid, err = boltNewUid(tx); err != nil {
+			return err
+		}
+		new_session := *session
+		new_session.Uid = uid
+		new_session.DgraphType = "Session"
+		return boltPut(tx, bucketSessions, uid, new_session)
+	})
+	if err != nil {
+		return "", err
+	}
+	return uid, nil
+}
+
+func (s *BoltStore) GetSession(uid string) (*Session, error) {
+	session := &Session{}
+	err := s.view(func(tx *bolt.Tx) error {
+		return boltGet(tx, bucketSessions, uid, session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// UpdateSession stores the new token hash and expiry of a session.
+func (s *BoltStore) UpdateSession(session *Session) error {
+	if err := validateSession(session); err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		stored := Session{}
+		if err := boltGet(tx, bucketSessions, session.Uid, &stored); err != nil {
+			return err
+		}
+		stored.TokenHash = session.TokenHash
+		stored.ExpiresAt = session.ExpiresAt
+		return boltPut(tx, bucketSessions, session.Uid, stored)
+	})
+}
+
+func (s *BoltStore) DeleteSession(uid string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketSessions).Get([]byte(uid)) == nil {
+			return fmt.Errorf("session %s: %w", uid, errNotFound)
+		}
+		return tx.Bucket(bucketSessions).Delete([]byte(uid))
+	})
+}
+
+func (s *BoltStore) DeleteUserSessions(username string) (int, error) {
+	var deleted int
+	err := s.update(func(tx *bolt.Tx) error {
+		var err error
+		deleted, err = boltDeleteUserSessions(tx, username)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+func boltUserAPIKeys(tx *bolt.Tx, username string) ([]*APIKey, error) {
+	keys := []*APIKey{}
+	err := tx.Bucket(bucketAPIKeys).ForEach(func(k, v []byte) error {
+		key := &APIKey{}
+		if err := json.Unmarshal(v, key); err != nil {
+			return err
+		}
+		if key.Username == username {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sortAPIKeys(keys)
+	return keys, err
+}
+
+func (s *BoltStore) CreateAPIKey(key *APIKey) (string, error) {
+	if err := validateAPIKey(key); err != nil {
+		return "", err
+	}
+
+	var uid string
+	err := s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketUsers).Get([]byte(key.Username)) == nil {
+			return fmt.Errorf("user %s: %w", key.Username, errNotFound)
+		}
+		var err error
+		if uid, err = boltNewUid(tx); err != nil {
+			return err
+		}
+		new_key := *key
+		new_key.Uid = uid
+		new_key.DgraphType = "APIKey"
+		return boltPut(tx, bucketAPIKeys, uid, new_key)
+	})
+	if err != nil {
+		return "", err
+	}
+	return uid, nil
+}
+
+func (s *BoltStore) GetAPIKey(uid string) (*APIKey, error) {
+	key := &APIKey{}
+	err := s.view(func(tx *bolt.Tx) error {
+		return boltGet(tx, bucketAPIKeys, uid, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *BoltStore) UserAPIKeys(username string) ([]*APIKey, error) {
+	var keys []*APIKey
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		keys, err = boltUserAPIKeys(tx, username)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *BoltStore) DeleteAPIKey(uid string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketAPIKeys).Get([]byte(uid)) == nil {
+			return fmt.Errorf("api key %s: %w", uid, errNotFound)
+		}
+		return tx.Bucket(bucketAPIKeys).Delete([]byte(uid))
+	})
+}
+
+func (s *BoltStore) GetModuleByName(name string, username string) ([]Module, error) {
+	var modules []Module
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketModules).ForEach(func(k, v []byte) error {
+			module := Module{}
+			if err := json.Unmarshal(v, &module); err != nil {
+				return err
+			}
+			if module.Name == name && module.Owner == username {
+				modules = append(modules, module)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return modules, nil
+}
+
+func (s *BoltStore) CreateModule(module *Module) (string, error) {
+	if err := validateModule(module); err != nil {
+		return "", err
+	}
+
+	var uid string
+	err := s.update(func(tx *bolt.Tx) error {
+		var err error
+		uid, err = boltNewUid(tx)
+		if err != nil {
+			return err
+		}
+		new_module := Module{
+			Uid:        uid,
+			Name:       module.Name,
+			Owner:      module.Owner,
+			DgraphType: "Module",
+		}
+		return boltPut(tx, bucketModules, uid, new_module)
+	})
+	if err != nil {
+		return "", err
+	}
+	return uid, nil
+}
+
+func (s *BoltStore) UserGetModules(username string) ([]*Module, error) {
+	var modules []*Module
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketModules).ForEach(func(k, v []byte) error {
+			module := &Module{}
+			if err := json.Unmarshal(v, module); err != nil {
+				return err
+			}
+			if module.Owner == username {
+				modules = append(modules, module)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(modules, func(i, j int) bool { return uidLess(modules[i].Uid, modules[j].Uid) })
+	return modules, nil
+}
+
+// boltDeleteModule removes a module with all its nodes and comments.
+func boltDeleteModule(tx *bolt.Tx, uid string) error {
+	nodes, err := boltModuleNodes(tx, uid)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := boltDeleteNode(tx, node); err != nil {
+			return err
+		}
+	}
+	comments, err := boltModuleComments(tx, uid)
+	if err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		if err := tx.Bucket(bucketComments).Delete([]byte(comment.Uid)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(bucketModules).Delete([]byte(uid))
+}
+
+func boltGroups(tx *bolt.Tx) ([]*Group, error) {
+	var groups []*Group
+	err := tx.Bucket(bucketGroups).ForEach(func(k, v []byte) error {
+		group := &Group{}
+		if err := json.Unmarshal(v, group); err != nil {
+			return err
+		}
+		groups = append(groups, group)
+		return nil
+	})
+	sort.Slice(groups, func(i, j int) bool { return uidLess(groups[i].Uid, groups[j].Uid) })
+	return groups, err
+}
+
+func boltModuleComments(tx *bolt.Tx, module_uid string) ([]*Comment, error) {
+	var comments []*Comment
+	err := tx.Bucket(bucketComments).ForEach(func(k, v []byte) error {
+		comment := &Comment{}
+		if err := json.Unmarshal(v, comment); err != nil {
+			return err
+		}
+		if comment.ModuleUID == module_uid {
+			comments = append(comments, comment)
+		}
+		return nil
+	})
+	sortComments(comments)
+	return comments, err
+}
+
+// boltGroupByCode returns the group with the given join code, or nil.
+func boltGroupByCode(tx *bolt.Tx, code string) (*Group, error) {
+	groups, err := boltGroups(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		if group.Code == code {
+			return group, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *BoltStore) CreateGroup(group *Group) (*Group, error) {
+	if err := validateGroup(group); err != nil {
+		return nil, err
+	}
+
+	var new_group *Group
+	err := s.update(func(tx *bolt.Tx) error {
+		var code string
+		for i := 0; i < joinCodeAttempts && code == ""; i++ {
+			c, err := newJoinCode()
+			if err != nil {
+				return err
+			}
+			taken, err := boltGroupByCode(tx, c)
+			if err != nil {
+				return err
+			}
+			if taken == nil {
+				code = c
+			}
+		}
+		if code == "" {
+			return fmt.Errorf("no unused join code: %w", errConflict)
+		}
+
+		uid, err := boltNewUid(tx)
+		if err != nil {
+			return err
+		}
+		new_group = &Group{
+			Uid:        uid,
+			Name:       group.Name,
+			Teacher:    group.Teacher,
+			Members:    group.Members,
+			Code:       code,
+			DgraphType: "Group",
+		}
+		return boltPut(tx, bucketGroups, uid, new_group)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return new_group, nil
+}
+
+func (s *BoltStore) GetGroups() ([]*Group, error) {
+	var groups []*Group
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		groups, err = boltGroups(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (s *BoltStore) GetGroup(uid string) (*Group, error) {
+	group := &Group{}
+	err := s.view(func(tx *bolt.Tx) error {
+		return boltGet(tx, bucketGroups, uid, group)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *BoltStore) GroupByCode(code string) (*Group, error) {
+	var group *Group
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		group, err = boltGroupByCode(tx, code)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, fmt.Errorf("group code %s: %w", code, errNotFound)
+	}
+	return group, nil
+}
+
+func (s *BoltStore) AddGroupMember(group_uid string, username string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		group := &Group{}
+		if err := boltGet(tx, bucketGroups, group_uid, group); err != nil {
+			return err
+		}
+		if tx.Bucket(bucketUsers).Get([]byte(username)) == nil {
+			return fmt.Errorf("user %s: %w", username, errNotFound)
+		}
+		if hasString(group.Members, username) {
+			return nil
+		}
+		group.Members = append(group.Members, username)
+		return boltPut(tx, bucketGroups, group_uid, group)
+	})
+}
+
+func (s *BoltStore) CreateComment(comment *Comment) (string, error) {
+	if err := validateComment(comment); err != nil {
+		return "", err
+	}
+
+	var uid string
+	err := s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketModules).Get([]byte(comment.ModuleUID)) == nil {
+			return fmt.Errorf("module %s: %w", comment.ModuleUID, errNotFound)
+		}
+		var err error
+		if uid, err = boltNewUid(tx); err != nil {
+			return err
+		}
+		new_comment := *comment
+		new_comment.Uid = uid
+		new_comment.DgraphType = "Comment"
+		return boltPut(tx, bucketComments, uid, new_comment)
+	})
+	if err != nil {
+		return "", err
+	}
+	return uid, nil
+}
+
+func (s *BoltStore) ModuleComments(module_uid string) ([]*Comment, error) {
+	var comments []*Comment
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		comments, err = boltModuleComments(tx, module_uid)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (s *BoltStore) DeleteModule(uid string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketModules).Get([]byte(uid)) == nil {
+			return fmt.Errorf("module %s: %w", uid, errNotFound)
+		}
+		return boltDeleteModule(tx, uid)
+	})
+}
+
+func (s *BoltStore) ClearModule(uid string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketModules).Get([]byte(uid)) == nil {
+			return fmt.Errorf("module %s: %w", uid, errNotFound)
+		}
+		nodes, err := boltModuleNodes(tx, uid)
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes {
+			if err := boltDeleteNode(tx, node); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) ModuleOf(uid string) (*Module, error) {
+	module := &Module{}
+	err := s.view(func(tx *bolt.Tx) error {
+		module_uid := uid
+		if tx.Bucket(bucketModules).Get([]byte(uid)) == nil {
+			node_uid := uid
+			if parent := tx.Bucket(bucketParents).Get([]byte(uid)); parent != nil {
+				node_uid = string(parent)
+			}
+			node := &Node{}
+			if err := boltGet(tx, bucketNodes, node_uid, node); err != nil {
+				return fmt.Errorf("%s: %w", uid, errNotFound)
+			}
+			module_uid = node.ModuleUID
+		}
+		return boltGet(tx, bucketModules, module_uid, module)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return module, nil
+}
+
+func (s *BoltStore) CreateNode(node *Node) (Node, error) {
+	if err := validateNode(node); err != nil {
+		return Node{}, err
+	}
+
+	created := *node
+	err := s.update(func(tx *bolt.Tx) error {
+		parents := tx.Bucket(bucketParents)
+		uid, err := boltNewUid(tx)
+		if err != nil {
+			return err
+		}
+		created.Uid = uid
+		created.DgraphType = "Node"
+
+		if created.Data.Uid, err = boltNewUid(tx); err != nil {
+			return err
+		}
+		created.Data.DgraphType = "Data"
+		if err := parents.Put([]byte(created.Data.Uid), []byte(uid)); err != nil {
+			return err
+		}
+
+		inputs_outputs := make([]*InputOutput, 0, len(node.InputsOutputs))
+		for _, io := range node.InputsOutputs {
+			input_output := *io
+			if input_output.Uid, err = boltNewUid(tx); err != nil {
+				return err
+			}
+			input_output.DgraphType = "InputOutput"
+			connections := make([]*Connection, 0, len(io.Connections))
+			for _, c := range io.Connections {
+				connection := *c
+				if connection.Uid, err = boltNewUid(tx); err != nil {
+					return err
+				}
+				connection.DgraphType = "Connection"
+				if err := parents.Put([]byte(connection.Uid), []byte(uid)); err != nil {
+					return err
+				}
+				connections = append(connections, &connection)
+			}
+			input_output.Connections = connections
+			if err := parents.Put([]byte(input_output.Uid), []byte(uid)); err != nil {
+				return err
+			}
+			inputs_outputs = append(inputs_outputs, &input_output)
+		}
+		created.InputsOutputs = inputs_outputs
+
+		return boltPut(tx, bucketNodes, uid, created)
+	})
+	if err != nil {
+		return Node{}, err
+	}
+	return created, nil
+}
+
+// boltSaveNode writes a node document, points all its embedded uids at it
+// and forgets the removed ones.
+func boltSaveNode(tx *bolt.Tx, node *Node, removed []removedRef) error {
+	parents := tx.Bucket(bucketParents)
+	for _, uid := range nodeUids(node)[1:] {
+		if err := parents.Put([]byte(uid), []byte(node.Uid)); err != nil {
+			return err
+		}
+	}
+	for _, ref := range removed {
+		if err := parents.Delete([]byte(ref.Uid)); err != nil {
+			return err
+		}
+	}
+	return boltPut(tx, bucketNodes, node.Uid, node)
+}
+
+func (s *BoltStore) SaveModuleGraph(module_uid string, nodes []*Node) (*GraphDiff, error) {
+	diff := &GraphDiff{}
+	err := s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketModules).Get([]byte(module_uid)) == nil {
+			return errModuleNotFound
+		}
+		existing, err := boltModuleNodes(tx, module_uid)
+		if err != nil {
+			return err
+		}
+		newUid := func() (string, error) { return boltNewUid(tx) }
+
+		created, updated, deleted := diffGraph(existing, nodes)
+		for _, node := range deleted {
+			if err := boltDeleteNode(tx, node); err != nil {
+				return err
+			}
+		}
+		for _, node := range created {
+			uid, err := newUid()
+			if err != nil {
+				return err
+			}
+			merged, _, err := mergeNode(&Node{Uid: uid, ModuleUID: module_uid}, node, newUid)
+			if err != nil {
+				return err
+			}
+			if err := boltSaveNode(tx, merged, nil); err != nil {
+				return err
+			}
+		}
+		for _, pair := range updated {
+			merged, removed, err := mergeNode(pair[0], pair[1], newUid)
+			if err != nil {
+				return err
+			}
+			if err := boltSaveNode(tx, merged, removed); err != nil {
+				return err
+			}
+		}
+
+		diff.Created, diff.Updated, diff.Deleted = len(created), len(updated), len(deleted)
+		diff.Nodes, err = boltModuleNodes(tx, module_uid)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+func (s *BoltStore) ModuleGetNodes(module_uid string) ([]*Node, error) {
+	var nodes []*Node
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		nodes, err = boltModuleNodes(tx, module_uid)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// DeleteNode removes a node and the connections of the other nodes of the
+// module that pointed at it.
+func (s *BoltStore) DeleteNode(uid string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		node := &Node{}
+		if err := boltGet(tx, bucketNodes, uid, node); err != nil {
+			return err
+		}
+		nodes, err := boltModuleNodes(tx, node.ModuleUID)
+		if err != nil {
+			return err
+		}
+		if err := boltDeleteNode(tx, node); err != nil {
+			return err
+		}
+
+		refs := peerConnections(nodes, []*Node{node})
+		if len(refs) == 0 {
+			return nil
+		}
+		removeConnections(nodes, refs)
+		for _, peer := range nodes {
+			if peer.Uid == node.Uid {
+				continue
+			}
+			if err := boltSaveNode(tx, peer, refs); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) NodeUpdatePosition(node_uid string, pos_x float32, pos_y float32) error {
+	return s.update(func(tx *bolt.Tx) error {
+		node := &Node{}
+		if err := boltGet(tx, bucketNodes, node_uid, node); err != nil {
+			return err
+		}
+		node.PosX = pos_x
+		node.PosY = pos_y
+		return boltPut(tx, bucketNodes, node.Uid, node)
+	})
+}
+
+func (s *BoltStore) UpdateData(data *Data) error {
+	return s.update(func(tx *bolt.Tx) error {
+		node, err := boltNodeOf(tx, data.Uid)
+		if err != nil {
+			return err
+		}
+		if node.Data.Uid != data.Uid {
+			return fmt.Errorf("data %s: %w", data.Uid, errNotFound)
+		}
+		node.Data.Name = data.Name
+		node.Data.Value = data.Value
+		node.Data.Operator = data.Operator
+		return boltPut(tx, bucketNodes, node.Uid, node)
+	})
+}
+
+func (s *BoltStore) AddConnection(input_output_uid string, connection *Connection) (string, error) {
+	var uid string
+	err := s.update(func(tx *bolt.Tx) error {
+		node, err := boltNodeOf(tx, input_output_uid)
+		if err != nil {
+			return err
+		}
+		for _, input_output := range node.InputsOutputs {
+			if input_output.Uid != input_output_uid {
+				continue
+			}
+			if uid, err = boltNewUid(tx); err != nil {
+				return err
+			}
+			new_connection := *connection
+			new_connection.Uid = uid
+			new_connection.DgraphType = "Connection"
+			input_output.Connections = append(input_output.Connections, &new_connection)
+			if err := tx.Bucket(bucketParents).Put([]byte(uid), []byte(node.Uid)); err != nil {
+				return err
+			}
+			return boltPut(tx, bucketNodes, node.Uid, node)
+		}
+		// The uid may be the data or a connection of the node
+		return fmt.Errorf("input/output %s: %w", input_output_uid, errNotFound)
+	})
+	if err != nil {
+		return "", err
+	}
+	return uid, nil
+}
+
+func (s *BoltStore) DeleteConnection(parent_uid string, connection *Connection) error {
+	return s.update(func(tx *bolt.Tx) error {
+		node, err := boltNodeOf(tx, parent_uid)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, input_output := range node.InputsOutputs {
+			if input_output.Uid != parent_uid {
+				continue
+			}
+			connections := input_output.Connections[:0]
+			for _, c := range input_output.Connections {
+				if c.Uid == connection.Uid {
+					found = true
+					continue
+				}
+				connections = append(connections, c)
+			}
+			input_output.Connections = connections
+		}
+		// Only a connection of the port, whose owner was checked, may go
+		if !found {
+			return fmt.Errorf("connection %s of input/output %s: %w", connection.Uid, parent_uid, errNotFound)
+		}
+		if err := tx.Bucket(bucketParents).Delete([]byte(connection.Uid)); err != nil {
+			return err
+		}
+		return boltPut(tx, bucketNodes, node.Uid, node)
+	})
+}
+
+// CollectGarbage removes the nodes of modules that no longer exist, the
+// connections pointing at missing nodes and the connections that were
+// created but never attached to a port.
+func (s *BoltStore) CollectGarbage(dryRun bool) (*GCReport, error) {
+	report := &GCReport{DryRun: dryRun}
+	err := s.update(func(tx *bolt.Tx) error {
+		modules := make(map[string]bool)
+		if err := tx.Bucket(bucketModules).ForEach(func(k, v []byte) error {
+			modules[string(k)] = true
+			return nil
+		}); err != nil {
+			return err
+		}
+		var nodes []*Node
+		if err := tx.Bucket(bucketNodes).ForEach(func(k, v []byte) error {
+			node := &Node{}
+			if err := json.Unmarshal(v, node); err != nil {
+				return err
+			}
+			nodes = append(nodes, node)
+			return nil
+		}); err != nil {
+			return err
+		}
+		var pending []string
+		if err := tx.Bucket(bucketConnections).ForEach(func(k, v []byte) error {
+			pending = append(pending, string(k))
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		orphans, dangling := gcScanNodes(modules, nodes)
+		for _, node := range orphans {
+			report.OrphanNodes = append(report.OrphanNodes, node.Uid)
+		}
+		report.OrphanConnections = pending
+		for _, ref := range dangling {
+			report.DanglingConnections = append(report.DanglingConnections, ref.Uid)
+		}
+		now := time.Now()
+		expired, err := boltSessions(tx, func(session *Session) bool { return session.ExpiresAt.Before(now) })
+		if err != nil {
+			return err
+		}
+		for _, session := range expired {
+			report.ExpiredSessions = append(report.ExpiredSessions, session.Uid)
+		}
+		report.sortUids()
+		if dryRun {
+			return nil
+		}
+
+		for _, uid := range report.ExpiredSessions {
+			if err := tx.Bucket(bucketSessions).Delete([]byte(uid)); err != nil {
+				return err
+			}
+		}
+
+		for _, node := range orphans {
+			if err := boltDeleteNode(tx, node); err != nil {
+				return err
+			}
+		}
+		for _, uid := range pending {
+			if err := tx.Bucket(bucketConnections).Delete([]byte(uid)); err != nil {
+				return err
+			}
+		}
+		if len(dangling) > 0 {
+			removeConnections(nodes, dangling)
+			for _, node := range nodes {
+				if !modules[node.ModuleUID] {
+					continue
+				}
+				if err := boltSaveNode(tx, node, dangling); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
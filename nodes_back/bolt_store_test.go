@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// openBolt opens the store at path and closes it after the test.
+func openBolt(t *testing.T, path string) *BoltStore {
+	t.Helper()
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBoltStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return openBolt(t, filepath.Join(t.TempDir(), "nodes.db"))
+	})
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.db")
+	s := openBolt(t, path)
+	module_uid, first, _ := twoNodes(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openBolt(t, path)
+	if module, err := s.ModuleOf(first.InputsOutputs[0].Connections[0].Uid); err != nil || module.Uid != module_uid {
+		t.Errorf("ModuleOf a connection after reopening = %v, %v, want module %s", module, err, module_uid)
+	}
+	// The uid counter survives, new objects don't reuse uids
+	second_module, err := s.CreateModule(&Module{Name: "loops", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range append([]string{module_uid}, nodeUids(&first)...) {
+		if uid == second_module {
+			t.Errorf("the new module got the uid %s of an existing object", uid)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runMigrate is the "migrate" subcommand: it applies the pending schema
+// migrations and reports the schema version before and after.
+func runMigrate(store Store) error {
+	m, ok := store.(Migrator)
+	if !ok {
+		fmt.Printf("The %s store has no schema to migrate\n", config.Store)
+		return nil
+	}
+
+	before, err := m.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Current schema version: %d (latest %d)\n", before, m.LatestSchemaVersion())
+	if before >= m.LatestSchemaVersion() {
+		return nil
+	}
+
+	if err := m.Migrate(); err != nil {
+		return err
+	}
+	after, err := m.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Migrated schema to version %d\n", after)
+	return nil
+}
+
+// runGC is the "gc" subcommand. With -dry-run it only prints what would be
+// removed.
+func runGC(store Store, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report the garbage without removing it")
+	fs.Parse(args)
+
+	report, err := store.CollectGarbage(*dryRun)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("%d objects would be removed\n", report.Total())
+	} else {
+		fmt.Printf("%d objects removed\n", report.Total())
+	}
+	return nil
+}
+
+// runRole is the "role" subcommand, e.g. "role ana admin". It is how the
+// first admin is appointed, since registering always makes a student.
+func runRole(store Store, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: role <username> <student|teacher|admin>")
+	}
+	username, role := args[0], args[1]
+	if !validRole(role) {
+		return fmt.Errorf("unknown role %q, use student, teacher or admin", role)
+	}
+	if err := store.UpdateRole(username, role); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", username, role)
+	return nil
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds the server settings. They are read, in increasing order of
+// precedence, from the defaults below, the YAML file given with -config (or
+// NODES_CONFIG), NODES_* environment variables and command line flags.
+type Config struct {
+	Listen           string        `yaml:"listen"`
+	Store            string        `yaml:"store"`
+	DBPath           string        `yaml:"db"`
+	Dgraph           DgraphConfig  `yaml:"dgraph"`
+	CORSOrigins      []string      `yaml:"cors_origins"`
+	RequestTimeout   time.Duration `yaml:"request_timeout"`
+	ReadTimeout      time.Duration `yaml:"read_timeout"`
+	WriteTimeout     time.Duration `yaml:"write_timeout"`
+	IdleTimeout      time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout  time.Duration `yaml:"shutdown_timeout"`
+	GCInterval       time.Duration `yaml:"gc_interval"`
+	AuthSecret       string        `yaml:"auth_secret"`
+	TokenTTL         time.Duration `yaml:"token_ttl"`
+	RefreshTTL       time.Duration `yaml:"refresh_ttl"`
+	LoginIPLimit     int           `yaml:"login_ip_limit"`
+	LoginMaxFailures int           `yaml:"login_max_failures"`
+	LoginLockout     time.Duration `yaml:"login_lockout"`
+	AuditLog         string        `yaml:"audit_log"`
+	OIDC             OIDCConfig    `yaml:"oidc"`
+	Run              RunConfig     `yaml:"run"`
+	LogLevel         string        `yaml:"log_level"`
+}
+
+type DgraphConfig struct {
+	Addr     string `yaml:"addr"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	PoolSize int    `yaml:"pool_size"`
+}
+
+// OIDCConfig enables the login with an OpenID Connect provider when Issuer
+// is set.
+type OIDCConfig struct {
+	Issuer        string            `yaml:"issuer"`
+	ClientID      string            `yaml:"client_id"`
+	ClientSecret  string            `yaml:"client_secret"`
+	RedirectURL   string            `yaml:"redirect_url"`
+	Scopes        []string          `yaml:"scopes"`
+	UsernameClaim string            `yaml:"username_claim"`
+	Domains       []string          `yaml:"domains"` // allowed after the @ of the username claim
+	RoleClaim     string            `yaml:"role_claim"`
+	Roles         map[string]string `yaml:"roles"` // value of the role claim -> role
+	FrontendURL   string            `yaml:"frontend_url"`
+}
+
+// RunConfig limits the programs the server runs for the students, which may
+// well loop forever.
+type RunConfig struct {
+	MaxSteps     int           `yaml:"max_steps"`
+	Timeout      time.Duration `yaml:"timeout"`
+	MaxValueBits int           `yaml:"max_value_bits"`
+	MaxOutput    int           `yaml:"max_output"`
+	DebugTTL     time.Duration `yaml:"debug_ttl"`
+}
+
+// setting is one config value that can be given as a flag or an environment
+// variable. def is also the default of the Config.
+type setting struct {
+	flag  string
+	env   string
+	def   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"listen", "NODES_LISTEN", ":3333", "address the HTTP server listens on",
+		func(c *Config, v string) error { c.Listen = v; return nil }},
+	{"store", "NODES_STORE", "bolt", "storage backend: bolt (embedded file), dgraph or memory (demo, reset on restart)",
+		func(c *Config, v string) error { c.Store = v; return nil }},
+	{"db", "NODES_DB", "nodes.db", "database file used by the bolt store",
+		func(c *Config, v string) error { c.DBPath = v; return nil }},
+	{"dgraph-addr", "NODES_DGRAPH_ADDR", "127.0.0.1:9080", "gRPC address of the Dgraph alpha",
+		func(c *Config, v string) error { c.Dgraph.Addr = v; return nil }},
+	{"dgraph-user", "NODES_DGRAPH_USER", "groot", "Dgraph user",
+		func(c *Config, v string) error { c.Dgraph.User = v; return nil }},
+	{"dgraph-password", "NODES_DGRAPH_PASSWORD", "password", "Dgraph password",
+		func(c *Config, v string) error { c.Dgraph.Password = v; return nil }},
+	{"dgraph-pool-size", "NODES_DGRAPH_POOL_SIZE", "4", "number of gRPC connections to Dgraph shared by all requests",
+		func(c *Config, v string) (err error) { c.Dgraph.PoolSize, err = strconv.Atoi(v); return err }},
+	{"cors-origins", "NODES_CORS_ORIGINS", "https://*,http://*", "comma separated origins allowed by CORS",
+		func(c *Config, v string) error { c.CORSOrigins = splitList(v); return nil }},
+	{"request-timeout", "NODES_REQUEST_TIMEOUT", "60s", "maximum time a request may take",
+		func(c *Config, v string) (err error) { c.RequestTimeout, err = time.ParseDuration(v); return err }},
+	{"read-timeout", "NODES_READ_TIMEOUT", "15s", "maximum time to read a request, body included",
+		func(c *Config, v string) (err error) { c.ReadTimeout, err = time.ParseDuration(v); return err }},
+	{"write-timeout", "NODES_WRITE_TIMEOUT", "75s", "maximum time to write a response, it should exceed the request timeout",
+		func(c *Config, v string) (err error) { c.WriteTimeout, err = time.ParseDuration(v); return err }},
+	{"idle-timeout", "NODES_IDLE_TIMEOUT", "120s", "how long keep-alive connections wait for the next request",
+		func(c *Config, v string) (err error) { c.IdleTimeout, err = time.ParseDuration(v); return err }},
+	{"shutdown-timeout", "NODES_SHUTDOWN_TIMEOUT", "30s", "how long to wait for requests in flight on SIGTERM or SIGINT",
+		func(c *Config, v string) (err error) { c.ShutdownTimeout, err = time.ParseDuration(v); return err }},
+	{"gc-interval", "NODES_GC_INTERVAL", "0s", "run the garbage collector periodically, e.g. 1h (0 disables it)",
+		func(c *Config, v string) (err error) { c.GCInterval, err = time.ParseDuration(v); return err }},
+	{"auth-secret", "NODES_AUTH_SECRET", "", "key signing the login tokens, at least 32 characters (random if empty, so tokens end on restart)",
+		func(c *Config, v string) error { c.AuthSecret = v; return nil }},
+	{"token-ttl", "NODES_TOKEN_TTL", "15m", "how long an access token is valid",
+		func(c *Config, v string) (err error) { c.TokenTTL, err = time.ParseDuration(v); return err }},
+	{"refresh-ttl", "NODES_REFRESH_TTL", "720h", "how long a session lasts without using its refresh token",
+		func(c *Config, v string) (err error) { c.RefreshTTL, err = time.ParseDuration(v); return err }},
+	{"login-ip-limit", "NODES_LOGIN_IP_LIMIT", "20", "login attempts allowed per minute from one IP",
+		func(c *Config, v string) (err error) { c.LoginIPLimit, err = strconv.Atoi(v); return err }},
+	{"login-max-failures", "NODES_LOGIN_MAX_FAILURES", "5", "failed logins in a row that lock a username",
+		func(c *Config, v string) (err error) { c.LoginMaxFailures, err = strconv.Atoi(v); return err }},
+	{"login-lockout", "NODES_LOGIN_LOCKOUT", "15m", "how long a username stays locked after too many failed logins",
+		func(c *Config, v string) (err error) { c.LoginLockout, err = time.ParseDuration(v); return err }},
+	{"audit-log", "NODES_AUDIT_LOG", "", "file the failed logins are appended to (standard error if empty)",
+		func(c *Config, v string) error { c.AuditLog = v; return nil }},
+	{"oidc-issuer", "NODES_OIDC_ISSUER", "", "OpenID Connect issuer URL; enables the login with that provider",
+		func(c *Config, v string) error { c.OIDC.Issuer = v; return nil }},
+	{"oidc-client-id", "NODES_OIDC_CLIENT_ID", "", "client ID registered at the OpenID provider",
+		func(c *Config, v string) error { c.OIDC.ClientID = v; return nil }},
+	{"oidc-client-secret", "NODES_OIDC_CLIENT_SECRET", "", "client secret registered at the OpenID provider",
+		func(c *Config, v string) error { c.OIDC.ClientSecret = v; return nil }},
+	{"oidc-redirect-url", "NODES_OIDC_REDIRECT_URL", "http://localhost:3333/user/oidc/callback", "callback URL registered at the OpenID provider",
+		func(c *Config, v string) error { c.OIDC.RedirectURL = v; return nil }},
+	{"oidc-scopes", "NODES_OIDC_SCOPES", "openid,profile,email", "comma separated scopes asked to the OpenID provider",
+		func(c *Config, v string) error { c.OIDC.Scopes = splitList(v); return nil }},
+	{"oidc-username-claim", "NODES_OIDC_USERNAME_CLAIM", "preferred_username", "ID token claim used as username (for email, the part before @)",
+		func(c *Config, v string) error { c.OIDC.UsernameClaim = v; return nil }},
+	{"oidc-domains", "NODES_OIDC_DOMAINS", "", "comma separated domains an email username claim may have, e.g. school.edu (none if empty)",
+		func(c *Config, v string) error { c.OIDC.Domains = splitList(v); return nil }},
+	{"oidc-role-claim", "NODES_OIDC_ROLE_CLAIM", "", "ID token claim mapped onto the role, e.g. groups (roles are managed locally if empty)",
+		func(c *Config, v string) error { c.OIDC.RoleClaim = v; return nil }},
+	{"oidc-roles", "NODES_OIDC_ROLES", "", "comma separated value=role pairs of the role claim, e.g. teachers=teacher,staff=admin",
+		func(c *Config, v string) (err error) { c.OIDC.Roles, err = splitMap(v); return err }},
+	{"oidc-frontend-url", "NODES_OIDC_FRONTEND_URL", "http://localhost:8080/login", "page the browser returns to after the OpenID login, with the tokens in the fragment",
+		func(c *Config, v string) error { c.OIDC.FrontendURL = v; return nil }},
+	{"run-max-steps", "NODES_RUN_MAX_STEPS", "1000000", "instructions a program run on the server may execute",
+		func(c *Config, v string) (err error) { c.Run.MaxSteps, err = strconv.Atoi(v); return err }},
+	{"run-timeout", "NODES_RUN_TIMEOUT", "5s", "how long a program run on the server may take",
+		func(c *Config, v string) (err error) { c.Run.Timeout, err = time.ParseDuration(v); return err }},
+	{"run-max-value-bits", "NODES_RUN_MAX_VALUE_BITS", "65536", "size in bits of the largest integer a program run on the server may hold",
+		func(c *Config, v string) (err error) { c.Run.MaxValueBits, err = strconv.Atoi(v); return err }},
+	{"run-max-output", "NODES_RUN_MAX_OUTPUT", "1048576", "bytes a program run on the server may print",
+		func(c *Config, v string) (err error) { c.Run.MaxOutput, err = strconv.Atoi(v); return err }},
+	{"run-debug-ttl", "NODES_RUN_DEBUG_TTL", "10m", "how long a debugging session lasts without being used",
+		func(c *Config, v string) (err error) { c.Run.DebugTTL, err = time.ParseDuration(v); return err }},
+	{"log-level", "NODES_LOG_LEVEL", "info", "debug, info, warn or error",
+		func(c *Config, v string) error { c.LogLevel = v; return nil }},
+}
+
+// config is the Config loaded in main().
+var config *Config
+
+var configPath = flag.String("config", "", "YAML config file (env NODES_CONFIG)")
+
+func init() {
+	for _, s := range settings {
+		flag.String(s.flag, s.def, s.usage+" (env "+s.env+")")
+	}
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// splitMap parses "key=value,key=value".
+func splitMap(v string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, item := range splitList(v) {
+		i := strings.IndexByte(item, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not a key=value pair", item)
+		}
+		m[strings.TrimSpace(item[:i])] = strings.TrimSpace(item[i+1:])
+	}
+	return m, nil
+}
+
+func defaultConfig() *Config {
+	c := &Config{}
+	for _, s := range settings {
+		if err := s.set(c, s.def); err != nil {
+			panic(fmt.Sprintf("default of -%s: %v", s.flag, err))
+		}
+	}
+	return c
+}
+
+// loadConfig builds the Config once the flags have been parsed.
+func loadConfig() (*Config, error) {
+	c := defaultConfig()
+
+	path := *configPath
+	if path == "" {
+		path = os.Getenv("NODES_CONFIG")
+	}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(b, c); err != nil {
+			return nil, fmt.Errorf("parsing config %s: %w", path, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(c, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if e := s.set(c, f.Value.String()); e != nil {
+					err = fmt.Errorf("-%s: %w", s.flag, e)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	logLevel = logLevels[c.LogLevel]
+
+	if c.AuthSecret == "" {
+		secret := make([]byte, minAuthSecret)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		c.AuthSecret = hex.EncodeToString(secret)
+		warnf("No auth secret configured, using a random one: login tokens won't survive a restart")
+	}
+	return c, nil
+}
+
+const minAuthSecret = 32
+
+// Validate checks the settings so a bad value stops the server at startup
+// rather than on the first request.
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("listen %q: %w", c.Listen, err)
+	}
+
+	switch c.Store {
+	case "bolt":
+		if c.DBPath == "" {
+			return errors.New("db path is required by the bolt store")
+		}
+	case "dgraph":
+		if _, _, err := net.SplitHostPort(c.Dgraph.Addr); err != nil {
+			return fmt.Errorf("dgraph addr %q: %w", c.Dgraph.Addr, err)
+		}
+		if c.Dgraph.PoolSize < 1 {
+			return fmt.Errorf("dgraph pool size must be at least 1, got %d", c.Dgraph.PoolSize)
+		}
+	case "memory":
+	default:
+		return fmt.Errorf("unknown store %q, use bolt, dgraph or memory", c.Store)
+	}
+
+	if len(c.CORSOrigins) == 0 {
+		return errors.New("at least one CORS origin is required")
+	}
+	for name, d := range map[string]time.Duration{
+		"request":  c.RequestTimeout,
+		"read":     c.ReadTimeout,
+		"write":    c.WriteTimeout,
+		"idle":     c.IdleTimeout,
+		"shutdown": c.ShutdownTimeout,
+		"token":    c.TokenTTL,
+		"refresh":  c.RefreshTTL,
+		"lockout":  c.LoginLockout,
+		"run":      c.Run.Timeout,
+		"debug":    c.Run.DebugTTL,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%s timeout must be positive, got %v", name, d)
+		}
+	}
+	if c.GCInterval < 0 {
+		return fmt.Errorf("gc interval can't be negative, got %v", c.GCInterval)
+	}
+	if c.LoginIPLimit < 1 {
+		return fmt.Errorf("login ip limit must be at least 1, got %d", c.LoginIPLimit)
+	}
+	if c.LoginMaxFailures < 1 {
+		return fmt.Errorf("login max failures must be at least 1, got %d", c.LoginMaxFailures)
+	}
+	if c.AuthSecret != "" && len(c.AuthSecret) < minAuthSecret {
+		return fmt.Errorf("auth secret must be at least %d characters", minAuthSecret)
+	}
+	if err := c.OIDC.Validate(); err != nil {
+		return fmt.Errorf("oidc: %w", err)
+	}
+	for name, n := range map[string]int{
+		"max steps":      c.Run.MaxSteps,
+		"max value bits": c.Run.MaxValueBits,
+		"max output":     c.Run.MaxOutput,
+	} {
+		if n < 1 {
+			return fmt.Errorf("run %s must be at least 1, got %d", name, n)
+		}
+	}
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		return fmt.Errorf("unknown log level %q, use debug, info, warn or error", c.LogLevel)
+	}
+	return nil
+}
+
+// Validate checks the OIDC settings when the provider is enabled.
+func (c *OIDCConfig) Validate() error {
+	if c.Issuer == "" {
+		return nil
+	}
+	if _, err := url.ParseRequestURI(c.Issuer); err != nil {
+		return fmt.Errorf("issuer: %w", err)
+	}
+	if c.ClientID == "" {
+		return errors.New("client id is required")
+	}
+	if _, err := url.ParseRequestURI(c.RedirectURL); err != nil {
+		return fmt.Errorf("redirect url: %w", err)
+	}
+	if c.FrontendURL != "" {
+		if _, err := url.ParseRequestURI(c.FrontendURL); err != nil {
+			return fmt.Errorf("frontend url: %w", err)
+		}
+	}
+	if c.UsernameClaim == "" {
+		return errors.New("username claim is required")
+	}
+	for value, role := range c.Roles {
+		if !validRole(role) {
+			return fmt.Errorf("role %q of %q, use student, teacher or admin", role, value)
+		}
+	}
+	return nil
+}
+
+/***************** Log level ******************/
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]int{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// logLevel is set by loadConfig(). Every log line goes through logf, so
+// lines below the level are dropped; at warn and above the request log is
+// off too. Fatal errors are always logged.
+var logLevel = levelInfo
+
+func logf(level int, format string, v ...interface{}) {
+	if logLevel <= level {
+		log.Printf(format, v...)
+	}
+}
+
+func debugf(format string, v ...interface{}) { logf(levelDebug, format, v...) }
+func infof(format string, v ...interface{})  { logf(levelInfo, format, v...) }
+func warnf(format string, v ...interface{})  { logf(levelWarn, format, v...) }
+func errorf(format string, v ...interface{}) { logf(levelError, format, v...) }
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"nodes/web-service-gin/program"
+)
+
+// maxDebugSessions is how many debugging sessions a user may have open;
+// starting one more ends the least recently used.
+const maxDebugSessions = 5
+
+// debugSweepInterval is how often expired sessions are looked for.
+const debugSweepInterval = time.Minute
+
+// debugSession runs a module node by node for the user who started it.
+// Its mutex keeps two requests from driving the machine at once.
+type debugSession struct {
+	mu          sync.Mutex
+	id          string
+	username    string
+	moduleUID   string
+	machine     *program.Machine
+	breakpoints map[int]bool
+	lastUsed    time.Time
+}
+
+// debugSessions holds the debugging sessions in memory, so they are per
+// process and end on restart, and drops those unused for ttl.
+type debugSessions struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	sessions  map[string]*debugSession
+	lastSweep time.Time
+}
+
+func newDebugSessions(c *Config) *debugSessions {
+	return &debugSessions{
+		ttl:      c.Run.DebugTTL,
+		sessions: make(map[string]*debugSession),
+	}
+}
+
+// debugger holds the sessions of the server, set up in main().
+var debugger *debugSessions
+
+// start opens a session on a compiled module.
+func (d *debugSessions) start(username string, module_uid string, machine *program.Machine) (*debugSession, error) {
+	id, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	s := &debugSession{
+		id:          id,
+		username:    username,
+		moduleUID:   module_uid,
+		machine:     machine,
+		breakpoints: map[int]bool{},
+		lastUsed:    time.Now(),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(s.lastUsed)
+	var mine []*debugSession
+	for _, other := range d.sessions {
+		if other.username == username {
+			mine = append(mine, other)
+		}
+	}
+	if len(mine) >= maxDebugSessions {
+		sort.Slice(mine, func(i, j int) bool { return mine[i].lastUsed.Before(mine[j].lastUsed) })
+		for _, old := range mine[:len(mine)-maxDebugSessions+1] {
+			delete(d.sessions, old.id)
+		}
+	}
+	d.sessions[id] = s
+	return s, nil
+}
+
+// get returns a session of username on the module and marks it used.
+// Sessions of other users are not found.
+func (d *debugSessions) get(id string, username string, module_uid string) (*debugSession, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.sweep(now)
+	s, ok := d.sessions[id]
+	if !ok || s.username != username || s.moduleUID != module_uid || now.Sub(s.lastUsed) >= d.ttl {
+		return nil, fmt.Errorf("debug session %s: %w", id, errNotFound)
+	}
+	s.lastUsed = now
+	return s, nil
+}
+
+// end closes a session.
+func (d *debugSessions) end(s *debugSession) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.sessions, s.id)
+}
+
+// sweep drops the expired sessions, at most once per interval. The caller
+// holds d.mu.
+func (d *debugSessions) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < debugSweepInterval {
+		return
+	}
+	d.lastSweep = now
+	for id, s := range d.sessions {
+		if now.Sub(s.lastUsed) >= d.ttl {
+			delete(d.sessions, id)
+		}
+	}
+}
+
+type BreakpointsRequest struct {
+	Breakpoints []int `json:"breakpoints"`
+}
+
+func (a *BreakpointsRequest) Bind(r *http.Request) error {
+	if a.Breakpoints == nil {
+		return errors.New("missing required breakpoints, [] clears them.")
+	}
+	return nil
+}
+
+// DebugResponse is the state of a session: the node about to run, the
+// variables and what was printed so far.
+type DebugResponse struct {
+	Session     string `json:"session"`
+	ModuleUID   string `json:"module_uid"`
+	Current     int    `json:"current,omitempty"`
+	Done        bool   `json:"done"`
+	Breakpoints []int  `json:"breakpoints"`
+	// Nodes are the nodes that can be current, and so take a breakpoint.
+	Nodes []int `json:"nodes"`
+	*program.Result
+}
+
+// NewDebugResponse describes s. The caller holds s.mu.
+func NewDebugResponse(s *debugSession, result *program.Result) *DebugResponse {
+	breakpoints := []int{}
+	for node := range s.breakpoints {
+		breakpoints = append(breakpoints, node)
+	}
+	sort.Ints(breakpoints)
+	return &DebugResponse{
+		Session:     s.id,
+		ModuleUID:   s.moduleUID,
+		Current:     s.machine.Node(),
+		Done:        s.machine.Done(),
+		Breakpoints: breakpoints,
+		Nodes:       s.machine.Nodes(),
+		Result:      result,
+	}
+}
+
+func (rd *DebugResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	return nil
+}
+
+// StartDebug opens a debugging session on the graph of a module, stopped
+// before its first node.
+func StartDebug(w http.ResponseWriter, r *http.Request) {
+	module_uid := chi.URLParam(r, "moduleUID")
+	nodes, err := moduleProgram(module_uid)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	machine, err := program.Compile(nodes, runLimits())
+	if err != nil {
+		render.Render(w, r, ErrStore(programError(err)))
+		return
+	}
+	s, err := debugger.start(authUser(r).Username, module_uid, machine)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, NewDebugResponse(s, machine.Result()))
+}
+
+// DebugSessionCtx loads the session of the {sessionID} of the route, which
+// must belong to the authenticated user and the module, and keeps it locked
+// while the request runs.
+func DebugSessionCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := debugger.get(chi.URLParam(r, "sessionID"), authUser(r).Username, chi.URLParam(r, "moduleUID"))
+		if err != nil {
+			render.Render(w, r, ErrStore(err))
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), debugSessionCtxKey, s)))
+	})
+}
+
+// requestDebugSession returns the session set by DebugSessionCtx.
+func requestDebugSession(r *http.Request) *debugSession {
+	s, _ := r.Context().Value(debugSessionCtxKey).(*debugSession)
+	return s
+}
+
+// GetDebug returns the state of a session.
+func GetDebug(w http.ResponseWriter, r *http.Request) {
+	s := requestDebugSession(r)
+	render.Render(w, r, NewDebugResponse(s, s.machine.Result()))
+}
+
+// SetBreakpoints replaces the breakpoints of a session. They are node ids,
+// among the nodes of the response.
+func SetBreakpoints(w http.ResponseWriter, r *http.Request) {
+	data := &BreakpointsRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	s := requestDebugSession(r)
+
+	runs := map[int]bool{}
+	for _, node := range s.machine.Nodes() {
+		runs[node] = true
+	}
+	breakpoints := map[int]bool{}
+	for _, node := range data.Breakpoints {
+		if !runs[node] {
+			render.Render(w, r, ErrStore(fmt.Errorf("node %d runs no code, it can't take a breakpoint: %w", node, errValidation)))
+			return
+		}
+		breakpoints[node] = true
+	}
+	s.breakpoints = breakpoints
+
+	render.Render(w, r, NewDebugResponse(s, s.machine.Result()))
+}
+
+// StepDebug runs the current node of a session.
+func StepDebug(w http.ResponseWriter, r *http.Request) {
+	s := requestDebugSession(r)
+	render.Render(w, r, NewDebugResponse(s, s.machine.Step(r.Context())))
+}
+
+// ContinueDebug runs a session up to the next breakpoint or the end.
+func ContinueDebug(w http.ResponseWriter, r *http.Request) {
+	s := requestDebugSession(r)
+	render.Render(w, r, NewDebugResponse(s, s.machine.Continue(r.Context(), s.breakpoints)))
+}
+
+// EndDebug closes a session and returns its last state.
+func EndDebug(w http.ResponseWriter, r *http.Request) {
+	s := requestDebugSession(r)
+	debugger.end(s)
+
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, NewDebugResponse(s, s.machine.Result()))
+}
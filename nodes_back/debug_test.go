@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestDebugSessions(t *testing.T) {
+	d := newDebugSessions(&Config{Run: RunConfig{DebugTTL: time.Minute}})
+
+	s, err := d.start("alice", "0x1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.get(s.id, "alice", "0x1"); err != nil || got != s {
+		t.Errorf("get by alice = %v, %v, want her session", got, err)
+	}
+	for name, args := range map[string][3]string{
+		"another user":   {s.id, "bob", "0x1"},
+		"another module": {s.id, "alice", "0x2"},
+		"unknown id":     {"nope", "alice", "0x1"},
+	} {
+		if _, err := d.get(args[0], args[1], args[2]); !errors.Is(err, errNotFound) {
+			t.Errorf("get of %s = %v, want not found", name, err)
+		}
+	}
+
+	d.end(s)
+	if _, err := d.get(s.id, "alice", "0x1"); !errors.Is(err, errNotFound) {
+		t.Errorf("get of an ended session = %v, want not found", err)
+	}
+}
+
+func TestDebugSessionsExpire(t *testing.T) {
+	d := newDebugSessions(&Config{Run: RunConfig{DebugTTL: time.Minute}})
+	old, _ := d.start("alice", "0x1", nil)
+	recent, _ := d.start("alice", "0x1", nil)
+
+	old.lastUsed = time.Now().Add(-time.Minute)
+	if _, err := d.get(old.id, "alice", "0x1"); !errors.Is(err, errNotFound) {
+		t.Errorf("get of an expired session = %v, want not found", err)
+	}
+
+	// The sweep drops it from memory
+	d.lastSweep = time.Now().Add(-debugSweepInterval)
+	if _, err := d.get(recent.id, "alice", "0x1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.sessions[old.id]; ok {
+		t.Error("the expired session is still in memory after a sweep")
+	}
+	if _, ok := d.sessions[recent.id]; !ok {
+		t.Error("the sweep dropped a session in use")
+	}
+}
+
+func TestDebugSessionsLimit(t *testing.T) {
+	d := newDebugSessions(&Config{Run: RunConfig{DebugTTL: time.Hour}})
+	bobs, _ := d.start("bob", "0x2", nil)
+
+	var alices []*debugSession
+	for i := 0; i < maxDebugSessions; i++ {
+		s, err := d.start("alice", "0x1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.lastUsed = time.Now().Add(time.Duration(i-maxDebugSessions) * time.Second)
+		alices = append(alices, s)
+	}
+	// The least recently used goes, not the oldest
+	alices[0].lastUsed = time.Now()
+
+	if _, err := d.start("alice", "0x1", nil); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range alices {
+		_, err := d.get(s.id, "alice", "0x1")
+		if evicted := errors.Is(err, errNotFound); evicted != (i == 1) {
+			t.Errorf("session %d: get = %v, want only session 1 ended", i, err)
+		}
+	}
+	if _, err := d.get(bobs.id, "bob", "0x2"); err != nil {
+		t.Errorf("bob's session ended with alice's: %v", err)
+	}
+}
+
+// debugRouter serves the debugging routes of main() to user.
+func debugRouter(user *User) http.Handler {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userCtxKey, user)))
+		})
+	})
+	r.Route("/modules/{moduleUID}", func(r chi.Router) {
+		r.Post("/debug", StartDebug)
+		r.Route("/debug/{sessionID}", func(r chi.Router) {
+			r.Use(DebugSessionCtx)
+			r.Get("/", GetDebug)
+			r.Put("/breakpoints", SetBreakpoints)
+			r.Post("/step", StepDebug)
+			r.Post("/continue", ContinueDebug)
+			r.Delete("/", EndDebug)
+		})
+	})
+	return r
+}
+
+// debugState is the part of a DebugResponse the tests look at; the values
+// of the variables don't decode back into a program.Value.
+type debugState struct {
+	Session     string   `json:"session"`
+	Current     int      `json:"current"`
+	Done        bool     `json:"done"`
+	Breakpoints []int    `json:"breakpoints"`
+	Output      []string `json:"output"`
+}
+
+// debugRequest sends a request through debugRouter and decodes the state of
+// the session it answers with.
+func debugRequest(t *testing.T, user *User, method string, path string, body string) (int, *debugState) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	debugRouter(user).ServeHTTP(w, r)
+	resp := &debugState{}
+	if w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+			t.Fatalf("%s %s: %v: %s", method, path, err, w.Body)
+		}
+	}
+	return w.Code, resp
+}
+
+func TestDebugHandlers(t *testing.T) {
+	s := useMemoryStore(t)
+	config.Run.DebugTTL = time.Minute
+	debugger = newDebugSessions(config)
+	t.Cleanup(func() { debugger = nil })
+	alice, bob := &User{Username: "alice"}, &User{Username: "bob"}
+
+	// x = 5, then y = 7
+	module_uid, err := s.CreateModule(&Module{Name: "debug", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var graph []*Node
+	for _, n := range []struct {
+		id          int
+		value, name string
+	}{{1, "5", "x"}, {3, "7", "y"}} {
+		graph = append(graph,
+			&Node{Id: n.id, Name: "number", Data: Data{Value: n.value}, InputsOutputs: []*InputOutput{
+				testPort("", "output_1", "output", testConnection("", strconv.Itoa(n.id+1), "input_1")),
+			}},
+			&Node{Id: n.id + 1, Name: "assign", Data: Data{Name: n.name}, InputsOutputs: []*InputOutput{
+				testPort("", "input_1", "input", testConnection("", strconv.Itoa(n.id), "output_1")),
+			}},
+		)
+	}
+	if _, err := s.SaveModuleGraph(module_uid, graph); err != nil {
+		t.Fatal(err)
+	}
+
+	base := "/modules/" + module_uid + "/debug"
+	code, state := debugRequest(t, alice, "POST", base, "")
+	if code != http.StatusCreated || state.Session == "" || state.Current != 1 || state.Done {
+		t.Fatalf("start: status %d, %+v, want a session at node 1", code, state)
+	}
+	session := base + "/" + state.Session + "/"
+
+	if code, _ := debugRequest(t, bob, "GET", session, ""); code != http.StatusNotFound {
+		t.Errorf("bob getting alice's session: status %d, want 404", code)
+	}
+	if code, _ := debugRequest(t, alice, "PUT", session+"breakpoints", `{"breakpoints": [9]}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("breakpoint on a missing node: status %d, want 422", code)
+	}
+
+	steps := []struct {
+		method, path, body string
+		current            int
+		output             []string
+	}{
+		{"PUT", "breakpoints", `{"breakpoints": [3]}`, 1, []string{}},
+		{"POST", "step", "", 2, []string{}},
+		{"POST", "continue", "", 3, []string{"5"}},
+		{"GET", "", "", 3, []string{"5"}},
+		{"POST", "continue", "", 0, []string{"5", "7"}},
+	}
+	for _, step := range steps {
+		code, state := debugRequest(t, alice, step.method, session+step.path, step.body)
+		if code != http.StatusOK || state.Current != step.current || !reflect.DeepEqual(state.Output, step.output) {
+			t.Errorf("%s %s: status %d, at node %d with output %q, want node %d with %q",
+				step.method, step.path, code, state.Current, state.Output, step.current, step.output)
+		}
+	}
+	if _, state := debugRequest(t, alice, "GET", session, ""); !state.Done || !reflect.DeepEqual(state.Breakpoints, []int{3}) {
+		t.Errorf("final state %+v, want done with the breakpoint on 3", state)
+	}
+
+	if code, _ := debugRequest(t, alice, "DELETE", session, ""); code != http.StatusAccepted {
+		t.Errorf("end: status %d, want 202", code)
+	}
+	if code, _ := debugRequest(t, alice, "GET", session, ""); code != http.StatusNotFound {
+		t.Errorf("get after the end: status %d, want 404", code)
+	}
+}
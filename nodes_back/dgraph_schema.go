@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dgraph-io/dgo/v210/protos/api"
+)
+
+// Migrator is implemented by the stores that keep a versioned schema. The
+// pending migrations are applied once at startup and by the migrate
+// subcommand.
+type Migrator interface {
+	SchemaVersion() (int, error)
+	LatestSchemaVersion() int
+	Migrate() error
+}
+
+// dgraphMigration is one step of the Dgraph schema. Steps are applied in
+// order and the last applied version is kept on a SchemaVersion node.
+type dgraphMigration struct {
+	Version     int
+	Description string
+	Schema      string
+	DropAttrs   []string
+	// Query and SetNquads, when set, are an upsert block run after the
+	// schema change to fill in new predicates.
+	Query     string
+	SetNquads string
+}
+
+var dgraphMigrations = []dgraphMigration{
+	{
+		Version:     1,
+		Description: "users, modules, nodes and the version tracking predicate",
+		Schema: `
+			username: string @index(exact) .
+			password: string .
+			owner: string @index(exact) .
+			module_uid: string @index(exact) .
+			name: string @index(exact) .
+			id: int .
+			class: string .
+			html: string .
+			typenode: bool .
+			pos_x: float .
+			pos_y: float .
+			value: string .
+			operator: string .
+			type: string .
+			node_number: string .
+			port: string .
+			schema_version: int .
+			type User {
+				username
+				password
+			}
+			type Module {
+				name
+				owner
+			}
+			type SchemaVersion {
+				schema_version
+			}
+		`,
+	},
+	{
+		Version:     2,
+		Description: "node edges named after the JSON keys (data, inputs_outputs, connections)",
+		Schema: `
+			id: int @index(int) .
+			node_uid: string .
+			data: uid @reverse .
+			inputs_outputs: [uid] @reverse .
+			connections: [uid] @reverse .
+			type Node {
+				module_uid
+				id
+				name
+				class
+				html
+				typenode
+				pos_x
+				pos_y
+				data
+				inputs_outputs
+			}
+			type Data {
+				name
+				value
+				operator
+			}
+			type InputOutput {
+				node_uid
+				name
+				type
+				connections
+			}
+			type Connection {
+				node_number
+				port
+			}
+		`,
+		DropAttrs: []string{"Data", "InputOutput", "Connection", "input"},
+	},
+	{
+		Version:     3,
+		Description: "user roles, groups of students and module comments",
+		Schema: `
+			role: string @index(exact) .
+			teacher: string @index(exact) .
+			members: [string] @index(exact) .
+			author: string @index(exact) .
+			body: string .
+			created_at: datetime .
+			type User {
+				username
+				password
+				role
+			}
+			type Group {
+				name
+				teacher
+				members
+			}
+			type Comment {
+				module_uid
+				author
+				body
+				created_at
+			}
+		`,
+	},
+	{
+		Version:     4,
+		Description: "join codes of the groups",
+		Schema: `
+			code: string @index(exact) .
+			type Group {
+				name
+				teacher
+				members
+				code
+			}
+		`,
+	},
+	{
+		Version:     5,
+		Description: "login sessions holding the hash of their refresh token",
+		Schema: `
+			token_hash: string .
+			expires_at: datetime @index(hour) .
+			type Session {
+				username
+				token_hash
+				created_at
+				expires_at
+			}
+		`,
+	},
+	{
+		Version:     6,
+		Description: "API keys holding the hash of their secret",
+		Schema: `
+			key_hash: string .
+			read_only: bool .
+			type APIKey {
+				username
+				name
+				key_hash
+				read_only
+				created_at
+			}
+		`,
+	},
+	{
+		Version:     7,
+		Description: "OpenID provider and subject of the users it created",
+		Schema: `
+			oidc_issuer: string @index(exact) .
+			oidc_subject: string @index(exact) .
+			type User {
+				username
+				password
+				role
+				oidc_issuer
+				oidc_subject
+			}
+		`,
+	},
+	{
+		Version:     8,
+		Description: "@upsert on username, so concurrent registrations of a name conflict",
+		Schema: `
+			username: string @index(exact) @upsert .
+		`,
+	},
+	{
+		Version:     9,
+		Description: "@upsert on the join code of the groups",
+		Schema: `
+			code: string @index(exact) @upsert .
+		`,
+	},
+	{
+		// Sessions and API keys hold username too, so @upsert on it made
+		// two logins of a user conflict
+		Version:     10,
+		Description: "account_name, the unique username of the users, instead of @upsert on username",
+		Schema: `
+			username: string @index(exact) .
+			account_name: string @index(exact) @upsert .
+			type User {
+				username
+				password
+				role
+				oidc_issuer
+				oidc_subject
+				account_name
+			}
+		`,
+		Query: `query {
+			users as var(func: type(User)) {
+				name as username
+			}
+		}`,
+		SetNquads: `uid(users) <account_name> val(name) .`,
+	},
+}
+
+func (s *DgraphStore) LatestSchemaVersion() int {
+	return dgraphMigrations[len(dgraphMigrations)-1].Version
+}
+
+// SchemaVersion returns the last migration applied, 0 on a new database.
+func (s *DgraphStore) SchemaVersion() (int, error) {
+	q := `{
+		versions(func: type(SchemaVersion)) {
+			schema_version
+		}
+	}`
+
+	resp, err := s.dg.NewReadOnlyTxn().Query(context.Background(), q)
+	if err != nil {
+		return 0, err
+	}
+
+	var r struct {
+		Versions []struct {
+			SchemaVersion int `json:"schema_version"`
+		} `json:"versions"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for _, v := range r.Versions {
+		if v.SchemaVersion > version {
+			version = v.SchemaVersion
+		}
+	}
+	return version, nil
+}
+
+// Migrate applies every migration newer than the current schema version.
+func (s *DgraphStore) Migrate() error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for _, m := range dgraphMigrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := s.dg.Alter(ctx, &api.Operation{Schema: m.Schema}); err != nil {
+			return fmt.Errorf("schema migration %d: %w", m.Version, err)
+		}
+		for _, attr := range m.DropAttrs {
+			op := &api.Operation{DropOp: api.Operation_ATTR, DropValue: attr}
+			if err := s.dg.Alter(ctx, op); err != nil {
+				return fmt.Errorf("schema migration %d, dropping %s: %w", m.Version, attr, err)
+			}
+		}
+		if m.Query != "" {
+			req := &api.Request{
+				Query:     m.Query,
+				Mutations: []*api.Mutation{{SetNquads: []byte(m.SetNquads)}},
+				CommitNow: true,
+			}
+			if _, err := s.dg.NewTxn().Do(ctx, req); err != nil {
+				return fmt.Errorf("schema migration %d, filling in: %w", m.Version, err)
+			}
+		}
+		if err := s.setSchemaVersion(ctx, m.Version); err != nil {
+			return fmt.Errorf("schema migration %d: %w", m.Version, err)
+		}
+		infof("Applied schema migration %d: %s", m.Version, m.Description)
+	}
+	return nil
+}
+
+// setSchemaVersion upserts the single SchemaVersion node.
+func (s *DgraphStore) setSchemaVersion(ctx context.Context, version int) error {
+	req := &api.Request{
+		Query: `query {
+			v as var(func: type(SchemaVersion))
+		}`,
+		Mutations: []*api.Mutation{{
+			SetNquads: []byte(fmt.Sprintf("uid(v) <schema_version> \"%d\" .\nuid(v) <dgraph.type> \"SchemaVersion\" .", version)),
+		}},
+		CommitNow: true,
+	}
+	_, err := s.dg.NewTxn().Do(ctx, req)
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestDgraphMigrationsOrder(t *testing.T) {
+	for i, m := range dgraphMigrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d, want %d", i, m.Version, i+1)
+		}
+		if m.Description == "" {
+			t.Errorf("migration %d has no description", m.Version)
+		}
+		if (m.Query == "") != (m.SetNquads == "") {
+			t.Errorf("migration %d has a query or a mutation without the other", m.Version)
+		}
+	}
+}
+
+func TestDgraphMigrate(t *testing.T) {
+	s := openDgraph(t)
+
+	version, err := s.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != s.LatestSchemaVersion() {
+		t.Errorf("schema version after Migrate = %d, want %d", version, s.LatestSchemaVersion())
+	}
+	// Nothing is pending, a second run changes nothing
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := s.SchemaVersion(); err != nil || again != version {
+		t.Errorf("schema version after a second Migrate = %d, %v, want %d", again, err, version)
+	}
+}
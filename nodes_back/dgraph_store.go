@@ -0,0 +1,1861 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/dgo/v210"
+	"github.com/dgraph-io/dgo/v210/protos/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// dialDgraph opens a pool of gRPC connections to the alpha at addr and logs
+// in once. The connections reconnect on their own with backoff, and dgo
+// logs in again by itself when the access token expires.
+func dialDgraph(addr string, user string, password string, size int) (*dgo.Dgraph, []*grpc.ClientConn, error) {
+	var conns []*grpc.ClientConn
+	var clients []api.DgraphClient
+	for i := 0; i < size; i++ {
+		conn, err := grpc.Dial(addr,
+			grpc.WithInsecure(),
+			grpc.WithConnectParams(grpc.ConnectParams{
+				Backoff:           backoff.DefaultConfig,
+				MinConnectTimeout: 5 * time.Second,
+			}),
+		)
+		if err != nil {
+			closeConns(conns)
+			return nil, nil, fmt.Errorf("while trying to dial gRPC: %w", err)
+		}
+		conns = append(conns, conn)
+		clients = append(clients, api.NewDgraphClient(conn))
+	}
+
+	dg := dgo.NewDgraphClient(clients...)
+	if err := loginWithRetry(dg, user, password); err != nil {
+		closeConns(conns)
+		return nil, nil, fmt.Errorf("while trying to login: %w", err)
+	}
+	return dg, conns, nil
+}
+
+// loginWithRetry logs in as user, retrying while the alpha is still starting
+// up or unreachable, for at most a minute.
+func loginWithRetry(dg *dgo.Dgraph, user string, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	wait := 500 * time.Millisecond
+	for {
+		err := dg.Login(ctx, user, password)
+		if err == nil {
+			return nil
+		}
+		if !strings.Contains(err.Error(), "Please retry") && status.Code(err) != codes.Unavailable {
+			return err
+		}
+		warnf("Dgraph not ready, retrying login in %v: %v", wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if wait < 8*time.Second {
+			wait *= 2
+		}
+	}
+}
+
+func closeConns(conns []*grpc.ClientConn) error {
+	var first error
+	for _, conn := range conns {
+		if err := conn.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+/******************************************************************************
+********************************* Start database ******************************
+******************************************************************************/
+// DgraphStore is the Store backed by a Dgraph alpha. It holds one pooled,
+// logged in client for the whole process.
+type DgraphStore struct {
+	dg    *dgo.Dgraph
+	conns []*grpc.ClientConn
+}
+
+// NewDgraphStore connects to the alpha in c. dgo spreads its calls over the
+// c.PoolSize connections.
+func NewDgraphStore(c DgraphConfig) (*DgraphStore, error) {
+	dg, conns, err := dialDgraph(c.Addr, c.User, c.Password, c.PoolSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DgraphStore{dg: dg, conns: conns}, nil
+}
+
+// Close closes the pooled gRPC connections.
+func (s *DgraphStore) Close() error {
+	return closeConns(s.conns)
+}
+
+// dgraphNodeFields selects a node with its data, ports and connections.
+const dgraphNodeFields = `
+	uid
+	expand(_all_)
+	data{
+		uid
+		expand(_all_)
+	}
+	inputs_outputs{
+		uid
+		expand(_all_)
+		connections{
+			uid
+			expand(_all_)
+		}
+	}
+`
+
+// dgraphModuleNodes loads the nodes of a module inside txn.
+func dgraphModuleNodes(ctx context.Context, txn *dgo.Txn, module_uid string) ([]*Node, error) {
+	q := `query modulenodes($module_uid: string){
+		nodes(func: type(Node)) @filter(eq(module_uid, $module_uid)) {` + dgraphNodeFields + `}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$module_uid": module_uid})
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Nodes []*Node `json:"nodes"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	return r.Nodes, nil
+}
+
+// dgraphModuleComments loads the comments of a module inside txn.
+func dgraphModuleComments(ctx context.Context, txn *dgo.Txn, module_uid string) ([]*Comment, error) {
+	q := `query modulecomments($module_uid: string){
+		comments(func: type(Comment)) @filter(eq(module_uid, $module_uid)) {
+			uid
+			expand(_all_)
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$module_uid": module_uid})
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Comments []*Comment `json:"comments"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	return r.Comments, nil
+}
+
+// dgraphModule is a module along with its nodes and comments.
+type dgraphModule struct {
+	Module
+	Nodes    []*Node    `json:"nodes"`
+	Comments []*Comment `json:"comments"`
+}
+
+// dgraphModules loads, inside txn, the modules matched by the root function
+// (e.g. "uid($uid)") with their nodes and comments.
+func dgraphModules(ctx context.Context, txn *dgo.Txn, root string, vars map[string]string) ([]*dgraphModule, error) {
+	var params []string
+	for name := range vars {
+		params = append(params, name+": string")
+	}
+	q := `query modules(` + strings.Join(params, ", ") + `){
+		modules(func: ` + root + `) @filter(type(Module)) {
+			uid
+			expand(_all_)
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, vars)
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Modules []*dgraphModule `json:"modules"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	for _, module := range r.Modules {
+		if module.Nodes, err = dgraphModuleNodes(ctx, txn, module.Uid); err != nil {
+			return nil, err
+		}
+		if module.Comments, err = dgraphModuleComments(ctx, txn, module.Uid); err != nil {
+			return nil, err
+		}
+	}
+	return r.Modules, nil
+}
+
+func modulesUids(modules []*dgraphModule) []string {
+	var uids []string
+	for _, module := range modules {
+		uids = append(uids, module.Uid)
+		for _, node := range module.Nodes {
+			uids = append(uids, nodeUids(node)...)
+		}
+		for _, comment := range module.Comments {
+			uids = append(uids, comment.Uid)
+		}
+	}
+	return uids
+}
+
+// dgraphDelete deletes the given objects and the refs (object plus the edge
+// pointing at it) in one mutation and commits txn.
+func dgraphDelete(ctx context.Context, txn *dgo.Txn, uids []string, refs []removedRef) error {
+	var del []map[string]interface{}
+	for _, uid := range uids {
+		del = append(del, map[string]interface{}{"uid": uid})
+	}
+	for _, ref := range refs {
+		del = append(del,
+			map[string]interface{}{"uid": ref.Parent, ref.Edge: []map[string]string{{"uid": ref.Uid}}},
+			map[string]interface{}{"uid": ref.Uid},
+		)
+	}
+	if len(del) == 0 {
+		return txn.Commit(ctx)
+	}
+
+	db, err := json.Marshal(del)
+	if err != nil {
+		return err
+	}
+	_, err = txn.Mutate(ctx, &api.Mutation{DeleteJson: db, CommitNow: true})
+	return err
+}
+
+// CreateUser checks the username is free and creates the user in one
+// transaction. The name is also kept in account_name, which only users
+// have; with @upsert on it Dgraph aborts the second of two concurrent
+// registrations of a name, reported as a conflict.
+func (s *DgraphStore) CreateUser(username string, password string) (string, error) {
+	if err := validateUser(username, password); err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	q := `query getuser($username: string){
+		users(func: eq(account_name, $username)) {
+			uid
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$username": username})
+	if err != nil {
+		return "", dgraphError(err)
+	}
+	var r struct {
+		Users []User `json:"users"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return "", err
+	}
+	if len(r.Users) > 0 {
+		return "", fmt.Errorf("user %s: %w", username, errConflict)
+	}
+
+	user := User{
+		Username: username,
+		Password: password,
+		DgraphType: "User",
+	}
+	ub, err := json.Marshal(struct {
+		User
+		AccountName	string	`json:"account_name"`
+	}{user, username})
+	if err != nil {
+		return "", err
+	}
+	response, err := txn.Mutate(ctx, &api.Mutation{SetJson: ub, CommitNow: true})
+	if err != nil {
+		return "", dgraphError(err)
+	}
+
+	var result string
+	//Get created user uid
+	for _, value := range response.Uids {
+		result = value
+	}
+
+	return result, nil
+}
+
+// UpdatePassword sets the password of the user inside one transaction.
+func (s *DgraphStore) UpdatePassword(username string, password string) error {
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	q := `query getuser($username: string){
+		users(func: eq(username, $username)) @filter(type(User)) {
+			uid
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$username": username})
+	if err != nil {
+		return dgraphError(err)
+	}
+	var r struct {
+		Users []User `json:"users"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return err
+	}
+	if len(r.Users) == 0 {
+		return fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+
+	var set []map[string]string
+	for _, user := range r.Users {
+		set = append(set, map[string]string{"uid": user.Uid, "password": password})
+	}
+	sb, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	_, err = txn.Mutate(ctx, &api.Mutation{SetJson: sb, CommitNow: true})
+	return dgraphError(err)
+}
+
+// UpdateRole sets the role of the user inside one transaction.
+func (s *DgraphStore) UpdateRole(username string, role string) error {
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	q := `query getuser($username: string){
+		users(func: eq(username, $username)) @filter(type(User)) {
+			uid
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$username": username})
+	if err != nil {
+		return dgraphError(err)
+	}
+	var r struct {
+		Users []User `json:"users"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return err
+	}
+	if len(r.Users) == 0 {
+		return fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+
+	var set []map[string]string
+	for _, user := range r.Users {
+		set = append(set, map[string]string{"uid": user.Uid, "role": role})
+	}
+	sb, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	_, err = txn.Mutate(ctx, &api.Mutation{SetJson: sb, CommitNow: true})
+	return dgraphError(err)
+}
+
+// SetUserIdentity sets the provider and subject of the user inside one
+// transaction.
+func (s *DgraphStore) SetUserIdentity(username string, issuer string, subject string) error {
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	q := `query getuser($username: string){
+		users(func: eq(username, $username)) @filter(type(User)) {
+			uid
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$username": username})
+	if err != nil {
+		return dgraphError(err)
+	}
+	var r struct {
+		Users []User `json:"users"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return err
+	}
+	if len(r.Users) == 0 {
+		return fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+
+	var set []map[string]string
+	for _, user := range r.Users {
+		set = append(set, map[string]string{"uid": user.Uid, "oidc_issuer": issuer, "oidc_subject": subject})
+	}
+	sb, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	_, err = txn.Mutate(ctx, &api.Mutation{SetJson: sb, CommitNow: true})
+	return dgraphError(err)
+}
+
+func (s *DgraphStore) UserByIdentity(issuer string, subject string) (*User, error) {
+	q := `query identity($issuer: string, $subject: string){
+		users(func: eq(oidc_subject, $subject)) @filter(type(User) and eq(oidc_issuer, $issuer)) {
+			uid
+			expand(_all_)
+		}
+	}`
+	resp, err := s.dg.NewReadOnlyTxn().QueryWithVars(context.Background(), q, map[string]string{"$issuer": issuer, "$subject": subject})
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	var r struct {
+		Users []User `json:"users"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	if len(r.Users) == 0 {
+		return nil, fmt.Errorf("user of %s %s: %w", issuer, subject, errNotFound)
+	}
+	return &r.Users[0], nil
+}
+
+func (s *DgraphStore) ListUsers() ([]User, error) {
+	q := `{
+		users(func: type(User), orderasc: username) {
+			uid
+			expand(_all_)
+		}
+	}`
+	resp, err := s.dg.NewReadOnlyTxn().Query(context.Background(), q)
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	var r struct {
+		Users []User `json:"users"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	return r.Users, nil
+}
+
+// DeleteUser removes a user with all its modules, their nodes and comments,
+// the groups it teaches and its membership in the others.
+func (s *DgraphStore) DeleteUser(username string) error {
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	q := `query getuser($username: string){
+		users(func: eq(username, $username)) @filter(type(User)) {
+			uid
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$username": username})
+	if err != nil {
+		return dgraphError(err)
+	}
+	var r struct {
+		Users []User `json:"users"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return err
+	}
+	if len(r.Users) == 0 {
+		return fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+
+	modules, err := dgraphModules(ctx, txn, "eq(owner, $username)", map[string]string{"$username": username})
+	if err != nil {
+		return dgraphError(err)
+	}
+	uids := modulesUids(modules)
+	for _, user := range r.Users {
+		uids = append(uids, user.Uid)
+	}
+	sessions, err := dgraphUserSessions(ctx, txn, username)
+	if err != nil {
+		return dgraphError(err)
+	}
+	uids = append(uids, sessions...)
+	keys, err := dgraphUserAPIKeys(ctx, txn, username)
+	if err != nil {
+		return dgraphError(err)
+	}
+	for _, key := range keys {
+		uids = append(uids, key.Uid)
+	}
+
+	groups, err := dgraphGroups(ctx, txn)
+	if err != nil {
+		return dgraphError(err)
+	}
+	var leave []map[string]interface{}
+	for _, group := range groups {
+		if group.Teacher == username {
+			uids = append(uids, group.Uid)
+			continue
+		}
+		for _, member := range group.Members {
+			if member == username {
+				leave = append(leave, map[string]interface{}{"uid": group.Uid, "members": []string{username}})
+				break
+			}
+		}
+	}
+	if len(leave) > 0 {
+		db, err := json.Marshal(leave)
+		if err != nil {
+			return err
+		}
+		if _, err := txn.Mutate(ctx, &api.Mutation{DeleteJson: db}); err != nil {
+			return dgraphError(err)
+		}
+	}
+	return dgraphError(dgraphDelete(ctx, txn, uids, nil))
+}
+
+// dgraphUserSessions returns the uids of the sessions of username.
+func dgraphUserSessions(ctx context.Context, txn *dgo.Txn, username string) ([]string, error) {
+	q := `query sessions($username: string){
+		sessions(func: eq(username, $username)) @filter(type(Session)) {
+			uid
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$username": username})
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Sessions []Session `json:"sessions"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, session := range r.Sessions {
+		uids = append(uids, session.Uid)
+	}
+	return uids, nil
+}
+
+func (s *DgraphStore) CreateSession(session *Session) (string, error) {
+	if err := validateSession(session); err != nil {
+		return "", err
+	}
+
+	users, err := s.GetUsersByUsername(session.Username)
+	if err != nil {
+		return "", err
+	}
+	if len(users) == 0 {
+		return "", fmt.Errorf("user %s: %w", session.Username, errNotFound)
+	}
+
+	new_session := *session
+	new_session.Uid = ""
+	new_session.DgraphType = "Session"
+	sb, err := json.Marshal(new_session)
+	if err != nil {
+		return "", err
+	}
+	response, err := s.dg.NewTxn().Mutate(context.Background(), &api.Mutation{SetJson: sb, CommitNow: true})
+	if err != nil {
+		return "", dgraphError(err)
+	}
+
+	var uid string
+	for _, value := range response.Uids {
+		uid = value
+	}
+	return uid, nil
+}
+
+func (s *DgraphStore) GetSession(uid string) (*Session, error) {
+	if err := checkUid(uid); err != nil {
+		return nil, err
+	}
+
+	q := `query session($uid: string){
+		sessions(func: uid($uid)) @filter(type(Session)) {
+			uid
+			expand(_all_)
+		}
+	}`
+	resp, err := s.dg.NewReadOnlyTxn().QueryWithVars(context.Background(), q, map[string]string{"$uid": uid})
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	var r struct {
+		Sessions []*Session `json:"sessions"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	if len(r.Sessions) == 0 {
+		return nil, fmt.Errorf("session %s: %w", uid, errNotFound)
+	}
+	return r.Sessions[0], nil
+}
+
+// UpdateSession stores the new token hash and expiry of a session.
+func (s *DgraphStore) UpdateSession(session *Session) error {
+	if err := validateSession(session); err != nil {
+		return err
+	}
+	if err := checkUid(session.Uid); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	exists, err := dgraphExists(ctx, txn, session.Uid, "Session")
+	if err != nil {
+		return dgraphError(err)
+	}
+	if !exists {
+		return fmt.Errorf("session %s: %w", session.Uid, errNotFound)
+	}
+
+	sb, err := json.Marshal(map[string]interface{}{
+		"uid":        session.Uid,
+		"token_hash": session.TokenHash,
+		"expires_at": session.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = txn.Mutate(ctx, &api.Mutation{SetJson: sb, CommitNow: true})
+	return dgraphError(err)
+}
+
+func (s *DgraphStore) DeleteSession(uid string) error {
+	if err := checkUid(uid); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	exists, err := dgraphExists(ctx, txn, uid, "Session")
+	if err != nil {
+		return dgraphError(err)
+	}
+	if !exists {
+		return fmt.Errorf("session %s: %w", uid, errNotFound)
+	}
+	return dgraphError(dgraphDelete(ctx, txn, []string{uid}, nil))
+}
+
+func (s *DgraphStore) DeleteUserSessions(username string) (int, error) {
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	uids, err := dgraphUserSessions(ctx, txn, username)
+	if err != nil {
+		return 0, dgraphError(err)
+	}
+	if err := dgraphDelete(ctx, txn, uids, nil); err != nil {
+		return 0, dgraphError(err)
+	}
+	return len(uids), nil
+}
+
+// dgraphUserAPIKeys returns the API keys of username.
+func dgraphUserAPIKeys(ctx context.Context, txn *dgo.Txn, username string) ([]*APIKey, error) {
+	q := `query keys($username: string){
+		keys(func: eq(username, $username)) @filter(type(APIKey)) {
+			uid
+			expand(_all_)
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$username": username})
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Keys []*APIKey `json:"keys"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	keys := append([]*APIKey{}, r.Keys...)
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+func (s *DgraphStore) CreateAPIKey(key *APIKey) (string, error) {
+	if err := validateAPIKey(key); err != nil {
+		return "", err
+	}
+
+	users, err := s.GetUsersByUsername(key.Username)
+	if err != nil {
+		return "", err
+	}
+	if len(users) == 0 {
+		return "", fmt.Errorf("user %s: %w", key.Username, errNotFound)
+	}
+
+	new_key := *key
+	new_key.Uid = ""
+	new_key.DgraphType = "APIKey"
+	kb, err := json.Marshal(new_key)
+	if err != nil {
+		return "", err
+	}
+	response, err := s.dg.NewTxn().Mutate(context.Background(), &api.Mutation{SetJson: kb, CommitNow: true})
+	if err != nil {
+		return "", dgraphError(err)
+	}
+
+	var uid string
+	for _, value := range response.Uids {
+		uid = value
+	}
+	return uid, nil
+}
+
+func (s *DgraphStore) GetAPIKey(uid string) (*APIKey, error) {
+	if err := checkUid(uid); err != nil {
+		return nil, err
+	}
+
+	q := `query key($uid: string){
+		keys(func: uid($uid)) @filter(type(APIKey)) {
+			uid
+			expand(_all_)
+		}
+	}`
+	resp, err := s.dg.NewReadOnlyTxn().QueryWithVars(context.Background(), q, map[string]string{"$uid": uid})
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	var r struct {
+		Keys []*APIKey `json:"keys"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	if len(r.Keys) == 0 {
+		return nil, fmt.Errorf("api key %s: %w", uid, errNotFound)
+	}
+	return r.Keys[0], nil
+}
+
+func (s *DgraphStore) UserAPIKeys(username string) ([]*APIKey, error) {
+	ctx := context.Background()
+	txn := s.dg.NewReadOnlyTxn()
+	keys, err := dgraphUserAPIKeys(ctx, txn, username)
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	return keys, nil
+}
+
+func (s *DgraphStore) DeleteAPIKey(uid string) error {
+	if err := checkUid(uid); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	exists, err := dgraphExists(ctx, txn, uid, "APIKey")
+	if err != nil {
+		return dgraphError(err)
+	}
+	if !exists {
+		return fmt.Errorf("api key %s: %w", uid, errNotFound)
+	}
+	return dgraphError(dgraphDelete(ctx, txn, []string{uid}, nil))
+}
+
+func dgraphGroups(ctx context.Context, txn *dgo.Txn) ([]*Group, error) {
+	q := `{
+		groups(func: type(Group)) {
+			uid
+			expand(_all_)
+		}
+	}`
+	resp, err := txn.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Groups []*Group `json:"groups"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	sort.Slice(r.Groups, func(i, j int) bool { return uidLess(r.Groups[i].Uid, r.Groups[j].Uid) })
+	return r.Groups, nil
+}
+
+// dgraphGroupsBy loads, inside txn, the groups matched by the root function
+// (e.g. "eq(code, $code)").
+func dgraphGroupsBy(ctx context.Context, txn *dgo.Txn, root string, vars map[string]string) ([]*Group, error) {
+	var params []string
+	for name := range vars {
+		params = append(params, name+": string")
+	}
+	q := `query groups(` + strings.Join(params, ", ") + `){
+		groups(func: ` + root + `) @filter(type(Group)) {
+			uid
+			expand(_all_)
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, vars)
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Groups []*Group `json:"groups"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+	return r.Groups, nil
+}
+
+// CreateGroup looks for an unused join code and creates the group in the
+// same transaction. With @upsert on code, Dgraph aborts the second of two
+// concurrent creations that picked the same code, reported as a conflict.
+func (s *DgraphStore) CreateGroup(group *Group) (*Group, error) {
+	if err := validateGroup(group); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	var code string
+	for i := 0; i < joinCodeAttempts && code == ""; i++ {
+		c, err := newJoinCode()
+		if err != nil {
+			return nil, err
+		}
+		taken, err := dgraphGroupsBy(ctx, txn, "eq(code, $code)", map[string]string{"$code": c})
+		if err != nil {
+			return nil, dgraphError(err)
+		}
+		if len(taken) == 0 {
+			code = c
+		}
+	}
+	if code == "" {
+		return nil, fmt.Errorf("no unused join code: %w", errConflict)
+	}
+
+	new_group := &Group{
+		Name:       group.Name,
+		Teacher:    group.Teacher,
+		Members:    group.Members,
+		Code:       code,
+		DgraphType: "Group",
+	}
+	gb, err := json.Marshal(new_group)
+	if err != nil {
+		return nil, err
+	}
+	response, err := txn.Mutate(ctx, &api.Mutation{SetJson: gb, CommitNow: true})
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+
+	for _, value := range response.Uids {
+		new_group.Uid = value
+	}
+	return new_group, nil
+}
+
+func (s *DgraphStore) GetGroups() ([]*Group, error) {
+	groups, err := dgraphGroups(context.Background(), s.dg.NewReadOnlyTxn())
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	return groups, nil
+}
+
+func (s *DgraphStore) GetGroup(uid string) (*Group, error) {
+	if err := checkUid(uid); err != nil {
+		return nil, err
+	}
+	groups, err := dgraphGroupsBy(context.Background(), s.dg.NewReadOnlyTxn(), "uid($uid)", map[string]string{"$uid": uid})
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("group %s: %w", uid, errNotFound)
+	}
+	return groups[0], nil
+}
+
+func (s *DgraphStore) GroupByCode(code string) (*Group, error) {
+	groups, err := dgraphGroupsBy(context.Background(), s.dg.NewReadOnlyTxn(), "eq(code, $code)", map[string]string{"$code": code})
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("group code %s: %w", code, errNotFound)
+	}
+	return groups[0], nil
+}
+
+// AddGroupMember adds username to the members of a group. members is a list
+// of strings, so adding an existing member changes nothing.
+func (s *DgraphStore) AddGroupMember(group_uid string, username string) error {
+	if err := checkUid(group_uid); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	exists, err := dgraphExists(ctx, txn, group_uid, "Group")
+	if err != nil {
+		return dgraphError(err)
+	}
+	if !exists {
+		return fmt.Errorf("group %s: %w", group_uid, errNotFound)
+	}
+	users, err := s.GetUsersByUsername(username)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+
+	sb, err := json.Marshal(map[string]interface{}{"uid": group_uid, "members": []string{username}})
+	if err != nil {
+		return err
+	}
+	_, err = txn.Mutate(ctx, &api.Mutation{SetJson: sb, CommitNow: true})
+	return dgraphError(err)
+}
+
+func (s *DgraphStore) CreateComment(comment *Comment) (string, error) {
+	if err := validateComment(comment); err != nil {
+		return "", err
+	}
+	if err := checkUid(comment.ModuleUID); err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	exists, err := dgraphExists(ctx, txn, comment.ModuleUID, "Module")
+	if err != nil {
+		return "", dgraphError(err)
+	}
+	if !exists {
+		return "", fmt.Errorf("module %s: %w", comment.ModuleUID, errNotFound)
+	}
+
+	new_comment := *comment
+	new_comment.Uid = ""
+	new_comment.DgraphType = "Comment"
+	cb, err := json.Marshal(new_comment)
+	if err != nil {
+		return "", err
+	}
+	response, err := txn.Mutate(ctx, &api.Mutation{SetJson: cb, CommitNow: true})
+	if err != nil {
+		return "", dgraphError(err)
+	}
+
+	var uid string
+	for _, value := range response.Uids {
+		uid = value
+	}
+	return uid, nil
+}
+
+func (s *DgraphStore) ModuleComments(module_uid string) ([]*Comment, error) {
+	comments, err := dgraphModuleComments(context.Background(), s.dg.NewReadOnlyTxn(), module_uid)
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	sortComments(comments)
+	return comments, nil
+}
+
+func (s *DgraphStore) GetUsersByUsername(username string) ([]User, error) {
+	dg := s.dg
+
+	vars := make(map[string]string)
+	vars["$enusername"] = username
+	q := `query wanghausers($enusername: string){
+		users(func: eq(username, $enusername)) @filter(type(User)) {
+			uid
+			expand(_all_)
+		}
+	}`
+
+	ctx := context.Background()
+
+	resp, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+
+	type arrays struct{
+		Uids	[]User `json:"users"`
+	}
+
+	var r arrays
+	err = json.Unmarshal([]byte(resp.Json), &r)
+	if err != nil{
+		return nil, err
+	}
+
+	//Return users as string
+	//return string(resp.Json)
+
+	//Return User array
+	return r.Uids, nil
+}
+
+func (s *DgraphStore) GetModuleByName(name string, username string) ([]Module, error) {
+	dg := s.dg
+
+	vars := make(map[string]string)
+	vars["$enname"] = name
+	vars["$enusername"] = username
+	q := `query wanghamodules($enname: string, $enusername: string){
+		modules(func: type(Module)) @filter(eq(name, $enname) and eq(owner, $enusername) ){
+			uid
+			expand(_all_)
+		}
+	}`
+
+	ctx := context.Background()
+
+	resp, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+
+	type arrays struct{
+		Uids	[]Module `json:"modules"`
+	}
+
+	var r arrays
+	err = json.Unmarshal([]byte(resp.Json), &r)
+	if err != nil{
+		return nil, err
+	}
+
+	return r.Uids, nil
+}
+
+func (s *DgraphStore) CreateModule(module *Module) (string, error) {
+	if err := validateModule(module); err != nil {
+		return "", err
+	}
+
+	dg := s.dg
+
+	new_module := Module{
+		Name: module.Name,
+		Owner: module.Owner,
+		DgraphType: "Module",
+	}
+	
+	ctx := context.Background()
+
+	mu := &api.Mutation{
+		CommitNow: true,
+	}
+
+	mb, err := json.Marshal(new_module)
+	if err != nil {
+		return "", err
+	}
+
+	mu.SetJson = mb
+	response, err := dg.NewTxn().Mutate(ctx, mu)
+	if err != nil {
+		return "", dgraphError(err)
+	}
+
+	var uid string
+	uid = ""
+	//Get created module uid
+	for _, value := range response.Uids {
+		uid = value
+	}
+
+	return uid, nil
+}
+
+func (s *DgraphStore) UserGetModules(username string) ([]*Module, error)  {
+	dg := s.dg
+
+	vars := make(map[string]string)
+	vars["$username"] = username
+	q := `query usermodules($username: string){
+		modules(func: type(Module)) @filter(eq(owner, $username)) {
+			uid
+			expand(_all_)
+		}
+	}`
+
+	ctx := context.Background()
+
+	resp, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+
+	type arrays struct{
+		Uids	[]*Module `json:"modules,omitempty"`
+	}
+
+	var modules arrays
+
+	err = json.Unmarshal([]byte(resp.Json), &modules)
+	if err != nil{
+		return nil, err
+	}
+
+	return modules.Uids, nil
+}
+
+func (s *DgraphStore) DeleteModule(uid string) error {
+	if err := checkUid(uid); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	modules, err := dgraphModules(ctx, txn, "uid($uid)", map[string]string{"$uid": uid})
+	if err != nil {
+		return dgraphError(err)
+	}
+	if len(modules) == 0 {
+		return fmt.Errorf("module %s: %w", uid, errNotFound)
+	}
+	return dgraphError(dgraphDelete(ctx, txn, modulesUids(modules), nil))
+}
+
+// ClearModule delete all nodes from an existing Module.
+func (s *DgraphStore) ClearModule(uid string) error {
+	if err := checkUid(uid); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	modules, err := dgraphModules(ctx, txn, "uid($uid)", map[string]string{"$uid": uid})
+	if err != nil {
+		return dgraphError(err)
+	}
+	if len(modules) == 0 {
+		return fmt.Errorf("module %s: %w", uid, errNotFound)
+	}
+	var uids []string
+	for _, node := range modules[0].Nodes {
+		uids = append(uids, nodeUids(node)...)
+	}
+	return dgraphError(dgraphDelete(ctx, txn, uids, nil))
+}
+
+// ModuleOf follows the reverse edges from a data, port or connection up to
+// its node, whose module_uid names the module.
+func (s *DgraphStore) ModuleOf(uid string) (*Module, error) {
+	if err := checkUid(uid); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewReadOnlyTxn()
+
+	q := `query moduleof($uid: string){
+		modules(func: uid($uid)) @filter(type(Module)) {
+			uid
+		}
+		nodes(func: uid($uid)) @filter(type(Node)) {
+			module_uid
+		}
+		data(func: uid($uid)) @filter(type(Data)) {
+			nodes: ~data {
+				module_uid
+			}
+		}
+		inputs_outputs(func: uid($uid)) @filter(type(InputOutput)) {
+			nodes: ~inputs_outputs {
+				module_uid
+			}
+		}
+		connections(func: uid($uid)) @filter(type(Connection)) {
+			inputs_outputs: ~connections {
+				nodes: ~inputs_outputs {
+					module_uid
+				}
+			}
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$uid": uid})
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	type parent struct {
+		Nodes []Node `json:"nodes"`
+	}
+	var r struct {
+		Modules       []Module `json:"modules"`
+		Nodes         []Node   `json:"nodes"`
+		Data          []parent `json:"data"`
+		InputsOutputs []parent `json:"inputs_outputs"`
+		Connections   []struct {
+			InputsOutputs []parent `json:"inputs_outputs"`
+		} `json:"connections"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+
+	var module_uid string
+	nodes := r.Nodes
+	for _, p := range append(r.Data, r.InputsOutputs...) {
+		nodes = append(nodes, p.Nodes...)
+	}
+	for _, c := range r.Connections {
+		for _, p := range c.InputsOutputs {
+			nodes = append(nodes, p.Nodes...)
+		}
+	}
+	switch {
+	case len(r.Modules) > 0:
+		module_uid = r.Modules[0].Uid
+	case len(nodes) > 0:
+		module_uid = nodes[0].ModuleUID
+	default:
+		return nil, fmt.Errorf("%s: %w", uid, errNotFound)
+	}
+	if err := checkUid(module_uid); err != nil {
+		return nil, fmt.Errorf("module of %s: %w", uid, errNotFound)
+	}
+
+	q = `query module($uid: string){
+		modules(func: uid($uid)) @filter(type(Module)) {
+			uid
+			expand(_all_)
+		}
+	}`
+	resp, err = txn.QueryWithVars(ctx, q, map[string]string{"$uid": module_uid})
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	var m struct {
+		Modules []*Module `json:"modules"`
+	}
+	if err := json.Unmarshal(resp.Json, &m); err != nil {
+		return nil, err
+	}
+	if len(m.Modules) == 0 {
+		return nil, fmt.Errorf("module of %s: %w", uid, errNotFound)
+	}
+	return m.Modules[0], nil
+}
+
+func (s *DgraphStore) CreateNode(node *Node) (Node, error) {
+	if err := validateNode(node); err != nil {
+		return Node{}, err
+	}
+	// Dgraph would update the objects of any uid left in the JSON
+	clearNodeUids(node)
+
+	dg := s.dg
+
+	ctx := context.Background()
+
+	mu := &api.Mutation{
+		CommitNow: true,
+	}
+
+	nb, err := json.Marshal(node)
+	if err != nil {
+		return Node{}, err
+	}
+
+	mu.SetJson = nb
+	_, err = dg.NewTxn().Mutate(ctx, mu)
+	if err != nil {
+		return Node{}, dgraphError(err)
+	}
+
+	id := strconv.Itoa(node.Id)
+	vars := make(map[string]string)
+	vars["$moduleuid"] = node.ModuleUID
+	vars["$id"] = id
+	q := `query getcreatednode($moduleuid: string, $id: string){
+		nodes(func: type(Node)) @filter(eq(module_uid, $moduleuid) and eq(id, $id) ){
+			uid
+			expand(_all_)
+			data{
+				uid
+				expand(_all_)
+			}
+			inputs_outputs{
+				uid
+				expand(_all_)
+				connections{
+					uid
+			  		expand(_all_)
+				}
+		  	}
+		}
+	}`
+
+	resp1, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
+	if err != nil {
+		return Node{}, dgraphError(err)
+	}
+
+	type arrays struct{
+		Uids	[]Node `json:"nodes"`
+	}
+
+	var r arrays
+	err = json.Unmarshal([]byte(resp1.Json), &r)
+	if err != nil{
+		return Node{}, err
+	}
+
+	if len(r.Uids) > 0 {
+		return r.Uids[0], nil
+	}
+
+	return Node{}, fmt.Errorf("created node %d of module %s: %w", node.Id, node.ModuleUID, errNotFound)
+}
+
+func (s *DgraphStore) ModuleGetNodes(module_uid string) ([]*Node, error) {
+	dg := s.dg
+
+	vars := make(map[string]string)
+	vars["$module_uid"] = module_uid
+	q := `query modulenodes($module_uid: string){
+		nodes(func: type(Node)) @filter(eq(module_uid, $module_uid)) {
+			uid
+			expand(_all_)
+			data{
+				uid
+				expand(_all_)
+			}
+			inputs_outputs{
+				uid
+				expand(_all_)
+				connections{
+					uid
+			  		expand(_all_)
+				}
+		  	}
+		}
+	}`
+
+	ctx := context.Background()
+
+	resp, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+
+	type arrays struct{
+		Uids	[]*Node `json:"nodes,omitempty"`
+	}
+
+	var nodes arrays
+
+	err = json.Unmarshal([]byte(resp.Json), &nodes)
+	if err != nil{
+		return nil, err
+	}
+
+	debugf("Module %s nodes: %v", module_uid, nodes)
+
+	return nodes.Uids, nil
+}
+
+// SaveModuleGraph applies the whole graph of a module in one transaction.
+// New objects get blank node uids, removed ones are deleted along with the
+// edge pointing at them.
+func (s *DgraphStore) SaveModuleGraph(module_uid string, nodes []*Node) (*GraphDiff, error) {
+	if err := checkUid(module_uid); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	modules, err := dgraphModules(ctx, txn, "uid($module_uid)", map[string]string{"$module_uid": module_uid})
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	if len(modules) == 0 {
+		return nil, errModuleNotFound
+	}
+
+	blank := 0
+	newUid := func() (string, error) {
+		blank++
+		return fmt.Sprintf("_:new%d", blank), nil
+	}
+
+	var set []*Node
+	var del []map[string]interface{}
+	created, updated, deleted := diffGraph(modules[0].Nodes, nodes)
+	for _, node := range deleted {
+		for _, uid := range nodeUids(node) {
+			del = append(del, map[string]interface{}{"uid": uid})
+		}
+	}
+	for _, node := range created {
+		uid, _ := newUid()
+		merged, _, err := mergeNode(&Node{Uid: uid, ModuleUID: module_uid}, node, newUid)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, merged)
+	}
+	for _, pair := range updated {
+		merged, removed, err := mergeNode(pair[0], pair[1], newUid)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, merged)
+		for _, ref := range removed {
+			del = append(del,
+				map[string]interface{}{"uid": ref.Parent, ref.Edge: []map[string]string{{"uid": ref.Uid}}},
+				map[string]interface{}{"uid": ref.Uid},
+			)
+		}
+		// Empty values are left out of the set json, clear them explicitly
+		// on the node and on its data, unless the data is new
+		for predicate, empty := range map[string]bool{"name": merged.Name == "", "class": merged.Class == "", "html": merged.Html == "", "pos_x": merged.PosX == 0, "pos_y": merged.PosY == 0} {
+			if empty {
+				del = append(del, map[string]interface{}{"uid": merged.Uid, predicate: nil})
+			}
+		}
+		if !strings.HasPrefix(merged.Data.Uid, "_:") {
+			for predicate, value := range map[string]string{"name": merged.Data.Name, "value": merged.Data.Value, "operator": merged.Data.Operator} {
+				if value == "" {
+					del = append(del, map[string]interface{}{"uid": merged.Data.Uid, predicate: nil})
+				}
+			}
+		}
+	}
+
+	req := &api.Request{CommitNow: true}
+	if len(set) > 0 {
+		sb, err := json.Marshal(set)
+		if err != nil {
+			return nil, err
+		}
+		req.Mutations = append(req.Mutations, &api.Mutation{SetJson: sb})
+	}
+	if len(del) > 0 {
+		db, err := json.Marshal(del)
+		if err != nil {
+			return nil, err
+		}
+		req.Mutations = append(req.Mutations, &api.Mutation{DeleteJson: db})
+	}
+	if len(req.Mutations) > 0 {
+		if _, err := txn.Do(ctx, req); err != nil {
+			return nil, dgraphError(err)
+		}
+	}
+
+	saved, err := s.ModuleGetNodes(module_uid)
+	if err != nil {
+		return nil, err
+	}
+	return &GraphDiff{
+		Created: len(created),
+		Updated: len(updated),
+		Deleted: len(deleted),
+		Nodes:   saved,
+	}, nil
+}
+
+// DeleteNode removes a node with its data, ports and connections, and the
+// connections of the other nodes of the module that pointed at it.
+func (s *DgraphStore) DeleteNode(uid string) error {
+	if err := checkUid(uid); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	q := `query getnode($uid: string){
+		nodes(func: uid($uid)) @filter(type(Node)) {
+			uid
+			module_uid
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$uid": uid})
+	if err != nil {
+		return dgraphError(err)
+	}
+	var r struct {
+		Nodes []*Node `json:"nodes"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return err
+	}
+	if len(r.Nodes) == 0 {
+		return fmt.Errorf("node %s: %w", uid, errNotFound)
+	}
+
+	nodes, err := dgraphModuleNodes(ctx, txn, r.Nodes[0].ModuleUID)
+	if err != nil {
+		return dgraphError(err)
+	}
+	var deleted *Node
+	for _, node := range nodes {
+		if node.Uid == uid {
+			deleted = node
+		}
+	}
+	if deleted == nil {
+		return fmt.Errorf("node %s: %w", uid, errNotFound)
+	}
+
+	refs := peerConnections(nodes, []*Node{deleted})
+	return dgraphError(dgraphDelete(ctx, txn, nodeUids(deleted), refs))
+}
+
+// dgraphExists tells whether uid is an object of the given type.
+func dgraphExists(ctx context.Context, txn *dgo.Txn, uid string, dgraph_type string) (bool, error) {
+	q := `query exists($uid: string){
+		objects(func: uid($uid)) @filter(type(` + dgraph_type + `)) {
+			uid
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$uid": uid})
+	if err != nil {
+		return false, err
+	}
+	var r struct {
+		Objects []struct {
+			Uid string `json:"uid"`
+		} `json:"objects"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return false, err
+	}
+	return len(r.Objects) > 0, nil
+}
+
+func (s *DgraphStore) NodeUpdatePosition(node_uid string, pos_x float32, pos_y float32) error {
+	if err := checkUid(node_uid); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	exists, err := dgraphExists(ctx, txn, node_uid, "Node")
+	if err != nil {
+		return dgraphError(err)
+	}
+	if !exists {
+		return fmt.Errorf("node %s: %w", node_uid, errNotFound)
+	}
+
+	mu := &api.Mutation{
+		CommitNow: true,
+	}
+
+	t1 := fmt.Sprintf("<%s> <pos_x> \"%g\" .",node_uid,pos_x)
+	t2 := fmt.Sprintf("<%s> <pos_y> \"%g\" .",node_uid,pos_y)
+	t := fmt.Sprintf(t1+"\n"+t2)
+	mu.SetNquads = []byte(t)
+
+	_,err = txn.Mutate(ctx,mu)
+	return dgraphError(err)
+}
+
+
+// UpdateData sets the fields as JSON, so quotes or newlines in a value
+// can't break the mutation.
+func (s *DgraphStore) UpdateData(data *Data) error {
+	if err := checkUid(data.Uid); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	exists, err := dgraphExists(ctx, txn, data.Uid, "Data")
+	if err != nil {
+		return dgraphError(err)
+	}
+	if !exists {
+		return fmt.Errorf("data %s: %w", data.Uid, errNotFound)
+	}
+
+	mu := &api.Mutation{
+		CommitNow: true,
+	}
+
+	db, err := json.Marshal(map[string]string{
+		"uid":      data.Uid,
+		"name":     data.Name,
+		"value":    data.Value,
+		"operator": data.Operator,
+	})
+	if err != nil {
+		return err
+	}
+	mu.SetJson = db
+
+	_,err = txn.Mutate(ctx,mu)
+	return dgraphError(err)
+}
+
+// AddConnection checks the port exists and creates the connection inside it
+// in the same transaction.
+func (s *DgraphStore) AddConnection(input_output_uid string, connection *Connection) (string, error) {
+	if err := checkUid(input_output_uid); err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	exists, err := dgraphExists(ctx, txn, input_output_uid, "InputOutput")
+	if err != nil {
+		return "", dgraphError(err)
+	}
+	if !exists {
+		return "", fmt.Errorf("input/output %s: %w", input_output_uid, errNotFound)
+	}
+
+	new_connection := *connection
+	// A blank node, Dgraph would update the connection of a uid left in the JSON
+	new_connection.Uid = "_:connection"
+	new_connection.DgraphType = "Connection"
+	cb, err := json.Marshal(map[string]interface{}{
+		"uid":         input_output_uid,
+		"connections": []*Connection{&new_connection},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	response, err := txn.Mutate(ctx, &api.Mutation{SetJson: cb, CommitNow: true})
+	if err != nil {
+		return "", dgraphError(err)
+	}
+	return response.Uids["connection"], nil
+}
+
+// DeleteConnection checks the connection hangs from the port and removes it
+// and its edge in the same transaction.
+func (s *DgraphStore) DeleteConnection(parent_uid string, connection *Connection) error {
+	if err := checkUid(parent_uid); err != nil {
+		return err
+	}
+	if err := checkUid(connection.Uid); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	// Only a connection of the port, whose owner was checked, may go
+	q := `query connection($parent: string, $uid: string){
+		ports(func: uid($parent)) @filter(type(InputOutput)) {
+			connections @filter(uid($uid)) {
+				uid
+			}
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, q, map[string]string{"$parent": parent_uid, "$uid": connection.Uid})
+	if err != nil {
+		return dgraphError(err)
+	}
+	var r struct {
+		Ports []InputOutput `json:"ports"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return err
+	}
+	if len(r.Ports) == 0 || len(r.Ports[0].Connections) == 0 {
+		return fmt.Errorf("connection %s of input/output %s: %w", connection.Uid, parent_uid, errNotFound)
+	}
+
+	ref := removedRef{Parent: parent_uid, Edge: "connections", Uid: connection.Uid}
+	return dgraphError(dgraphDelete(ctx, txn, nil, []removedRef{ref}))
+}
+// CollectGarbage finds the Data, InputOutput and Connection objects no node
+// references (including the ones left behind by the old one-uid-at-a-time
+// deletes), nodes whose module is gone and connections pointing at missing
+// nodes, and removes them in one transaction unless dryRun is set.
+func (s *DgraphStore) CollectGarbage(dryRun bool) (*GCReport, error) {
+	ctx := context.Background()
+	txn := s.dg.NewTxn()
+	defer txn.Discard(ctx)
+
+	q := `{
+		modules(func: type(Module)) {
+			uid
+		}
+		nodes(func: type(Node)) {` + dgraphNodeFields + `}
+		data(func: type(Data)) {
+			uid
+		}
+		inputs_outputs(func: type(InputOutput)) {
+			uid
+		}
+		connections(func: type(Connection)) {
+			uid
+		}
+		ghost_nodes(func: has(inputs_outputs)) @filter(not type(Node)) {
+			uid
+		}
+		ghost_inputs_outputs(func: has(connections)) @filter(not type(InputOutput)) {
+			uid
+		}
+		expired_sessions(func: lt(expires_at, $now)) @filter(type(Session)) {
+			uid
+		}
+	}`
+	resp, err := txn.QueryWithVars(ctx, `query gc($now: string)`+q, map[string]string{"$now": time.Now().UTC().Format(time.RFC3339)})
+	if err != nil {
+		return nil, dgraphError(err)
+	}
+	var r struct {
+		Modules            []Module      `json:"modules"`
+		Nodes              []*Node       `json:"nodes"`
+		Data               []Data        `json:"data"`
+		InputsOutputs      []InputOutput `json:"inputs_outputs"`
+		Connections        []Connection  `json:"connections"`
+		GhostNodes         []Node        `json:"ghost_nodes"`
+		GhostInputsOutputs []InputOutput `json:"ghost_inputs_outputs"`
+		ExpiredSessions    []Session     `json:"expired_sessions"`
+	}
+	if err := json.Unmarshal(resp.Json, &r); err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string]bool, len(r.Modules))
+	for _, module := range r.Modules {
+		modules[module.Uid] = true
+	}
+	orphans, dangling := gcScanNodes(modules, r.Nodes)
+
+	reachable := make(map[string]bool)
+	for _, node := range r.Nodes {
+		for _, uid := range nodeUids(node) {
+			reachable[uid] = true
+		}
+	}
+
+	report := &GCReport{DryRun: dryRun}
+	var del []map[string]interface{}
+	for _, node := range orphans {
+		report.OrphanNodes = append(report.OrphanNodes, node.Uid)
+		for _, uid := range nodeUids(node) {
+			del = append(del, map[string]interface{}{"uid": uid})
+		}
+	}
+	// Untyped leftovers of a partial delete only keep their edges
+	for _, node := range r.GhostNodes {
+		report.OrphanNodes = append(report.OrphanNodes, node.Uid)
+		del = append(del, map[string]interface{}{"uid": node.Uid, "data": nil, "inputs_outputs": nil})
+	}
+	for _, data := range r.Data {
+		if !reachable[data.Uid] {
+			report.OrphanData = append(report.OrphanData, data.Uid)
+			del = append(del, map[string]interface{}{"uid": data.Uid})
+		}
+	}
+	for _, input_output := range r.InputsOutputs {
+		if !reachable[input_output.Uid] {
+			report.OrphanInputsOutputs = append(report.OrphanInputsOutputs, input_output.Uid)
+			del = append(del, map[string]interface{}{"uid": input_output.Uid})
+		}
+	}
+	for _, input_output := range r.GhostInputsOutputs {
+		report.OrphanInputsOutputs = append(report.OrphanInputsOutputs, input_output.Uid)
+		del = append(del, map[string]interface{}{"uid": input_output.Uid, "connections": nil})
+	}
+	for _, connection := range r.Connections {
+		if !reachable[connection.Uid] {
+			report.OrphanConnections = append(report.OrphanConnections, connection.Uid)
+			del = append(del, map[string]interface{}{"uid": connection.Uid})
+		}
+	}
+	for _, ref := range dangling {
+		report.DanglingConnections = append(report.DanglingConnections, ref.Uid)
+		del = append(del,
+			map[string]interface{}{"uid": ref.Parent, ref.Edge: []map[string]string{{"uid": ref.Uid}}},
+			map[string]interface{}{"uid": ref.Uid},
+		)
+	}
+	for _, session := range r.ExpiredSessions {
+		report.ExpiredSessions = append(report.ExpiredSessions, session.Uid)
+		del = append(del, map[string]interface{}{"uid": session.Uid})
+	}
+	report.sortUids()
+
+	if dryRun || len(del) == 0 {
+		return report, nil
+	}
+	db, err := json.Marshal(del)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := txn.Mutate(ctx, &api.Mutation{DeleteJson: db, CommitNow: true}); err != nil {
+		return nil, dgraphError(err)
+	}
+	return report, nil
+}
+/******************************************************************************
+********************************* End database ********************************
+******************************************************************************/
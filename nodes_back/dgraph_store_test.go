@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/dgo/v210/protos/api"
+)
+
+// dgraphTestAddr is the alpha the Dgraph tests run on, they are skipped
+// when it is not set. Every test drops all the data, use a throwaway
+// cluster, e.g. NODES_TEST_DGRAPH=127.0.0.1:9080 with docker-compose.
+var dgraphTestAddr = os.Getenv("NODES_TEST_DGRAPH")
+
+// openDgraph returns a store on an empty, migrated database.
+func openDgraph(t *testing.T) *DgraphStore {
+	t.Helper()
+	if dgraphTestAddr == "" {
+		t.Skip("NODES_TEST_DGRAPH is not set")
+	}
+	c := defaultConfig().Dgraph
+	c.Addr = dgraphTestAddr
+	s, err := NewDgraphStore(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	if err := s.dg.Alter(context.Background(), &api.Operation{DropAll: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDgraphStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return openDgraph(t) })
+}
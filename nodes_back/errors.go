@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/dgraph-io/dgo/v210"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Domain errors returned by the stores. They are wrapped with the object
+// involved, e.g. fmt.Errorf("module %s: %w", uid, errNotFound), and the
+// handlers turn them into an ErrResponse with ErrStore.
+var (
+	errNotFound    = errors.New("not found")
+	errConflict    = errors.New("conflict")
+	errValidation  = errors.New("invalid")
+	errUnavailable = errors.New("storage unavailable")
+	errForbidden   = errors.New("forbidden")
+	errRateLimited = errors.New("too many requests")
+)
+
+var errModuleNotFound = fmt.Errorf("module %w", errNotFound)
+
+// Application error codes sent as "code" in an ErrResponse.
+const (
+	AppCodeInternal     = 1000
+	AppCodeNotFound     = 1001
+	AppCodeConflict     = 1002
+	AppCodeValidation   = 1003
+	AppCodeUnavailable  = 1004
+	AppCodeUnauthorized = 1005
+	AppCodeForbidden    = 1006
+	AppCodeRateLimited  = 1007
+)
+
+var uidPattern = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
+
+// checkUid rejects anything that isn't a "0x.." uid before it reaches a
+// query or an N-Quad.
+func checkUid(uid string) error {
+	if !uidPattern.MatchString(uid) {
+		return fmt.Errorf("uid %q: %w", uid, errValidation)
+	}
+	return nil
+}
+
+// dgraphError classifies the errors of the Dgraph client: aborted
+// transactions are conflicts and an unreachable alpha is unavailable.
+func dgraphError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, dgo.ErrAborted) {
+		return fmt.Errorf("%w: %v", errConflict, err)
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return fmt.Errorf("%w: %v", errUnavailable, err)
+	}
+	return err
+}
+
+// boltError reports a closed or locked database file as unavailable.
+func boltError(err error) error {
+	if errors.Is(err, bolt.ErrDatabaseNotOpen) || errors.Is(err, bolt.ErrTimeout) {
+		return fmt.Errorf("%w: %v", errUnavailable, err)
+	}
+	return err
+}
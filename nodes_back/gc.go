@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// GCReport lists what the garbage collector found, and removed unless it
+// was a dry run.
+type GCReport struct {
+	DryRun              bool     `json:"dry_run"`
+	OrphanNodes         []string `json:"orphan_nodes,omitempty"`          // nodes of modules that no longer exist
+	OrphanData          []string `json:"orphan_data,omitempty"`           // Data no Node references
+	OrphanInputsOutputs []string `json:"orphan_inputs_outputs,omitempty"` // InputOutput no Node references
+	OrphanConnections   []string `json:"orphan_connections,omitempty"`    // Connection no InputOutput references
+	DanglingConnections []string `json:"dangling_connections,omitempty"`  // Connection whose node_number is gone
+	ExpiredSessions     []string `json:"expired_sessions,omitempty"`      // Session past its expiry
+}
+
+func (r *GCReport) Total() int {
+	return len(r.OrphanNodes) + len(r.OrphanData) + len(r.OrphanInputsOutputs) +
+		len(r.OrphanConnections) + len(r.DanglingConnections) + len(r.ExpiredSessions)
+}
+
+// sortUids keeps the report stable between runs.
+func (r *GCReport) sortUids() {
+	for _, uids := range [][]string{r.OrphanNodes, r.OrphanData, r.OrphanInputsOutputs, r.OrphanConnections, r.DanglingConnections, r.ExpiredSessions} {
+		sort.Slice(uids, func(i, j int) bool { return uidLess(uids[i], uids[j]) })
+	}
+}
+
+// gcScanNodes splits the stored nodes into the ones whose module is gone and
+// the connections of the others that point at a node id missing from their
+// module.
+func gcScanNodes(modules map[string]bool, nodes []*Node) (orphans []*Node, dangling []removedRef) {
+	ids := make(map[string]map[string]bool)
+	for _, node := range nodes {
+		if !modules[node.ModuleUID] {
+			orphans = append(orphans, node)
+			continue
+		}
+		if ids[node.ModuleUID] == nil {
+			ids[node.ModuleUID] = make(map[string]bool)
+		}
+		ids[node.ModuleUID][strconv.Itoa(node.Id)] = true
+	}
+
+	for _, node := range nodes {
+		if !modules[node.ModuleUID] {
+			continue
+		}
+		for _, port := range node.InputsOutputs {
+			for _, c := range port.Connections {
+				if !ids[node.ModuleUID][c.NodeNumber] {
+					dangling = append(dangling, removedRef{Parent: port.Uid, Edge: "connections", Uid: c.Uid})
+				}
+			}
+		}
+	}
+	return orphans, dangling
+}
+
+// runGCLoop collects garbage every interval until ctx is cancelled.
+func runGCLoop(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report, err := store.CollectGarbage(false)
+		if err != nil {
+			errorf("Garbage collection failed: %v", err)
+			continue
+		}
+		if report.Total() > 0 {
+			infof("Garbage collection removed %d objects", report.Total())
+		}
+	}
+}
@@ -2,15 +2,24 @@ module nodes/web-service-gin
 
 go 1.17
 
-require github.com/gin-gonic/gin v1.7.4
+require (
+	github.com/coreos/go-oidc/v3 v3.1.0
+	github.com/dgraph-io/dgo/v210 v210.0.0-20210825123656-d3f867fe9cc3
+	github.com/gin-gonic/gin v1.7.4
+	github.com/go-chi/chi/v5 v5.0.4
+	github.com/go-chi/cors v1.2.0
+	github.com/go-chi/render v1.0.1
+	github.com/golang-jwt/jwt/v4 v4.2.0
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+	golang.org/x/oauth2 v0.0.0-20211104180415-d3ed0bb246c8
+	google.golang.org/grpc v1.41.0
+	gopkg.in/yaml.v2 v2.4.0
+)
 
 require (
-	github.com/dgraph-io/dgo/v210 v210.0.0-20210825123656-d3f867fe9cc3 // indirect
 	github.com/gin-contrib/sse v0.1.0 // indirect
-	github.com/go-chi/chi/v5 v5.0.4 // indirect
-	github.com/go-chi/cors v1.2.0 // indirect
 	github.com/go-chi/docgen v1.2.0 // indirect
-	github.com/go-chi/render v1.0.1 // indirect
 	github.com/go-playground/locales v0.13.0 // indirect
 	github.com/go-playground/universal-translator v0.17.0 // indirect
 	github.com/go-playground/validator/v10 v10.4.1 // indirect
@@ -23,12 +32,11 @@ require (
 	github.com/modern-go/reflect2 v0.0.0-20180701023420-4b7aa43c6742 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/ugorji/go/codec v1.1.7 // indirect
-	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9 // indirect
-	golang.org/x/net v0.0.0-20211007125505-59d4e928ea9d // indirect
+	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2 // indirect
 	golang.org/x/sys v0.0.0-20211007075335-d3039528d8ac // indirect
 	golang.org/x/text v0.3.7 // indirect
+	google.golang.org/appengine v1.6.6 // indirect
 	google.golang.org/genproto v0.0.0-20211007155348-82e027067bd4 // indirect
-	google.golang.org/grpc v1.41.0 // indirect
 	google.golang.org/protobuf v1.27.1 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gopkg.in/square/go-jose.v2 v2.5.1 // indirect
 )
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"nodes/web-service-gin/program"
+)
+
+/***************** Drawflow export ******************/
+// DrawflowExport is the JSON produced by editor.export() in the frontend.
+// Modules are keyed by module uid.
+type DrawflowExport struct {
+	Drawflow map[string]*DrawflowModule `json:"drawflow"`
+}
+
+type DrawflowModule struct {
+	Data map[string]*DrawflowNode `json:"data"`
+}
+
+type DrawflowNode struct {
+	Id       int                      `json:"id"`
+	Name     string                   `json:"name"`
+	Data     map[string]interface{}   `json:"data"`
+	Class    string                   `json:"class"`
+	Html     string                   `json:"html"`
+	Typenode bool                     `json:"typenode"`
+	Inputs   map[string]*DrawflowPort `json:"inputs"`
+	Outputs  map[string]*DrawflowPort `json:"outputs"`
+	PosX     float32                  `json:"pos_x"`
+	PosY     float32                  `json:"pos_y"`
+}
+
+type DrawflowPort struct {
+	Connections []*DrawflowConnection `json:"connections"`
+}
+
+// DrawflowConnection points at the other end of a link. Input ports name the
+// remote output in Input and output ports name the remote input in Output.
+type DrawflowConnection struct {
+	Node   string `json:"node"`
+	Input  string `json:"input,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+// ModuleNodes converts the given module of the export into stored nodes, the
+// same way Drawflow.vue formats a node before sending it to /nodes/create.
+func (e *DrawflowExport) ModuleNodes(module_uid string) ([]*Node, error) {
+	module, ok := e.Drawflow[module_uid]
+	if !ok || module == nil {
+		return nil, fmt.Errorf("module %s is not in the drawflow export", module_uid)
+	}
+
+	nodes := make([]*Node, 0, len(module.Data))
+	for key, drawflow_node := range module.Data {
+		if drawflow_node == nil {
+			continue
+		}
+		if strconv.Itoa(drawflow_node.Id) != key {
+			return nil, fmt.Errorf("node %s has id %d", key, drawflow_node.Id)
+		}
+
+		node := &Node{
+			ModuleUID:  module_uid,
+			Id:         drawflow_node.Id,
+			Name:       drawflow_node.Name,
+			Class:      drawflow_node.Class,
+			Html:       drawflow_node.Html,
+			Typenode:   drawflow_node.Typenode,
+			PosX:       drawflow_node.PosX,
+			PosY:       drawflow_node.PosY,
+			DgraphType: "Node",
+			Data: Data{
+				Name:       drawflowDataString(drawflow_node.Data, "name"),
+				Value:      drawflowDataString(drawflow_node.Data, "value"),
+				Operator:   drawflowDataString(drawflow_node.Data, "operator"),
+				DgraphType: "Data",
+			},
+		}
+		node.InputsOutputs = append(node.InputsOutputs, drawflowPorts(drawflow_node.Inputs, "input")...)
+		node.InputsOutputs = append(node.InputsOutputs, drawflowPorts(drawflow_node.Outputs, "output")...)
+		nodes = append(nodes, node)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Id < nodes[j].Id })
+	return nodes, nil
+}
+
+func drawflowDataString(data map[string]interface{}, key string) string {
+	v, ok := data[key]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func drawflowPorts(ports map[string]*DrawflowPort, port_type string) []*InputOutput {
+	names := make([]string, 0, len(ports))
+	for name := range ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var inputs_outputs []*InputOutput
+	for _, name := range names {
+		input_output := &InputOutput{
+			Name:       name,
+			Type:       port_type,
+			DgraphType: "InputOutput",
+		}
+		if ports[name] != nil {
+			for _, c := range ports[name].Connections {
+				port := c.Input
+				if port_type == "output" {
+					port = c.Output
+				}
+				input_output.Connections = append(input_output.Connections, &Connection{
+					NodeNumber: c.Node,
+					Port:       port,
+					DgraphType: "Connection",
+				})
+			}
+		}
+		inputs_outputs = append(inputs_outputs, input_output)
+	}
+	return inputs_outputs
+}
+
+/***************** Programs ******************/
+// programNodes converts stored nodes for the program package. Links to
+// node numbers that are not a number are dropped.
+func programNodes(nodes []*Node) []*program.Node {
+	converted := make([]*program.Node, 0, len(nodes))
+	for _, node := range nodes {
+		p := &program.Node{
+			ID:       node.Id,
+			Type:     node.Name,
+			Name:     node.Data.Name,
+			Value:    node.Data.Value,
+			Operator: node.Data.Operator,
+		}
+		for _, input_output := range node.InputsOutputs {
+			port := program.Port{Name: input_output.Name}
+			for _, connection := range input_output.Connections {
+				id, err := strconv.Atoi(connection.NodeNumber)
+				if err != nil {
+					continue
+				}
+				port.Links = append(port.Links, program.Link{Node: id, Port: connection.Port})
+			}
+			if input_output.Type == "output" {
+				p.Outputs = append(p.Outputs, port)
+			} else {
+				p.Inputs = append(p.Inputs, port)
+			}
+		}
+		converted = append(converted, p)
+	}
+	return converted
+}
+
+// moduleProgram loads the nodes of a module for the program package.
+func moduleProgram(module_uid string) ([]*program.Node, error) {
+	module, err := store.ModuleOf(module_uid)
+	if err != nil {
+		return nil, err
+	}
+	if module.Uid != module_uid {
+		return nil, fmt.Errorf("module %s: %w", module_uid, errNotFound)
+	}
+	nodes, err := store.ModuleGetNodes(module_uid)
+	if err != nil {
+		return nil, err
+	}
+	return programNodes(nodes), nil
+}
+
+// programError turns the errors of the program package about the graph
+// into validation errors.
+func programError(err error) error {
+	if errors.Is(err, program.ErrInvalidGraph) {
+		return fmt.Errorf("%v: %w", err, errValidation)
+	}
+	return err
+}
+
+// runLimits are the limits of the programs run on the server.
+func runLimits() program.Limits {
+	return program.Limits{
+		Steps:     config.Run.MaxSteps,
+		Timeout:   config.Run.Timeout,
+		ValueBits: config.Run.MaxValueBits,
+		Output:    config.Run.MaxOutput,
+	}
+}
+
+/***************** Graph diff ******************/
+// GraphDiff is the result of saving a whole module graph.
+type GraphDiff struct {
+	Created int     `json:"created"`
+	Updated int     `json:"updated"`
+	Deleted int     `json:"deleted"`
+	Nodes   []*Node `json:"nodes"`
+}
+
+// removedRef is an object dropped from a node that is kept: a port
+// (edge "inputs_outputs" of the node) or a connection (edge "connections"
+// of its port).
+type removedRef struct {
+	Parent string
+	Edge   string
+	Uid    string
+}
+
+// diffGraph matches the stored nodes of a module with the incoming ones by
+// their Drawflow id.
+func diffGraph(existing []*Node, incoming []*Node) (created []*Node, updated [][2]*Node, deleted []*Node) {
+	by_id := make(map[int]*Node, len(existing))
+	for _, node := range existing {
+		by_id[node.Id] = node
+	}
+	for _, node := range incoming {
+		if old, ok := by_id[node.Id]; ok {
+			updated = append(updated, [2]*Node{old, node})
+			delete(by_id, node.Id)
+		} else {
+			created = append(created, node)
+		}
+	}
+	for _, node := range existing {
+		if _, ok := by_id[node.Id]; ok {
+			deleted = append(deleted, node)
+		}
+	}
+	return created, updated, deleted
+}
+
+// mergeNode applies an incoming node onto the stored one. Data, ports and
+// connections that still exist keep their uid, new ones get one from newUid.
+// It returns the merged node and the ports and connections that are gone.
+func mergeNode(existing *Node, incoming *Node, newUid func() (string, error)) (*Node, []removedRef, error) {
+	var err error
+	merged := *incoming
+	merged.Uid = existing.Uid
+	merged.ModuleUID = existing.ModuleUID
+	merged.DgraphType = "Node"
+	merged.Data.Uid = existing.Data.Uid
+	if merged.Data.Uid == "" {
+		if merged.Data.Uid, err = newUid(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var removed []removedRef
+	old_ports := make(map[string]*InputOutput, len(existing.InputsOutputs))
+	for _, port := range existing.InputsOutputs {
+		old_ports[port.Type+"/"+port.Name] = port
+	}
+
+	merged.InputsOutputs = make([]*InputOutput, 0, len(incoming.InputsOutputs))
+	for _, in_port := range incoming.InputsOutputs {
+		port := *in_port
+		port.DgraphType = "InputOutput"
+		port.Connections = make([]*Connection, 0, len(in_port.Connections))
+
+		old_port, ok := old_ports[port.Type+"/"+port.Name]
+		old_connections := make(map[string]*Connection)
+		if ok {
+			port.Uid = old_port.Uid
+			delete(old_ports, port.Type+"/"+port.Name)
+			for _, c := range old_port.Connections {
+				old_connections[c.NodeNumber+"/"+c.Port] = c
+			}
+		} else if port.Uid, err = newUid(); err != nil {
+			return nil, nil, err
+		}
+
+		for _, in_connection := range in_port.Connections {
+			connection := *in_connection
+			connection.DgraphType = "Connection"
+			if old, ok := old_connections[connection.NodeNumber+"/"+connection.Port]; ok {
+				connection.Uid = old.Uid
+				delete(old_connections, connection.NodeNumber+"/"+connection.Port)
+			} else if connection.Uid, err = newUid(); err != nil {
+				return nil, nil, err
+			}
+			port.Connections = append(port.Connections, &connection)
+		}
+		for _, c := range old_connections {
+			removed = append(removed, removedRef{Parent: port.Uid, Edge: "connections", Uid: c.Uid})
+		}
+		merged.InputsOutputs = append(merged.InputsOutputs, &port)
+	}
+
+	for _, port := range old_ports {
+		for _, c := range port.Connections {
+			removed = append(removed, removedRef{Parent: port.Uid, Edge: "connections", Uid: c.Uid})
+		}
+		removed = append(removed, removedRef{Parent: existing.Uid, Edge: "inputs_outputs", Uid: port.Uid})
+	}
+	return &merged, removed, nil
+}
+
+// peerConnections finds the connections of the remaining nodes that point
+// at one of the deleted nodes, through its Drawflow id.
+func peerConnections(nodes []*Node, deleted []*Node) []removedRef {
+	gone := make(map[string]bool, len(deleted))
+	ids := make(map[string]bool, len(deleted))
+	for _, node := range deleted {
+		gone[node.Uid] = true
+		ids[strconv.Itoa(node.Id)] = true
+	}
+
+	var refs []removedRef
+	for _, node := range nodes {
+		if gone[node.Uid] {
+			continue
+		}
+		for _, port := range node.InputsOutputs {
+			for _, c := range port.Connections {
+				if ids[c.NodeNumber] {
+					refs = append(refs, removedRef{Parent: port.Uid, Edge: "connections", Uid: c.Uid})
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// removeConnections drops the referenced connections from the nodes.
+func removeConnections(nodes []*Node, refs []removedRef) {
+	drop := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		drop[ref.Uid] = true
+	}
+	for _, node := range nodes {
+		for _, port := range node.InputsOutputs {
+			connections := port.Connections[:0]
+			for _, c := range port.Connections {
+				if !drop[c.Uid] {
+					connections = append(connections, c)
+				}
+			}
+			port.Connections = connections
+		}
+	}
+}
+
+// nodeUids lists the uids of a node and everything it embeds.
+func nodeUids(node *Node) []string {
+	uids := []string{node.Uid}
+	if node.Data.Uid != "" {
+		uids = append(uids, node.Data.Uid)
+	}
+	for _, port := range node.InputsOutputs {
+		uids = append(uids, port.Uid)
+		for _, c := range port.Connections {
+			uids = append(uids, c.Uid)
+		}
+	}
+	return uids
+}
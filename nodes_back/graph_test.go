@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func testPort(uid string, name string, port_type string, connections ...*Connection) *InputOutput {
+	return &InputOutput{Uid: uid, Name: name, Type: port_type, Connections: connections}
+}
+
+func testConnection(uid string, node_number string, port string) *Connection {
+	return &Connection{Uid: uid, NodeNumber: node_number, Port: port}
+}
+
+// sequentialUids hands out "new1", "new2"...
+func sequentialUids() func() (string, error) {
+	n := 0
+	return func() (string, error) {
+		n++
+		return fmt.Sprintf("new%d", n), nil
+	}
+}
+
+// nodeIds lists the Drawflow ids of nodes.
+func nodeIds(nodes []*Node) []int {
+	ids := []int{}
+	for _, node := range nodes {
+		ids = append(ids, node.Id)
+	}
+	return ids
+}
+
+func TestDiffGraph(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []int
+		incoming []int
+		created  []int
+		updated  []int
+		deleted  []int
+	}{
+		{"empty", nil, nil, []int{}, []int{}, []int{}},
+		{"first save", nil, []int{1, 2}, []int{1, 2}, []int{}, []int{}},
+		{"unchanged", []int{1, 2}, []int{1, 2}, []int{}, []int{1, 2}, []int{}},
+		{"mixed", []int{1, 2, 3}, []int{3, 4, 2}, []int{4}, []int{3, 2}, []int{1}},
+		{"all deleted", []int{5, 6}, nil, []int{}, []int{}, []int{5, 6}},
+	}
+	for _, tt := range tests {
+		var existing, incoming []*Node
+		for _, id := range tt.existing {
+			existing = append(existing, &Node{Uid: fmt.Sprintf("0x%d", id), Id: id})
+		}
+		for _, id := range tt.incoming {
+			incoming = append(incoming, &Node{Id: id})
+		}
+
+		created, updated, deleted := diffGraph(existing, incoming)
+		updated_ids := []int{}
+		for _, pair := range updated {
+			if pair[0].Id != pair[1].Id || pair[0].Uid == "" || pair[1].Uid != "" {
+				t.Errorf("%s: updated pair %+v, %+v does not match a stored node with an incoming one", tt.name, pair[0], pair[1])
+			}
+			updated_ids = append(updated_ids, pair[1].Id)
+		}
+		if got := nodeIds(created); !reflect.DeepEqual(got, tt.created) {
+			t.Errorf("%s: created = %v, want %v", tt.name, got, tt.created)
+		}
+		if !reflect.DeepEqual(updated_ids, tt.updated) {
+			t.Errorf("%s: updated = %v, want %v", tt.name, updated_ids, tt.updated)
+		}
+		if got := nodeIds(deleted); !reflect.DeepEqual(got, tt.deleted) {
+			t.Errorf("%s: deleted = %v, want %v", tt.name, got, tt.deleted)
+		}
+	}
+}
+
+func TestMergeNode(t *testing.T) {
+	// stored has an input linked to node 2 and an output linked to node 3
+	stored := func() *Node {
+		return &Node{
+			Uid: "0x1", ModuleUID: "0xm", Id: 1, Name: "addition",
+			Data: Data{Uid: "0xd"},
+			InputsOutputs: []*InputOutput{
+				testPort("0xi", "input_1", "input", testConnection("0xc1", "2", "output_1")),
+				testPort("0xo", "output_1", "output", testConnection("0xc2", "3", "input_1")),
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		existing *Node
+		incoming *Node
+		// uids of the merged node, its data, then each port followed by
+		// its connections
+		uids    []string
+		removed []removedRef
+	}{
+		{
+			name:     "unchanged",
+			existing: stored(),
+			incoming: &Node{Id: 1, Name: "addition", InputsOutputs: []*InputOutput{
+				testPort("", "input_1", "input", testConnection("", "2", "output_1")),
+				testPort("", "output_1", "output", testConnection("", "3", "input_1")),
+			}},
+			uids: []string{"0x1", "0xd", "0xi", "0xc1", "0xo", "0xc2"},
+		},
+		{
+			name:     "connection added and dropped",
+			existing: stored(),
+			incoming: &Node{Id: 1, Name: "addition", InputsOutputs: []*InputOutput{
+				testPort("", "input_1", "input", testConnection("", "2", "output_1"), testConnection("", "4", "output_1")),
+				testPort("", "output_1", "output"),
+			}},
+			uids:    []string{"0x1", "0xd", "0xi", "0xc1", "new1", "0xo"},
+			removed: []removedRef{{Parent: "0xo", Edge: "connections", Uid: "0xc2"}},
+		},
+		{
+			name:     "connection moved to another port",
+			existing: stored(),
+			incoming: &Node{Id: 1, Name: "addition", InputsOutputs: []*InputOutput{
+				testPort("", "input_1", "input", testConnection("", "2", "output_2")),
+				testPort("", "output_1", "output", testConnection("", "3", "input_1")),
+			}},
+			uids:    []string{"0x1", "0xd", "0xi", "new1", "0xo", "0xc2"},
+			removed: []removedRef{{Parent: "0xi", Edge: "connections", Uid: "0xc1"}},
+		},
+		{
+			name:     "port dropped and added",
+			existing: stored(),
+			incoming: &Node{Id: 1, Name: "addition", InputsOutputs: []*InputOutput{
+				testPort("", "input_1", "input", testConnection("", "2", "output_1")),
+				testPort("", "input_2", "input", testConnection("", "5", "output_1")),
+			}},
+			uids: []string{"0x1", "0xd", "0xi", "0xc1", "new1", "new2"},
+			removed: []removedRef{
+				{Parent: "0xo", Edge: "connections", Uid: "0xc2"},
+				{Parent: "0x1", Edge: "inputs_outputs", Uid: "0xo"},
+			},
+		},
+		{
+			name:     "same name, other type",
+			existing: stored(),
+			incoming: &Node{Id: 1, Name: "addition", InputsOutputs: []*InputOutput{
+				testPort("", "input_1", "output"),
+			}},
+			uids: []string{"0x1", "0xd", "new1"},
+			removed: []removedRef{
+				{Parent: "0xi", Edge: "connections", Uid: "0xc1"},
+				{Parent: "0x1", Edge: "inputs_outputs", Uid: "0xi"},
+				{Parent: "0xo", Edge: "connections", Uid: "0xc2"},
+				{Parent: "0x1", Edge: "inputs_outputs", Uid: "0xo"},
+			},
+		},
+		{
+			name:     "stored without data",
+			existing: &Node{Uid: "0x1", ModuleUID: "0xm", Id: 1},
+			incoming: &Node{Id: 1, Name: "number", Data: Data{Value: "7"}},
+			uids:     []string{"0x1", "new1"},
+		},
+	}
+	for _, tt := range tests {
+		merged, removed, err := mergeNode(tt.existing, tt.incoming, sequentialUids())
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := nodeUids(merged); !reflect.DeepEqual(got, tt.uids) {
+			t.Errorf("%s: uids = %v, want %v", tt.name, got, tt.uids)
+		}
+		sortRefs(removed)
+		sortRefs(tt.removed)
+		if !reflect.DeepEqual(removed, tt.removed) {
+			t.Errorf("%s: removed = %v, want %v", tt.name, removed, tt.removed)
+		}
+		if merged.ModuleUID != tt.existing.ModuleUID || merged.Name != tt.incoming.Name || merged.Data.Value != tt.incoming.Data.Value {
+			t.Errorf("%s: merged %+v does not take the module of the stored node and the rest of the incoming one", tt.name, merged)
+		}
+		if tt.incoming.Uid != "" || tt.incoming.Data.Uid != "" {
+			t.Errorf("%s: mergeNode changed the incoming node", tt.name)
+		}
+	}
+}
+
+func TestMergeNodeUidError(t *testing.T) {
+	failing := func() (string, error) { return "", fmt.Errorf("out of uids") }
+	incoming := &Node{Id: 1, InputsOutputs: []*InputOutput{testPort("", "input_1", "input")}}
+	if _, _, err := mergeNode(&Node{Uid: "0x1", Data: Data{Uid: "0xd"}}, incoming, failing); err == nil {
+		t.Error("mergeNode succeeded without uids for a new port")
+	}
+}
+
+func TestPeerConnections(t *testing.T) {
+	nodes := []*Node{
+		{Uid: "0x1", Id: 1, InputsOutputs: []*InputOutput{
+			testPort("0xi1", "input_1", "input", testConnection("0xc1", "2", "output_1"), testConnection("0xc2", "3", "output_1")),
+		}},
+		{Uid: "0x2", Id: 2, InputsOutputs: []*InputOutput{
+			testPort("0xo2", "output_1", "output", testConnection("0xc3", "1", "input_1")),
+		}},
+		{Uid: "0x3", Id: 3, InputsOutputs: []*InputOutput{
+			testPort("0xo3", "output_1", "output", testConnection("0xc4", "1", "input_1")),
+		}},
+	}
+	refs := peerConnections(nodes, []*Node{nodes[1]})
+	want := []removedRef{{Parent: "0xi1", Edge: "connections", Uid: "0xc1"}}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("peerConnections = %v, want %v", refs, want)
+	}
+
+	removeConnections(nodes, refs)
+	if got := nodeUids(nodes[0]); !reflect.DeepEqual(got, []string{"0x1", "0xi1", "0xc2"}) {
+		t.Errorf("after removeConnections node 1 has %v", got)
+	}
+}
+
+func TestModuleNodes(t *testing.T) {
+	export := &DrawflowExport{Drawflow: map[string]*DrawflowModule{
+		"0xm": {Data: map[string]*DrawflowNode{
+			"2": {Id: 2, Name: "assign", Data: map[string]interface{}{"name": "x"},
+				Inputs: map[string]*DrawflowPort{"input_1": {Connections: []*DrawflowConnection{{Node: "1", Input: "output_1"}}}}},
+			"1": {Id: 1, Name: "number", Data: map[string]interface{}{"value": 7.0},
+				Outputs: map[string]*DrawflowPort{"output_1": {Connections: []*DrawflowConnection{{Node: "2", Output: "input_1"}}}}},
+		}},
+	}}
+
+	nodes, err := export.ModuleNodes("0xm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeIds(nodes); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Fatalf("nodes %v, want 1 and 2", got)
+	}
+	if nodes[0].Data.Value != "7" || nodes[1].Data.Name != "x" {
+		t.Errorf("data %+v and %+v, want value 7 and name x", nodes[0].Data, nodes[1].Data)
+	}
+	output := nodes[0].InputsOutputs[0]
+	if output.Type != "output" || output.Connections[0].NodeNumber != "2" || output.Connections[0].Port != "input_1" {
+		t.Errorf("output of node 1 is %+v, want a link to input_1 of node 2", output)
+	}
+	input := nodes[1].InputsOutputs[0]
+	if input.Type != "input" || input.Connections[0].NodeNumber != "1" || input.Connections[0].Port != "output_1" {
+		t.Errorf("input of node 2 is %+v, want a link from output_1 of node 1", input)
+	}
+
+	if _, err := export.ModuleNodes("0xother"); err == nil {
+		t.Error("ModuleNodes of a module missing from the export succeeded")
+	}
+	export.Drawflow["0xm"].Data["3"] = &DrawflowNode{Id: 4}
+	if _, err := export.ModuleNodes("0xm"); err == nil {
+		t.Error("ModuleNodes with a node keyed by another id succeeded")
+	}
+}
+
+func sortRefs(refs []removedRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Parent != refs[j].Parent {
+			return refs[i].Parent < refs[j].Parent
+		}
+		return refs[i].Uid < refs[j].Uid
+	})
+}
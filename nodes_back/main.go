@@ -4,79 +4,126 @@ import (
 	"context"
 	"errors"
 	"flag"
-	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
-	"strconv"
-
-	"github.com/dgraph-io/dgo/v210"
-    "github.com/dgraph-io/dgo/v210/protos/api"
-	"google.golang.org/grpc"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/go-chi/render"
+
+	"nodes/web-service-gin/program"
 )
 
-type CancelFunc func()
+func main() {
+	flag.Parse()
 
-func getDgraphClient() (*dgo.Dgraph, CancelFunc) {
-	conn, err := grpc.Dial("127.0.0.1:9080", grpc.WithInsecure())
+	var err error
+	config, err = loadConfig()
 	if err != nil {
-		log.Fatal("While trying to dial gRPC")
+		log.Fatal(err)
 	}
+	logins = newLoginLimiter(config)
+	debugger = newDebugSessions(config)
 
-	dc := api.NewDgraphClient(conn)
-	dg := dgo.NewDgraphClient(dc)
-	ctx := context.Background()
-
-	for {
+	// The mock provider needs no store
+	if flag.Arg(0) == "mock-oidc" {
+		if err := runMockOIDC(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-		err = dg.Login(ctx, "groot", "password")
-		if err == nil || !strings.Contains(err.Error(), "Please retry") {
-			break
+	if config.AuditLog != "" {
+		f, err := openAuditLog(config.AuditLog)
+		if err != nil {
+			log.Fatal(err)
 		}
-		time.Sleep(time.Second)
+		defer f.Close()
 	}
+
+	store, err = newStore(config)
 	if err != nil {
-		log.Fatalf("While trying to login %v", err.Error())
+		log.Fatal(err)
 	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			errorf("Closing store: %v", err)
+		}
+	}()
 
-	return dg, func() {
-		if err := conn.Close(); err != nil {
-			log.Printf("Error while closing connection:%v", err)
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(store); err != nil {
+			log.Fatal(err)
 		}
+		return
 	}
-}
 
-func main() {
-	//log.Printf(dbCreateUser("diego", "test"))
-	//log.Printf(dbCreateUser("mario", "test"))
+	// Bring the schema up to date before serving, and before the other
+	// subcommands, which query it as the server does
+	if m, ok := store.(Migrator); ok {
+		if err := m.Migrate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch flag.Arg(0) {
+	case "gc":
+		if err := runGC(store, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "role":
+		if err := runRole(store, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if config.GCInterval > 0 {
+		gcCtx, stopGC := context.WithCancel(context.Background())
+		gcDone := make(chan struct{})
+		go func() {
+			defer close(gcDone)
+			runGCLoop(gcCtx, store, config.GCInterval)
+		}()
+		// Stop the loop, and wait for a collection under way, before the store is closed
+		defer func() {
+			stopGC()
+			<-gcDone
+		}()
+	}
 
-	flag.Parse()
 	r := chi.NewRouter()
 
 	// Basic CORS
 	// for more ideas, see: https://developer.github.com/v3/#cross-origin-resource-sharing
 	r.Use(cors.Handler(cors.Options{
-		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
-		AllowedOrigins:   []string{"https://*", "http://*"},
+		AllowedOrigins:   config.CORSOrigins,
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "Retry-After"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
+	if logLevel <= levelInfo {
+		r.Use(middleware.Logger)
+	}
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.URLFormat)
+	r.Use(middleware.Timeout(config.RequestTimeout))
 	r.Use(render.SetContentType(render.ContentTypeJSON))
 
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
@@ -93,20 +140,45 @@ func main() {
 
 	// RESTy routes for "modules" resource
 	r.Route("/modules", func(r chi.Router) {
+		r.Use(Authenticator)
 		r.Post("/", ListModules)
+		r.Get("/", ListModules) // Same, for read-only API keys
 		r.Post("/create", CreateModule)
 		r.Post("/search", SearchModuleByName)
 		r.Route("/{moduleUID}", func(r chi.Router) {
-			r.Put("/", ClearModule) // Clear /modules/123
-			r.Delete("/", DeleteModule) // DELETE /modules/123
+			r.Group(func(r chi.Router) {
+				r.Use(ViewerOnly("moduleUID"))
+				r.Get("/", GetModule) // GET /modules/123, also for the teacher and admins
+				r.Get("/comments", ListComments)
+				r.Get("/code", ModuleCode) // GET /modules/123/code?lang=python
+				r.Post("/run", RunModule) // Run the graph on the server, it changes nothing
+				r.Post("/debug", StartDebug) // Debugging session, stopped before the first node
+				r.Route("/debug/{sessionID}", func(r chi.Router) {
+					r.Use(DebugSessionCtx)
+					r.Get("/", GetDebug) // Current node, variables and output
+					r.Put("/breakpoints", SetBreakpoints) // {"breakpoints": [node ids]}
+					r.Post("/step", StepDebug) // Run the current node
+					r.Post("/continue", ContinueDebug) // Run up to a breakpoint or the end
+					r.Delete("/", EndDebug)
+				})
+				r.Post("/comments", CreateComment)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(OwnerOnly("moduleUID"))
+				r.Put("/", ClearModule) // Clear /modules/123
+				r.Delete("/", DeleteModule) // DELETE /modules/123
+				r.Put("/graph", SaveModuleGraph) // Save the whole drawflow export /modules/123/graph
+			})
 		})
 	})
 
 	// RESTy routes for "nodes" resource
 	r.Route("/nodes", func(r chi.Router) {
+		r.Use(Authenticator)
 		r.Post("/create", CreateNode)
 		r.Post("/data", DataNode)
 		r.Route("/{nodeUID}", func(r chi.Router) {
+			r.Use(OwnerOnly("nodeUID"))
 			r.Put("/", PositionNode) // Change position /nodes/123
 			r.Delete("/", DeleteNode) // DELETE /nodes/123
 		})
@@ -118,10 +190,95 @@ func main() {
 
 	// RESTy routes for "user" resource
 	r.Route("/user", func(r chi.Router) {
-		r.Post("/login", SignIn)
+		r.With(LoginRateLimit).Post("/login", SignIn)
+		r.Post("/register", Register)
+		r.Post("/refresh", RefreshToken) // New access token for a refresh token
+		r.Post("/logout", Logout) // Ends the session of a refresh token
+		r.Route("/oidc", func(r chi.Router) {
+			r.Get("/", OIDCInfo) // {"enabled": true} when an OpenID provider is configured
+			r.Get("/login", OIDCLogin) // Redirects to the provider
+			r.Get("/callback", OIDCCallback) // The provider redirects back here
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(Authenticator)
+			r.Use(SessionOnly)
+			r.Put("/password", ChangePassword)
+			r.Delete("/", DeleteAccount) // DELETE /user, with its modules and nodes
+			r.Get("/keys", ListAPIKeys)
+			r.Post("/keys", CreateAPIKey) // The secret is only shown in this response
+			r.Delete("/keys/{keyUID}", RevokeAPIKey)
+		})
+	})
+
+	r.Route("/groups", func(r chi.Router) {
+		r.Use(Authenticator)
+		r.Get("/", ListMyGroups) // Groups I teach or belong to
+		r.Post("/join", JoinGroup) // POST /groups/join {"code": "..."}
+		r.Get("/{groupUID}/members", GroupMembers) // Members and their modules, for the teacher
+		r.Group(func(r chi.Router) {
+			r.Use(RequireRole(roleTeacher, roleAdmin))
+			r.Post("/create", CreateGroup)
+		})
+	})
+
+	r.Route("/teacher", func(r chi.Router) {
+		r.Use(Authenticator)
+		r.Use(RequireRole(roleTeacher, roleAdmin))
+		r.Get("/modules", TeacherModules) // Modules of the students in my groups
+	})
+
+	// Maintenance routes
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(Authenticator)
+		r.Use(RequireRole(roleAdmin))
+		r.Post("/gc", CollectGarbage) // POST /admin/gc?dry_run=true
+		r.Get("/users", ListUsers)
+		r.Put("/users/{username}/role", UpdateRole)
+		r.Delete("/users/{username}", AdminDeleteUser)
+		r.Delete("/users/{username}/sessions", RevokeSessions) // Log the user out everywhere
+		r.Get("/groups", ListGroups)
+		r.Post("/groups", AdminCreateGroup)
 	})
 
-	http.ListenAndServe(":3333", r)
+	srv := &http.Server{
+		Addr:         config.Listen,
+		Handler:      r,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+	}
+	if err := serve(srv, config.ShutdownTimeout); err != nil {
+		errorf("%v", err)
+	}
+}
+
+// serve runs srv until SIGINT or SIGTERM, then stops accepting connections
+// and waits up to timeout for the requests in flight, so a restart doesn't
+// cut a graph save in half. The store is closed by main() afterwards.
+func serve(srv *http.Server, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		infof("Listening on %s", srv.Addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	infof("Shutting down, waiting up to %v for requests in flight", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
 }
 
 /***************** Models ******************/
@@ -176,18 +333,71 @@ type User struct {
 	Uid        	string		`json:"uid,omitempty"`
 	Username	string 		`json:"username,omitempty"`
 	Password   	string  	`json:"password,omitempty"`
+	Role		string		`json:"role,omitempty"` // student (also when empty), teacher or admin
+	// The OpenID provider and subject of the users it created, nothing for
+	// the password accounts
+	OIDCIssuer	string		`json:"oidc_issuer,omitempty"`
+	OIDCSubject	string		`json:"oidc_subject,omitempty"`
 	DgraphType 	string    	`json:"dgraph.type,omitempty"`
 }
 
+// Group is a class: a teacher and the usernames of its students, who join
+// by typing its code.
+type Group struct {
+	Uid			string		`json:"uid,omitempty"`
+	Name		string		`json:"name,omitempty"`
+	Teacher		string		`json:"teacher,omitempty"`
+	Members		[]string	`json:"members,omitempty"`
+	Code		string		`json:"code,omitempty"`
+	DgraphType	string		`json:"dgraph.type,omitempty"`
+}
+
+// Comment is a note left on a module, usually by a teacher.
+type Comment struct {
+	Uid			string		`json:"uid,omitempty"`
+	ModuleUID	string		`json:"module_uid,omitempty"`
+	Author		string		`json:"author,omitempty"`
+	Body		string		`json:"body,omitempty"`
+	CreatedAt	time.Time	`json:"created_at,omitempty"`
+	DgraphType	string		`json:"dgraph.type,omitempty"`
+}
+
+// Session is a login on one device. The client keeps the refresh token,
+// the store only its SHA-256 hash.
+type Session struct {
+	Uid			string		`json:"uid,omitempty"`
+	Username	string		`json:"username,omitempty"`
+	TokenHash	string		`json:"token_hash,omitempty"`
+	CreatedAt	time.Time	`json:"created_at,omitempty"`
+	ExpiresAt	time.Time	`json:"expires_at,omitempty"`
+	DgraphType	string		`json:"dgraph.type,omitempty"`
+}
+
+// APIKey lets a script act as its user without logging in. Like sessions,
+// the store only keeps the SHA-256 hash of the secret.
+type APIKey struct {
+	Uid			string		`json:"uid,omitempty"`
+	Username	string		`json:"username,omitempty"`
+	Name		string		`json:"name,omitempty"`
+	KeyHash		string		`json:"key_hash,omitempty"`
+	ReadOnly	bool		`json:"read_only,omitempty"`
+	CreatedAt	time.Time	`json:"created_at,omitempty"`
+	DgraphType	string		`json:"dgraph.type,omitempty"`
+}
+
 type ErrResponse struct {
 	Err            error `json:"-"` // low-level runtime error
 	HTTPStatusCode int   `json:"-"` // http response status code
 	StatusText string `json:"status"`          // user-level status message
 	AppCode    int64  `json:"code,omitempty"`  // application-specific error code
 	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
+	RetryAfter time.Duration `json:"-"`       // sent as the Retry-After header
 }
 
 func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
 	render.Status(r, e.HTTPStatusCode)
 	return nil
 }
@@ -210,6 +420,47 @@ func ErrRender(err error) render.Renderer {
 	}
 }
 
+func ErrUnauthorized(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 401,
+		StatusText:     "Authentication required.",
+		AppCode:        AppCodeUnauthorized,
+		ErrorText:      err.Error(),
+	}
+}
+
+// ErrRateLimited asks the client to wait before trying again.
+func ErrRateLimited(err error, wait time.Duration) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 429,
+		StatusText:     "Too many requests.",
+		AppCode:        AppCodeRateLimited,
+		ErrorText:      err.Error(),
+		RetryAfter:     wait,
+	}
+}
+
+// ErrStore maps the domain errors of the store onto the HTTP status and
+// AppCode of the response.
+func ErrStore(err error) render.Renderer {
+	switch {
+	case errors.Is(err, errNotFound):
+		return &ErrResponse{Err: err, HTTPStatusCode: 404, StatusText: "Resource not found.", AppCode: AppCodeNotFound, ErrorText: err.Error()}
+	case errors.Is(err, errConflict):
+		return &ErrResponse{Err: err, HTTPStatusCode: 409, StatusText: "Conflict.", AppCode: AppCodeConflict, ErrorText: err.Error()}
+	case errors.Is(err, errForbidden):
+		return &ErrResponse{Err: err, HTTPStatusCode: 403, StatusText: "Forbidden.", AppCode: AppCodeForbidden, ErrorText: err.Error()}
+	case errors.Is(err, errValidation):
+		return &ErrResponse{Err: err, HTTPStatusCode: 422, StatusText: "Validation failed.", AppCode: AppCodeValidation, ErrorText: err.Error()}
+	case errors.Is(err, errUnavailable):
+		return &ErrResponse{Err: err, HTTPStatusCode: 503, StatusText: "Storage unavailable.", AppCode: AppCodeUnavailable, ErrorText: err.Error()}
+	}
+	errorf("Store error: %v", err)
+	return &ErrResponse{Err: err, HTTPStatusCode: 500, StatusText: "Internal server error.", AppCode: AppCodeInternal, ErrorText: err.Error()}
+}
+
 // paginate is a stub, but very possible to implement middleware logic
 // to handle the request params for handling a paginated request.
 func paginate(next http.Handler) http.Handler {
@@ -223,784 +474,448 @@ func paginate(next http.Handler) http.Handler {
 var ErrNotFound = &ErrResponse{HTTPStatusCode: 404, StatusText: "Resource not found."}
 
 
-/******************************************************************************
-********************************* Start database ******************************
-******************************************************************************/
-func dbCreateUser(username string, password string) string {
-	var result string
-	result = "USERNAME_ALREADY_REGISTERED"
-
-	//Check if exists an user with this username
-	users := getUsersByUsername(username)
-	if len(users) > 0 {
-		return result
-	}
 
-	dg, cancel := getDgraphClient()
-	defer cancel()
-	
-	user := User{
-		Username: username,
-		Password: password,
-		DgraphType: "User",
-	}
-
-	ou := &api.Operation{}
-	ou.Schema = `
-		username: string @index(exact) .
-		password: string .
-		type: string .
-		type User {
-			username: string
-			password: string
-		}
-	`
-	ctx := context.Background()
-	if err := dg.Alter(ctx, ou); err != nil {
-		log.Fatal(err)
-	}
 
-	mu := &api.Mutation{
-		CommitNow: true,
-	}
-	ub, err := json.Marshal(user)
-	if err != nil {
-		log.Fatal(err)
-	}
 
-	mu.SetJson = ub
-	response, err := dg.NewTxn().Mutate(ctx, mu)
-	if err != nil {
-		log.Fatal(err)
-	}
-	
-	result = "USER_NOT_CREATED"
-	//Get created program uid
-	for _, value := range response.Uids {
-		result = value
-	}
+/******************************************************************************
+********************************* Start Api Rest ******************************
+******************************************************************************/
 
-	return result
+/******************************* Start Users ********************************/
+type UserRequest struct {
+	*User
 }
 
-func getAllUsers(username string) string {
-	dg, cancel := getDgraphClient()
-	defer cancel()
+type custom_error struct {
+	Field 	string `json:"field,omitempty"`
+	Message string `json:"message,omitempty"`
+}
 
-	vars := make(map[string]string)
-	vars["$enusername"] = username
-	q := `query wanghausers($enusername: string){
-		users(func: eq(username, $enusername)){
-			uid
-			expand(_all_)
-		}
-	}`
+type LoginResponse struct {
+	Token 		string 			`json:"token,omitempty"`
+	ExpiresAt	int64			`json:"expires_at,omitempty"` // unix time
+	RefreshToken	string		`json:"refresh_token,omitempty"`
+	RefreshExpiresAt	int64	`json:"refresh_expires_at,omitempty"` // unix time
+	Username	string			`json:"username,omitempty"` 
+	Role		string			`json:"role,omitempty"`
+	Errors 		[]custom_error	`json:"errors,omitempty"`
+}
 
-	ctx := context.Background()
+func (rd *LoginResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	return nil
+}
 
-	resp, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
-	if err != nil {
-		log.Println(err)
-	}
+func (rd *LoginResponse) setTokens(tokens *loginTokens) {
+	rd.Token = tokens.Access
+	rd.ExpiresAt = tokens.AccessExpires.Unix()
+	rd.RefreshToken = tokens.Refresh
+	rd.RefreshExpiresAt = tokens.RefreshExpires.Unix()
+}
 
-	type arrays struct{
-		Uids	[]User `json:"users"`
+func (a *UserRequest) Bind(r *http.Request) error {
+	if a.User == nil {
+		return errors.New("missing required User fields.")
 	}
+	return nil
+}
 
-	var r arrays
-	err = json.Unmarshal([]byte(resp.Json), &r)
-	if err != nil{
-		log.Println(err)
+// SignIn checks the credentials and returns a login token. Unknown
+// usernames and wrong passwords get the same message, and a username is
+// locked for a while after too many failures in a row.
+func SignIn(w http.ResponseWriter, r *http.Request) {
+	data := &UserRequest{}
+	var errors []custom_error
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
 
-	//Return users
-	fmt.Printf("response: %v",resp)
-	return string(resp.Json)
-}
-
-func getUsersByUsername(username string) []User {
-	dg, cancel := getDgraphClient()
-	defer cancel()
+	resp := &LoginResponse{}
 
-	vars := make(map[string]string)
-	vars["$enusername"] = username
-	q := `query wanghausers($enusername: string){
-		users(func: eq(username, $enusername)){
-			uid
-			expand(_all_)
-		}
-	}`
+	if(data.Username == ""){
+		errors = append(errors, custom_error {
+			Field: "username", 
+			Message: "El nombre de usuario es obligatorio",
+		})
+	}
+	if(data.Password == ""){
+		errors = append(errors, custom_error {
+			Field: "password", 
+			Message: "La contraseña es obligatoria",
+		})
+	}
+	if len(errors) > 0 {
+		resp.Errors = errors
+		render.Status(r, http.StatusCreated)
+		render.Render(w, r, resp)
+		return
+	}
 
-	ctx := context.Background()
+	ip := clientIP(r)
+	if wait := logins.locked(data.Username); wait > 0 {
+		auditf("login_failed user=%q ip=%s reason=locked", data.Username, ip)
+		render.Render(w, r, ErrRateLimited(fmt.Errorf("user %s is locked: %w", data.Username, errRateLimited), wait))
+		return
+	}
 
-	resp, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
+	//Get user from database
+	users, err := store.GetUsersByUsername(data.Username)
 	if err != nil {
-		log.Println(err)
+		render.Render(w, r, ErrStore(err))
+		return
 	}
-
-	type arrays struct{
-		Uids	[]User `json:"users"`
+	var user *User
+	ok, legacy := false, false
+	if len(users) > 0 {
+		user = &users[0]
+		ok, legacy = checkPassword(user.Password, data.Password)
+	} else {
+		checkPassword(unknownUserHash, data.Password)
 	}
 
-	var r arrays
-	err = json.Unmarshal([]byte(resp.Json), &r)
-	if err != nil{
-		log.Println(err)
+	if !ok {
+		reason := "wrong_password"
+		if user == nil {
+			reason = "unknown_user"
+		}
+		auditf("login_failed user=%q ip=%s reason=%s", data.Username, ip, reason)
+		if logins.fail(data.Username) {
+			auditf("login_locked user=%q ip=%s duration=%v", data.Username, ip, config.LoginLockout)
+		}
+		resp.Errors = []custom_error{{
+			Field: "password",
+			Message: "Usuario o contraseña incorrectos",
+		}}
+		render.Status(r, http.StatusCreated)
+		render.Render(w, r, resp)
+		return
 	}
+	logins.succeed(data.Username)
 
-	//Return users as string
-	//return string(resp.Json)
-
-	//Return User array
-	return r.Uids
-}
-
-func dbDeleteAnyByUidType(uid string, borrar string) bool {
-	dg, cancel := getDgraphClient()
-	defer cancel()
-	ctx := context.Background()
-
-	d := map[string]string{"uid":uid}
-	ub, err := json.Marshal(d)
-
-	mu := &api.Mutation{
-		CommitNow: true,
-		DeleteJson: ub,
+	if legacy {
+		// Account created before passwords were hashed
+		hash, err := hashPassword(data.Password)
+		if err == nil {
+			err = store.UpdatePassword(user.Username, hash)
+		}
+		if err != nil {
+			errorf("Hashing the password of %s: %v", user.Username, err)
+		}
 	}
 
-	resp, err := dg.NewTxn().Mutate(ctx, mu)
-	if resp != nil {}
+	tokens, err := startSession(user.Username)
 	if err != nil {
-		log.Println(err)
-		return false
+		render.Render(w, r, ErrStore(err))
+		return
 	}
-	return true
-}
+	resp.Username = user.Username
+	resp.Role = roleOf(user)
+	resp.setTokens(tokens)
 
-func dbGetModuleByName(name string, username string) []Module {
-	dg, cancel := getDgraphClient()
-	defer cancel()
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, resp)
+}
 
-	vars := make(map[string]string)
-	vars["$enname"] = name
-	vars["$enusername"] = username
-	q := `query wanghamodules($enname: string, $enusername: string){
-		modules(func: type(Module)) @filter(eq(name, $enname) and eq(owner, $enusername) ){
-			uid
-			expand(_all_)
-		}
-	}`
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
 
-	ctx := context.Background()
+const minPasswordLength = 8
 
-	resp, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
-	if err != nil {
-		log.Println(err)
+// validateCredentials returns the field errors of a new username and
+// password.
+func validateCredentials(username string, password string) []custom_error {
+	var errors []custom_error
+	if username == "" {
+		errors = append(errors, custom_error {
+			Field: "username",
+			Message: "El nombre de usuario es obligatorio",
+		})
+	}else if !usernamePattern.MatchString(username) {
+		errors = append(errors, custom_error {
+			Field: "username",
+			Message: "El nombre de usuario debe tener entre 3 y 32 caracteres: letras, números, punto, guion o guion bajo",
+		})
 	}
+	errors = append(errors, validateNewPassword("password", password)...)
+	return errors
+}
 
-	type arrays struct{
-		Uids	[]Module `json:"modules"`
+func validateNewPassword(field string, password string) []custom_error {
+	if password == "" {
+		return []custom_error{{
+			Field: field,
+			Message: "La contraseña es obligatoria",
+		}}
 	}
-
-	var r arrays
-	err = json.Unmarshal([]byte(resp.Json), &r)
-	if err != nil{
-		log.Println(err)
+	if len([]rune(password)) < minPasswordLength {
+		return []custom_error{{
+			Field: field,
+			Message: fmt.Sprintf("La contraseña debe tener al menos %d caracteres", minPasswordLength),
+		}}
 	}
-
-	return r.Uids
+	return nil
 }
 
-func dbCreateModule(module *Module) (string, error) {
-	dg, cancel := getDgraphClient()
-	defer cancel()
-
-	new_module := Module{
-		Name: module.Name,
-		Owner: module.Owner,
-		DgraphType: "Module",
+// Register creates an account and logs it in.
+func Register(w http.ResponseWriter, r *http.Request) {
+	data := &UserRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
-	
-	mo := &api.Operation{}
-	mo.Schema = `
-		name: string @index(exact) .
-		owner: string @index(exact) . 
-		type: string .
-		type Module {
-			name:		string
-			owner: 	string
-		}
-	`
 
-	ctx := context.Background()
-	if err := dg.Alter(ctx, mo); err != nil {
-		log.Fatal(err)
+	resp := &LoginResponse{}
+	resp.Errors = validateCredentials(data.Username, data.Password)
+	if len(resp.Errors) > 0 {
+		render.Status(r, http.StatusUnprocessableEntity)
+		render.Render(w, r, resp)
+		return
 	}
 
-	mu := &api.Mutation{
-		CommitNow: true,
+	hash, err := hashPassword(data.Password)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	_, err = store.CreateUser(data.Username, hash)
+	if errors.Is(err, errConflict) {
+		resp.Errors = append(resp.Errors, custom_error {
+			Field: "username",
+			Message: "Este nombre de usuario ya está registrado",
+		})
+		render.Status(r, http.StatusConflict)
+		render.Render(w, r, resp)
+		return
 	}
-
-	mb, err := json.Marshal(new_module)
 	if err != nil {
-		log.Fatal(err)
+		render.Render(w, r, ErrStore(err))
+		return
 	}
 
-	mu.SetJson = mb
-	response, err := dg.NewTxn().Mutate(ctx, mu)
+	tokens, err := startSession(data.Username)
 	if err != nil {
-		log.Fatal(err)
+		render.Render(w, r, ErrStore(err))
+		return
 	}
+	resp.Username = data.Username
+	resp.Role = roleStudent
+	resp.setTokens(tokens)
 
-	var uid string
-	uid = ""
-	//Get created module uid
-	for _, value := range response.Uids {
-		uid = value
-	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, resp)
+}
 
-	return uid, nil
+type PasswordRequest struct {
+	CurrentPassword	string		`json:"current_password,omitempty"`
+	NewPassword		string		`json:"new_password,omitempty"`
 }
 
-func dbUserGetModules(username string) []*Module  {
-	dg, cancel := getDgraphClient()
-	defer cancel()
+func (a *PasswordRequest) Bind(r *http.Request) error {
+	return nil
+}
 
-	vars := make(map[string]string)
-	vars["$username"] = username
-	q := `query usermodules($username: string){
-		modules(func: type(Module)) @filter(eq(owner, $username)) {
-			uid
-			expand(_all_)
-		}
-	}`
+type AccountResponse struct {
+	Updated		bool			`json:"updated,omitempty"`
+	Deleted		bool			`json:"deleted,omitempty"`
+	Username	string			`json:"username,omitempty"`
+	Errors 		[]custom_error	`json:"errors,omitempty"`
+}
 
-	ctx := context.Background()
+func (rd *AccountResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	return nil
+}
 
-	resp, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
+// checkCurrentPassword loads the authenticated user and compares password
+// with the stored one. Accounts created by the OpenID provider have a random
+// password nobody knows, so for them the login session is enough.
+func checkCurrentPassword(r *http.Request, field string, password string) ([]custom_error, error) {
+	users, err := store.GetUsersByUsername(authUser(r).Username)
 	if err != nil {
-		log.Println(err)
-	}
-
-	type arrays struct{
-		Uids	[]*Module `json:"modules,omitempty"`
+		return nil, err
 	}
-
-	var modules arrays
-
-	err = json.Unmarshal([]byte(resp.Json), &modules)
-	if err != nil{
-		log.Println(err)
+	if len(users) == 0 {
+		return nil, fmt.Errorf("user %s: %w", authUser(r).Username, errNotFound)
 	}
-
-	return modules.Uids
-}
-
-func dbDeleteModule(uid string) int {
-
-	//Firts delete nodes
-	nodes := dbModuleGetNodes(uid)
-	for _, node := range nodes {
-		dbDeleteAnyByUidType(node.Uid, "Node")
+	if users[0].OIDCSubject != "" {
+		return nil, nil
 	}
-
-	dg, cancel := getDgraphClient()
-	defer cancel()
-	ctx := context.Background()
-
-	d := map[string]string{"uid":uid, "dgraph.type":"Module"}
-	ub, err := json.Marshal(d)
-
-	mu := &api.Mutation{
-		CommitNow: true,
-		DeleteJson: ub,
+	if password == "" {
+		return []custom_error{{
+			Field: field,
+			Message: "La contraseña es obligatoria",
+		}}, nil
 	}
-
-	assign, err := dg.NewTxn().Mutate(ctx, mu)
-	log.Println(assign.Metrics.NumUids)
-	if err != nil{
-		return 0
+	if ok, _ := checkPassword(users[0].Password, password); !ok {
+		return []custom_error{{
+			Field: field,
+			Message: "Contraseña incorrecta",
+		}}, nil
 	}
-	return 1
+	return nil, nil
 }
 
-func dbCreateNode(node *Node) (Node, error) {
-	dg, cancel := getDgraphClient()
-	defer cancel()
-
-	no := &api.Operation{}
-	no.Schema = `
-		module_uid: string @index(exact) .
-		name: string @index(exact) .
-		id: int .
-		class: string .
-		html: string .
-		typenode: bool .
-		pos_x: float .
-		pos_y: float .
-		value: string .
-		operator: string .
-		port: string .
-		input: string .
-		type: string .
-		Data: uid @reverse .
-		InputOutput: [uid] @reverse .
-		Connection: [uid] .
-		node_number: string .
-		port: string .
-		type Node {
-			module_uid: string
-			id: int
-			name: string
-			class: string
-			html: string
-			typenode: bool
-			pos_x: float
-			pos_y: float
-			Data: Data
-			InputOutput: [InputOutput]
-		}
-		type Data {
-			name:	string
-			value: string
-			operator: string
-		}
-		type InputOutput {
-			name: string
-			type: string
-			Connection: [Connection]
-		}
-		type Connection {
-			node_number: string
-			port: string
-		}
-	`
-
-	ctx := context.Background()
-	if err := dg.Alter(ctx, no); err != nil {
-		log.Fatal(err)
-	}
-
-	mu := &api.Mutation{
-		CommitNow: true,
+// ChangePassword replaces the password of the authenticated user after
+// checking the current one.
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	data := &PasswordRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
 
-	nb, err := json.Marshal(node)
+	resp := &AccountResponse{Username: authUser(r).Username}
+	errors, err := checkCurrentPassword(r, "current_password", data.CurrentPassword)
 	if err != nil {
-		log.Fatal(err)
+		render.Render(w, r, ErrStore(err))
+		return
 	}
-
-	mu.SetJson = nb
-	resp, err := dg.NewTxn().Mutate(ctx, mu)
-	if (err != nil && resp == nil)  {
-		log.Fatal(err)
+	resp.Errors = append(errors, validateNewPassword("new_password", data.NewPassword)...)
+	if len(resp.Errors) > 0 {
+		render.Status(r, http.StatusUnprocessableEntity)
+		render.Render(w, r, resp)
+		return
 	}
 
-	id := strconv.Itoa(node.Id)
-	vars := make(map[string]string)
-	vars["$moduleuid"] = node.ModuleUID
-	vars["$id"] = id
-	q := `query getcreatednode($moduleuid: string, $id: string){
-		nodes(func: type(Node)) @filter(eq(module_uid, $moduleuid) and eq(id, $id) ){
-			uid
-			expand(_all_)
-			data{
-				uid
-				expand(_all_)
-			}
-			inputs_outputs{
-				uid
-				expand(_all_)
-				connections{
-					uid
-			  		expand(_all_)
-				}
-		  	}
-		}
-	}`
-
-	resp1, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
+	hash, err := hashPassword(data.NewPassword)
 	if err != nil {
-		log.Println(err)
+		render.Render(w, r, ErrStore(err))
+		return
 	}
-
-	type arrays struct{
-		Uids	[]Node `json:"nodes"`
+	if err := store.UpdatePassword(resp.Username, hash); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
 	}
 
-	var r arrays
-	err = json.Unmarshal([]byte(resp1.Json), &r)
-	if err != nil{
-		log.Println(err)
-	}
-
-	if len(r.Uids) > 0 {
-		return r.Uids[0], nil
-	}
-
-	return Node{}, nil
+	resp.Updated = true
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, resp)
 }
 
-func dbModuleGetNodes(module_uid string) []*Node {
-	dg, cancel := getDgraphClient()
-	defer cancel()
-
-	vars := make(map[string]string)
-	vars["$module_uid"] = module_uid
-	q := `query modulenodes($module_uid: string){
-		nodes(func: type(Node)) @filter(eq(module_uid, $module_uid)) {
-			uid
-			expand(_all_)
-			data{
-				uid
-				expand(_all_)
-			}
-			inputs_outputs{
-				uid
-				expand(_all_)
-				connections{
-					uid
-			  		expand(_all_)
-				}
-		  	}
-		}
-	}`
+type DeleteAccountRequest struct {
+	Password	string		`json:"password,omitempty"`
+}
 
-	ctx := context.Background()
+func (a *DeleteAccountRequest) Bind(r *http.Request) error {
+	return nil
+}
 
-	resp, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
-	if err != nil {
-		log.Println(err)
+// DeleteAccount removes the authenticated user with all its modules and
+// their nodes. The password is asked again to confirm.
+func DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	data := &DeleteAccountRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
 
-	type arrays struct{
-		Uids	[]*Node `json:"nodes,omitempty"`
+	resp := &AccountResponse{Username: authUser(r).Username}
+	errors, err := checkCurrentPassword(r, "password", data.Password)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
 	}
-
-	var nodes arrays
-
-	err = json.Unmarshal([]byte(resp.Json), &nodes)
-	if err != nil{
-		log.Println(err)
+	if len(errors) > 0 {
+		resp.Errors = errors
+		render.Status(r, http.StatusUnprocessableEntity)
+		render.Render(w, r, resp)
+		return
 	}
-
-	log.Println(nodes)
-
-	return nodes.Uids
-}
-
-func dbDeleteNode(uid string) bool {
-	dg, cancel := getDgraphClient()
-	defer cancel()
-
-	vars := make(map[string]string)
-	vars["$uid"] = uid
-	q := `query getnodes($uid: string){
-				nodes(func: type(Node)) @filter(uid($uid)) {
-			uid
-			expand(_all_)
-			data{
-				uid
-				expand(_all_)
-			}
-			inputs_outputs{
-				uid
-				expand(_all_)
-				connections{
-					uid
-			  		expand(_all_)
-				}
-		  	}
-		}
-	}`
-
-	ctx := context.Background()
-
-	resp, err := dg.NewTxn().QueryWithVars(ctx,q,vars)
+	last, err := lastAdmin(resp.Username)
 	if err != nil {
-		log.Println(err)
+		render.Render(w, r, ErrStore(err))
+		return
 	}
-
-	type arrays struct{
-		Uids	[]*Node `json:"nodes,omitempty"`
+	if last {
+		render.Render(w, r, ErrStore(fmt.Errorf("%s is the last admin: %w", resp.Username, errForbidden)))
+		return
 	}
 
-	var nodes arrays
-	var deleted bool
-	deleted = false
-
-	err = json.Unmarshal([]byte(resp.Json), &nodes)
-	if err != nil{
-		log.Println(err)
+	if err := store.DeleteUser(resp.Username); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
 	}
 
-	//Get nodes
-	for _, node := range nodes.Uids {
-		//Delete node data
-		data_uid := node.Data.Uid
-		if data_uid != ""{
-			dbDeleteAnyByUidType(data_uid, "Data")
-		}
-		//Delete node inputs_outputs
-		inputs_outputs := node.InputsOutputs
-		for _, input_output := range inputs_outputs{
-			input_output_uid := input_output.Uid
-
-			//Delete input_output connections
-			connections := input_output.Connections
-			for _, connection := range connections{
-				connection_uid := connection.Uid
-				if connection_uid != "" {
-					dbDeleteAnyByUidType(connection_uid, "Connection")
-				}
-			}
-
-			//Delete this input_output
-			if input_output_uid != "" {
-				dbDeleteAnyByUidType(input_output_uid, "InputOutput")
-			}
-		}
-
-		//Finally delete this node 
-		node_uid := node.Uid 
-		if node_uid != "" {
-			deleted = dbDeleteAnyByUidType(node_uid, "Node")
-		}
-	}
-	return deleted
+	resp.Deleted = true
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, resp)
 }
-
-func dbNodeUpdatePosition(node_uid string, pos_x float32, pos_y float32) bool {
-
-	dg, cancel := getDgraphClient()
-	defer cancel()
-
-	ctx := context.Background()
-
-	mu := &api.Mutation{
-		CommitNow: true,
-	}
-
-	t1 := fmt.Sprintf("<%s> <pos_x> \"%g\" .",node_uid,pos_x)
-	t2 := fmt.Sprintf("<%s> <pos_y> \"%g\" .",node_uid,pos_y)
-	t := fmt.Sprintf(t1+"\n"+t2)
-	mu.SetNquads = []byte(t)
-
-	_,err := dg.NewTxn().Mutate(ctx,mu)
-	if err != nil{
-		log.Println(err)
-		return false
-	}
-
-	return true
+type RefreshRequest struct {
+	RefreshToken	string	`json:"refresh_token,omitempty"`
 }
 
-
-func dbUpdateData(data *Data) bool {
-
-	dg, cancel := getDgraphClient()
-	defer cancel()
-
-	ctx := context.Background()
-
-	mu := &api.Mutation{
-		CommitNow: true,
-	}
-
-	t1 := fmt.Sprintf("<%s> <name> \"%s\" .",data.Uid,data.Name)
-	t2 := fmt.Sprintf("<%s> <value> \"%s\" .",data.Uid,data.Value)
-	t3 := fmt.Sprintf("<%s> <operator> \"%s\" .",data.Uid,data.Operator)
-	t := fmt.Sprintf(t1+"\n"+t2+"\n"+t3)
-	mu.SetNquads = []byte(t)
-
-	_,err := dg.NewTxn().Mutate(ctx,mu)
-	if err != nil{
-		log.Println(err)
-		return false
+func (a *RefreshRequest) Bind(r *http.Request) error {
+	if a.RefreshToken == "" {
+		return errors.New("missing required refresh_token.")
 	}
-
-	return true
+	return nil
 }
 
-func dbcreateConnection(connection *Connection) string {
-	dg, cancel := getDgraphClient()
-	defer cancel()
-
-	co := &api.Operation{}
-	co.Schema = `
-		node_number: string .
-		port: string .
-		type Connection {
-			node_number: string
-			port: string
-		}
-	`
-
-	ctx := context.Background()
-	if err := dg.Alter(ctx, co); err != nil {
-		log.Fatal(err)
+// RefreshToken trades a refresh token for a new access token and refresh
+// token. Each refresh token works once.
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	data := &RefreshRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
 	}
 
-	mu := &api.Mutation{
-		CommitNow: true,
+	session, tokens, err := refreshSession(data.RefreshToken)
+	if errors.Is(err, errUnauthorized) {
+		render.Render(w, r, ErrUnauthorized(err))
+		return
 	}
-	cb, err := json.Marshal(connection)
 	if err != nil {
-		log.Fatal(err)
+		render.Render(w, r, ErrStore(err))
+		return
 	}
-
-	mu.SetJson = cb
-	response, err := dg.NewTxn().Mutate(ctx, mu)
+	users, err := store.GetUsersByUsername(session.Username)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	var uid string
-	uid = ""
-	//Get created program uid
-	for _, value := range response.Uids {
-		uid = value
-	}
-
-	return uid
-}
-
-func dbInputOutputAddConnection(input_output_uid string, connection_uid string){
-	dg, cancel := getDgraphClient()
-	defer cancel()
-
-	ctx := context.Background()
-
-	mu := &api.Mutation{
-		CommitNow: true,
-	}
-
-	t := fmt.Sprintf("<%s> <connections> <%s> .",input_output_uid,connection_uid)
-	mu.SetNquads = []byte(t)
-
-	assign,err := dg.NewTxn().Mutate(ctx,mu)
-	if assign == nil {}
-	if err != nil{
-		log.Println(err)
+		render.Render(w, r, ErrStore(err))
+		return
 	}
-}
-
-func dbDeleteConnection(parent_uid string, connection *Connection){
-	dg, cancel := getDgraphClient()
-	defer cancel()
-	ctx := context.Background()
-
-	//Remove relation between OutputConnection and Connection
-	type UidStruct struct {
-		Uid string	`json:"uid"`
+	if len(users) == 0 {
+		render.Render(w, r, ErrUnauthorized(fmt.Errorf("%w: user %s no longer exists", errUnauthorized, session.Username)))
+		return
 	}
-	connection_uids := [1]UidStruct {{connection.Uid}} // [{uid:"0x4"}]
-	d := map[string]interface{}{
-        "uid": parent_uid,
-        "connections": &connection_uids,
-    }
-
-    pb, err := json.Marshal(d)
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    mu := &api.Mutation{
-        CommitNow:  true,
-        DeleteJson: pb,
-    }	
-
-	resp, err := dg.NewTxn().Mutate(ctx, mu)
-	if resp == nil {}
 
-	dbDeleteAnyByUidType(connection.Uid, "Connection");
-}
-/******************************************************************************
-********************************* End database ********************************
-******************************************************************************/
-
-
-
-/******************************************************************************
-********************************* Start Api Rest ******************************
-******************************************************************************/
-
-/******************************* Start Users ********************************/
-type UserRequest struct {
-	*User
-}
-
-type custom_error struct {
-	Field 	string `json:"field,omitempty"`
-	Message string `json:"message,omitempty"`
+	resp := &LoginResponse{Username: session.Username, Role: roleOf(&users[0])}
+	resp.setTokens(tokens)
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, resp)
 }
 
-type LoginResponse struct {
-	Token 		string 			`json:"token,omitempty"`
-	Username	string			`json:"username,omitempty"` 
-	Errors 		[]custom_error	`json:"errors,omitempty"`
+type SessionResponse struct {
+	Username	string	`json:"username,omitempty"`
+	Revoked		int		`json:"revoked"`
 }
 
-func (rd *LoginResponse) Render(w http.ResponseWriter, r *http.Request) error {
+func (rd *SessionResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	// Pre-processing before a response is marshalled and sent across the wire
 	return nil
 }
 
-func (a *UserRequest) Bind(r *http.Request) error {
-	if a.User == nil {
-		return errors.New("missing required User fields.")
-	}
-	return nil
-}
-
-// CreateArticle persists the posted Article and returns it
-// back to the client as an acknowledgement.
-func SignIn(w http.ResponseWriter, r *http.Request) {
-	data := &UserRequest{}
-	var errors []custom_error
+// Logout ends the session of the given refresh token. The access token
+// stops working too.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	data := &RefreshRequest{}
 	if err := render.Bind(r, data); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
-	resp := &LoginResponse{}
-
-		if(data.Username == ""){
-			errors = append(errors, custom_error {
-				Field: "username", 
-				Message: "El nombre de usuario es obligatorio",
-			})
-		}
-		if(data.Password == ""){
-			errors = append(errors, custom_error {
-				Field: "password", 
-				Message: "La contraseña es obligatoria",
-			})
-		}
-
-		//Get user from database
-		users := getUsersByUsername(data.Username)
-		if len(users) > 0 {
-			user := users[0]
-			if user.Password == data.Password{
-				resp.Username = user.Username
-				resp.Token = "12345"
-			}else{
-				errors = append(errors, custom_error {
-					Field: "password", 
-					Message: "Contraseña incorrecta",
-				})
-			}
-		}else if data.Username != "" {
-			errors = append(errors, custom_error {
-				Field: "username", 
-				Message: "Este nombre de usuario no se encuentra registrado",
-			})
-		}
-
-	resp.Errors = errors
+	session, err := endSession(data.RefreshToken)
+	if errors.Is(err, errUnauthorized) {
+		render.Render(w, r, ErrUnauthorized(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
 
-	render.Status(r, http.StatusCreated)
+	resp := &SessionResponse{Username: session.Username, Revoked: 1}
+	render.Status(r, http.StatusAccepted)
 	render.Render(w, r, resp)
 }
 /********************************* End Users *********************************/
@@ -1008,7 +923,6 @@ func SignIn(w http.ResponseWriter, r *http.Request) {
 /***************************** Start Modules *********************************/
 type ModuleRequest struct {
 	Module	*Module		`json:"module,omitempty"`
-	Token	string		`json:"token,omitempty"`
 }
 func (a *ModuleRequest) Bind(r *http.Request) error {
 
@@ -1041,7 +955,11 @@ func SearchModuleByName(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
-	modules := dbGetModuleByName(data.Module.Name, data.Module.Owner)
+	modules, err := store.GetModuleByName(data.Module.Name, authUser(r).Username)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
 
 	var isset bool
 	isset = true
@@ -1072,7 +990,12 @@ func CreateModule(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
-	uid, _ := dbCreateModule(data.Module)
+	data.Module.Owner = authUser(r).Username
+	uid, err := store.CreateModule(data.Module)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
 
 	resp := &CreateModuleResponse{Uid: uid}
 
@@ -1080,33 +1003,20 @@ func CreateModule(w http.ResponseWriter, r *http.Request){
 	render.Render(w, r, resp)
 }
 
-type ListModuleRequest struct {
-	Username	string		`json:"username,omitempty"`
-	Token		string		`json:"token,omitempty"`
-}
-
-func (a *ListModuleRequest) Bind(r *http.Request) error {
-
-	if a.Username == "" {
-		return errors.New("missing required Username field.")
-	}
-
-	if a.Token == "" {
-		return errors.New("missing required Token field.")
-	}
-
-	return nil
-}
-
+// ListModules lists the modules of the authenticated user, or with
+// ?group=<uid> the modules of that group's members the user can see.
 func ListModules(w http.ResponseWriter, r *http.Request) {
-	data := &ListModuleRequest{}
-
-	if err := render.Bind(r, data); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+	var modules []*Module
+	var err error
+	if group_uid := r.URL.Query().Get("group"); group_uid != "" {
+		modules, err = groupModules(r, group_uid)
+	} else {
+		modules, err = store.UserGetModules(authUser(r).Username)
+	}
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
 		return
 	}
-
-	modules := dbUserGetModules(data.Username)
 	resp := &ModuleListResponse{Success: true}
 
 	if err := render.RenderList(w, r, NewModuleListResponse(modules)); err != nil {
@@ -1142,7 +1052,11 @@ type ModuleResponse struct {
 func (rd *ModuleResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	// Pre-processing before a response is marshalled and sent across the wire
 	rd.Module.Owner = ""
-	rd.Nodes = dbModuleGetNodes(rd.Module.Uid)
+	nodes, err := store.ModuleGetNodes(rd.Module.Uid)
+	if err != nil {
+		return err
+	}
+	rd.Nodes = nodes
 	return nil
 }
 
@@ -1157,10 +1071,8 @@ func DeleteModule(w http.ResponseWriter, r *http.Request) {
 	
 	module_uid := chi.URLParam(r, "moduleUID")
 
-	deleted := dbDeleteModule(module_uid)
-
-	if(deleted <= 0){
-		render.Render(w, r, ErrNotFound)
+	if err := store.DeleteModule(module_uid); err != nil {
+		render.Render(w, r, ErrStore(err))
 		return
 	}else{
 
@@ -1185,104 +1097,316 @@ func (rd *DeleteModuleResponse) Render(w http.ResponseWriter, r *http.Request) e
 // ClearModule delete all nodes from an existing Module from our persistent store.
 func ClearModule(w http.ResponseWriter, r *http.Request) {
 	module_uid := chi.URLParam(r, "moduleUID")
-	nodes := dbModuleGetNodes(module_uid)
-	for _, node := range nodes {
-		if node.Uid != "" {
-			dbDeleteAnyByUidType(node.Uid, "Node")
-		}
+	if err := store.ClearModule(module_uid); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
 	}
 	render.Status(r, http.StatusAccepted)
 }
-/****************************** End Modules **********************************/
 
-/***************************** Start Nodes ***********************************/
-type NodeRequest struct {
-	Node			*Node			`json:"node,omitempty"`
-	Token			string			`json:"token,omitempty"`
+type SaveGraphRequest struct {
+	*DrawflowExport
 }
 
-func (a *NodeRequest) Bind(r *http.Request) error {
-	if a.Node == nil {
-		return errors.New("missing required Node fields.")
+func (a *SaveGraphRequest) Bind(r *http.Request) error {
+	if a.DrawflowExport == nil || a.Drawflow == nil {
+		return errors.New("missing required drawflow export.")
 	}
 	return nil
 }
 
-func CreateNode(w http.ResponseWriter, r *http.Request){
-	data := &NodeRequest{}
+type SaveGraphResponse struct {
+	Saved		bool	`json:"saved,omitempty"`
+	*GraphDiff
+}
+
+func (rd *SaveGraphResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	return nil
+}
+
+// SaveModuleGraph replaces the nodes, ports and connections of a Module with
+// the ones in a drawflow export, in a single transaction.
+func SaveModuleGraph(w http.ResponseWriter, r *http.Request) {
+	module_uid := chi.URLParam(r, "moduleUID")
 
+	data := &SaveGraphRequest{}
 	if err := render.Bind(r, data); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
-	node, _ := dbCreateNode(data.Node)
-	resp := &CreateNodeResponse{Created: true, Node:node}
+	nodes, err := data.ModuleNodes(module_uid)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
 
-	render.Status(r, http.StatusCreated)
+	diff, err := store.SaveModuleGraph(module_uid, nodes)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	resp := &SaveGraphResponse{Saved: true, GraphDiff: diff}
+	render.Status(r, http.StatusAccepted)
 	render.Render(w, r, resp)
 }
 
-type CreateNodeResponse struct {
-	Created		bool	`json:"created,omitempty"`
-	Node 		Node 	`json:"node,omitempty"`
+// GetModule returns a Module with its nodes to the users that can view it.
+func GetModule(w http.ResponseWriter, r *http.Request) {
+	module_uid := chi.URLParam(r, "moduleUID")
+
+	module, err := store.ModuleOf(module_uid)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if module.Uid != module_uid {
+		render.Render(w, r, ErrStore(fmt.Errorf("module %s: %w", module_uid, errNotFound)))
+		return
+	}
+
+	if err := render.Render(w, r, NewModuleResponse(module)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
 }
 
-func (rd *CreateNodeResponse) Render(w http.ResponseWriter, r *http.Request) error {
+type CodeResponse struct {
+	ModuleUID	string	`json:"module_uid"`
+	Lang		string	`json:"lang"`
+	Code		string	`json:"code"`
+}
+
+func (rd *CodeResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	// Pre-processing before a response is marshalled and sent across the wire
 	return nil
 }
 
-// DeleteNode removes an existing Node from our persistent store.
-func DeleteNode(w http.ResponseWriter, r *http.Request) {
-	
-	node_uid := chi.URLParam(r, "nodeUID")
-
-	deleted := dbDeleteNode(node_uid)
-
-	if(!deleted){
-		render.Render(w, r, ErrNotFound)
+// ModuleCode exports the graph of a module as source code in the language
+// given by ?lang=, Python by default.
+func ModuleCode(w http.ResponseWriter, r *http.Request) {
+	module_uid := chi.URLParam(r, "moduleUID")
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		lang = "python"
+	}
+	if !program.Supported(lang) {
+		render.Render(w, r, ErrStore(fmt.Errorf("language %q, use %s: %w", lang, strings.Join(program.Languages(), ", "), errValidation)))
 		return
-	}else{
-
-		resp := &DeleteNodeResponse{Deleted: deleted, Uid:node_uid}
-
-		render.Status(r, http.StatusAccepted)
-		render.Render(w, r, resp)
+	}
 
+	nodes, err := moduleProgram(module_uid)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	code, err := program.Generate(lang, nodes)
+	if err != nil {
+		render.Render(w, r, ErrStore(programError(err)))
+		return
 	}
-}
-type DeleteNodeResponse struct {
-	Deleted		bool	`json:"deleted,omitempty"`
-	Uid 		string 	`json:"uid,omitempty"`
-}
 
-func (rd *DeleteNodeResponse) Render(w http.ResponseWriter, r *http.Request) error {
-	// Pre-processing before a response is marshalled and sent across the wire
-	return nil
+	render.Render(w, r, &CodeResponse{ModuleUID: module_uid, Lang: lang, Code: code})
 }
 
-type PositionNodeRequest struct {
-	PosX	float32		`json:"pos_x,omitempty"`
-	PosY	float32		`json:"pos_y,omitempty"`
+type RunResponse struct {
+	ModuleUID	string	`json:"module_uid"`
+	*program.Result
 }
 
-func (a *PositionNodeRequest) Bind(r *http.Request) error {
+func (rd *RunResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
 	return nil
 }
 
-func PositionNode(w http.ResponseWriter, r *http.Request){
-	node_uid := chi.URLParam(r, "nodeUID")
-
+// RunModule runs the graph of a module with the interpreter of the program
+// package and returns what it printed and the variables. An error of the
+// program, like a division by zero or a loop stopped by the run limits, is
+// part of the result, not a failed request.
+func RunModule(w http.ResponseWriter, r *http.Request) {
+	module_uid := chi.URLParam(r, "moduleUID")
+	nodes, err := moduleProgram(module_uid)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	machine, err := program.Compile(nodes, runLimits())
+	if err != nil {
+		render.Render(w, r, ErrStore(programError(err)))
+		return
+	}
+
+	render.Render(w, r, &RunResponse{ModuleUID: module_uid, Result: machine.Run(r.Context())})
+}
+
+type CommentRequest struct {
+	Body	string	`json:"body,omitempty"`
+}
+
+func (a *CommentRequest) Bind(r *http.Request) error {
+	a.Body = strings.TrimSpace(a.Body)
+	if a.Body == "" {
+		return errors.New("missing required comment body.")
+	}
+	return nil
+}
+
+type CommentResponse struct {
+	*Comment
+}
+
+func (rd *CommentResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	return nil
+}
+
+// ListComments lists the comments of a Module, oldest first.
+func ListComments(w http.ResponseWriter, r *http.Request) {
+	comments, err := store.ModuleComments(chi.URLParam(r, "moduleUID"))
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, comment := range comments {
+		list = append(list, &CommentResponse{Comment: comment})
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// CreateComment leaves a comment on a Module, signed by the authenticated
+// user.
+func CreateComment(w http.ResponseWriter, r *http.Request) {
+	data := &CommentRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	comment := &Comment{
+		ModuleUID:	chi.URLParam(r, "moduleUID"),
+		Author:		authUser(r).Username,
+		Body:		data.Body,
+		CreatedAt:	time.Now().UTC(),
+	}
+	uid, err := store.CreateComment(comment)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	comment.Uid = uid
+
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &CommentResponse{Comment: comment})
+}
+/****************************** End Modules **********************************/
+
+/***************************** Start Nodes ***********************************/
+type NodeRequest struct {
+	Node			*Node			`json:"node,omitempty"`
+}
+
+func (a *NodeRequest) Bind(r *http.Request) error {
+	if a.Node == nil {
+		return errors.New("missing required Node fields.")
+	}
+	if nodeHasUid(a.Node) {
+		return errors.New("a new node can't carry uids, they are assigned on creation.")
+	}
+	return nil
+}
+
+func CreateNode(w http.ResponseWriter, r *http.Request){
+	data := &NodeRequest{}
+
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	if err := authorize(r, data.Node.ModuleUID); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	node, err := store.CreateNode(data.Node)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	resp := &CreateNodeResponse{Created: true, Node:node}
+
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, resp)
+}
+
+type CreateNodeResponse struct {
+	Created		bool	`json:"created,omitempty"`
+	Node 		Node 	`json:"node,omitempty"`
+}
+
+func (rd *CreateNodeResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	return nil
+}
+
+// DeleteNode removes an existing Node from our persistent store.
+func DeleteNode(w http.ResponseWriter, r *http.Request) {
+	
+	node_uid := chi.URLParam(r, "nodeUID")
+
+	if err := store.DeleteNode(node_uid); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}else{
+
+		resp := &DeleteNodeResponse{Deleted: true, Uid:node_uid}
+
+		render.Status(r, http.StatusAccepted)
+		render.Render(w, r, resp)
+
+	}
+}
+type DeleteNodeResponse struct {
+	Deleted		bool	`json:"deleted,omitempty"`
+	Uid 		string 	`json:"uid,omitempty"`
+}
+
+func (rd *DeleteNodeResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	return nil
+}
+
+type PositionNodeRequest struct {
+	PosX	float32		`json:"pos_x,omitempty"`
+	PosY	float32		`json:"pos_y,omitempty"`
+}
+
+func (a *PositionNodeRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+func PositionNode(w http.ResponseWriter, r *http.Request){
+	node_uid := chi.URLParam(r, "nodeUID")
+
 	data := &PositionNodeRequest{}
 	if err := render.Bind(r, data); err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 	
-	updated := dbNodeUpdatePosition(node_uid, data.PosX, data.PosY)
+	if err := store.NodeUpdatePosition(node_uid, data.PosX, data.PosY); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
 
-	resp := &PositionNodeResponse{Updated: updated, Uid:node_uid}
+	resp := &PositionNodeResponse{Updated: true, Uid:node_uid}
 	render.Status(r, http.StatusAccepted)
 	render.Render(w, r, resp)
 }
@@ -1316,9 +1440,17 @@ func DataNode(w http.ResponseWriter, r *http.Request){
 		return
 	}
 	
-	updated := dbUpdateData(data.NodeData)
+	if err := authorize(r, data.NodeData.Uid); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	if err := store.UpdateData(data.NodeData); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
 
-	resp := &DataNodeResponse{Updated: updated}
+	resp := &DataNodeResponse{Updated: true}
 	render.Status(r, http.StatusAccepted)
 	render.Render(w, r, resp)
 }
@@ -1336,13 +1468,15 @@ type CreateConnectionRequest struct {
 	InputConnection			*Connection		`json:"input_connection,omitempty"`
 	OutputInputOutputUID	string 			`json:"output_input_output_uid,omitempty"`
 	InputInputOutputUID		string 			`json:"input_input_output_uid,omitempty"`
-	Token					string			`json:"token,omitempty"`
 }
 
 func (a *CreateConnectionRequest) Bind(r *http.Request) error {
 	if (a.OutputConnection == nil || a.InputConnection == nil) {
 		return errors.New("missing required Connection fields.")
 	}
+	if a.OutputConnection.Uid != "" || a.InputConnection.Uid != "" {
+		return errors.New("a new connection can't carry a uid, it is assigned on creation.")
+	}
 	return nil
 }
 
@@ -1354,11 +1488,21 @@ func CreateConnection(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
-	output_connection_uid := dbcreateConnection(data.OutputConnection)
-	input_connection_uid := dbcreateConnection(data.InputConnection)
+	if err := authorize(r, data.OutputInputOutputUID, data.InputInputOutputUID); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
 
-	dbInputOutputAddConnection(data.OutputInputOutputUID, output_connection_uid)
-	dbInputOutputAddConnection(data.InputInputOutputUID, input_connection_uid)
+	output_connection_uid, err := store.AddConnection(data.OutputInputOutputUID, data.OutputConnection)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	input_connection_uid, err := store.AddConnection(data.InputInputOutputUID, data.InputConnection)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
 
 	resp := &CreateConnectionResponse{ConnectionOutputUID: output_connection_uid, ConnectionInputUID:input_connection_uid}
 	render.Status(r, http.StatusCreated)
@@ -1381,7 +1525,6 @@ type DeleteConnectionsRequest struct {
 	InputConnection				*Connection		`json:"input_connection,omitempty"`
 	OutputConnectionParentId	string			`json:"output_connection_parent_uid,omitempty"`
 	InputConnectionParentId		string			`json:"input_connection_parent_uid,omitempty"`
-	Token						string			`json:"token,omitempty"`
 }
 
 func (a *DeleteConnectionsRequest) Bind(r *http.Request) error {
@@ -1398,8 +1541,19 @@ func DeleteConnections(w http.ResponseWriter, r *http.Request){
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
-	dbDeleteConnection(data.OutputConnectionParentId, data.OutputConnection);
-	dbDeleteConnection(data.InputConnectionParentId, data.InputConnection);
+	if err := authorize(r, data.OutputConnectionParentId, data.InputConnectionParentId); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	if err := store.DeleteConnection(data.OutputConnectionParentId, data.OutputConnection); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if err := store.DeleteConnection(data.InputConnectionParentId, data.InputConnection); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
 	
 	render.Status(r, http.StatusAccepted)
 }
@@ -1408,4 +1562,567 @@ func DeleteConnections(w http.ResponseWriter, r *http.Request){
 
 /******************************************************************************
 ********************************* End Api Rest ********************************
-******************************************************************************/
\ No newline at end of file
+******************************************************************************/
+
+/***************************** Start Admin ***********************************/
+type GCResponse struct {
+	*GCReport
+	Total	int		`json:"total"`
+}
+
+func (rd *GCResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	return nil
+}
+
+// CollectGarbage removes orphan and dangling objects from the store, or only
+// reports them with ?dry_run=true.
+func CollectGarbage(w http.ResponseWriter, r *http.Request) {
+	dry_run := r.URL.Query().Get("dry_run") == "true"
+
+	report, err := store.CollectGarbage(dry_run)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	resp := &GCResponse{GCReport: report, Total: report.Total()}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, resp)
+}
+
+type UserResponse struct {
+	*User
+}
+
+func (rd *UserResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	rd.User.Password = ""
+	rd.User.Role = roleOf(rd.User)
+	return nil
+}
+
+// ListUsers lists every account with its role.
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := store.ListUsers()
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for i := range users {
+		list = append(list, &UserResponse{User: &users[i]})
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+type RoleRequest struct {
+	Role	string	`json:"role,omitempty"`
+}
+
+func (a *RoleRequest) Bind(r *http.Request) error {
+	if !validRole(a.Role) {
+		return fmt.Errorf("unknown role %q, use student, teacher or admin.", a.Role)
+	}
+	return nil
+}
+
+// UpdateRole changes the role of an account. Admins can't change their own
+// role, so there is always one left.
+func UpdateRole(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	data := &RoleRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if username == authUser(r).Username {
+		render.Render(w, r, ErrStore(fmt.Errorf("changing your own role: %w", errForbidden)))
+		return
+	}
+
+	if err := store.UpdateRole(username, data.Role); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	resp := &AccountResponse{Updated: true, Username: username}
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, resp)
+}
+
+// RevokeSessions ends every session of an account, e.g. after a stolen
+// password. Its access tokens stop working at once.
+func RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	revoked, err := store.DeleteUserSessions(username)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	auditf("sessions_revoked user=%q by=%q count=%d", username, authUser(r).Username, revoked)
+
+	resp := &SessionResponse{Username: username, Revoked: revoked}
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, resp)
+}
+
+// lastAdmin tells whether username is the only admin left, whom deleting
+// would leave nobody to manage the accounts.
+func lastAdmin(username string) (bool, error) {
+	users, err := store.ListUsers()
+	if err != nil {
+		return false, err
+	}
+	admins, is_admin := 0, false
+	for i := range users {
+		if roleOf(&users[i]) == roleAdmin {
+			admins++
+			is_admin = is_admin || users[i].Username == username
+		}
+	}
+	return is_admin && admins == 1, nil
+}
+
+// AdminDeleteUser removes an account with all its modules and their nodes.
+// Like with the roles, an admin can't delete their own account here, nor
+// the last admin.
+func AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	if username == authUser(r).Username {
+		render.Render(w, r, ErrStore(fmt.Errorf("deleting your own account: %w", errForbidden)))
+		return
+	}
+	last, err := lastAdmin(username)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if last {
+		render.Render(w, r, ErrStore(fmt.Errorf("%s is the last admin: %w", username, errForbidden)))
+		return
+	}
+
+	if err := store.DeleteUser(username); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	resp := &AccountResponse{Deleted: true, Username: username}
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, resp)
+}
+
+// AdminCreateGroup creates a class for any teacher. The teacher must have
+// the teacher or admin role and every member must be registered.
+func AdminCreateGroup(w http.ResponseWriter, r *http.Request) {
+	data := &GroupRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if data.Group.Teacher == "" {
+		render.Render(w, r, ErrInvalidRequest(errors.New("missing required Group teacher.")))
+		return
+	}
+
+	teachers, err := store.GetUsersByUsername(data.Group.Teacher)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if len(teachers) == 0 {
+		render.Render(w, r, ErrStore(fmt.Errorf("teacher %s: %w", data.Group.Teacher, errNotFound)))
+		return
+	}
+	if role := roleOf(&teachers[0]); role != roleTeacher && role != roleAdmin {
+		render.Render(w, r, ErrStore(fmt.Errorf("teacher %s has role %s: %w", data.Group.Teacher, role, errValidation)))
+		return
+	}
+	if err := checkMembers(data.Group.Members); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	group, err := store.CreateGroup(data.Group)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &GroupResponse{Group: group})
+}
+
+// ListGroups lists every group with its teacher and members.
+func ListGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := store.GetGroups()
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, group := range groups {
+		list = append(list, &GroupResponse{Group: group})
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+/****************************** End Admin ************************************/
+
+/***************************** Start Teacher *********************************/
+type StudentModules struct {
+	Username	string		`json:"username"`
+	Modules		[]*Module	`json:"modules"`
+}
+
+type TeacherGroupResponse struct {
+	*Group
+	Students	[]*StudentModules	`json:"students"`
+}
+
+func (rd *TeacherGroupResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	return nil
+}
+
+// TeacherModules lists the groups taught by the authenticated user, each
+// with the modules of its students. Admins see every group.
+func TeacherModules(w http.ResponseWriter, r *http.Request) {
+	user := authUser(r)
+
+	groups, err := store.GetGroups()
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, group := range groups {
+		if group.Teacher != user.Username && roleOf(user) != roleAdmin {
+			continue
+		}
+		resp, err := newTeacherGroupResponse(group)
+		if err != nil {
+			render.Render(w, r, ErrStore(err))
+			return
+		}
+		list = append(list, resp)
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+/****************************** End Teacher **********************************/
+
+/***************************** Start Groups **********************************/
+type GroupRequest struct {
+	Group	*Group	`json:"group,omitempty"`
+}
+
+func (a *GroupRequest) Bind(r *http.Request) error {
+	if a.Group == nil {
+		return errors.New("missing required Group fields.")
+	}
+	if a.Group.Name == "" {
+		return errors.New("missing required Group name.")
+	}
+	return nil
+}
+
+type GroupResponse struct {
+	*Group
+}
+
+func (rd *GroupResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	if !canManageGroup(authUser(r), rd.Group) {
+		rd.Group.Code = ""
+		rd.Group.Members = nil
+	}
+	return nil
+}
+
+// checkMembers checks that every username in members is registered.
+func checkMembers(members []string) error {
+	for _, member := range members {
+		users, err := store.GetUsersByUsername(member)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return fmt.Errorf("member %s: %w", member, errNotFound)
+		}
+	}
+	return nil
+}
+
+// CreateGroup creates a class taught by the authenticated user and returns
+// it with the code students join with. A teacher's group starts empty:
+// listing members would let the teacher see their modules without them
+// joining, so only admins may.
+func CreateGroup(w http.ResponseWriter, r *http.Request) {
+	data := &GroupRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	user := authUser(r)
+	if len(data.Group.Members) > 0 && roleOf(user) != roleAdmin {
+		render.Render(w, r, ErrStore(fmt.Errorf("students join %s with its code, only admins add members: %w", data.Group.Name, errForbidden)))
+		return
+	}
+	if err := checkMembers(data.Group.Members); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	data.Group.Teacher = user.Username
+	group, err := store.CreateGroup(data.Group)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &GroupResponse{Group: group})
+}
+
+// ListMyGroups lists the groups the authenticated user teaches or belongs
+// to.
+func ListMyGroups(w http.ResponseWriter, r *http.Request) {
+	user := authUser(r)
+
+	groups, err := store.GetGroups()
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, group := range groups {
+		if group.Teacher == user.Username || hasString(group.Members, user.Username) {
+			list = append(list, &GroupResponse{Group: group})
+		}
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+type JoinGroupRequest struct {
+	Code	string	`json:"code,omitempty"`
+}
+
+func (a *JoinGroupRequest) Bind(r *http.Request) error {
+	a.Code = strings.ToUpper(strings.TrimSpace(a.Code))
+	if a.Code == "" {
+		return errors.New("missing required join code.")
+	}
+	return nil
+}
+
+// JoinGroup adds the authenticated user to the group with the given code.
+// Joining a group twice changes nothing.
+func JoinGroup(w http.ResponseWriter, r *http.Request) {
+	data := &JoinGroupRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	user := authUser(r)
+	group, err := store.GroupByCode(data.Code)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if group.Teacher == user.Username {
+		render.Render(w, r, ErrStore(fmt.Errorf("%s teaches group %s: %w", user.Username, group.Uid, errValidation)))
+		return
+	}
+	if err := store.AddGroupMember(group.Uid, user.Username); err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, &GroupResponse{Group: group})
+}
+
+// newTeacherGroupResponse loads the modules of every member of group.
+func newTeacherGroupResponse(group *Group) (*TeacherGroupResponse, error) {
+	resp := &TeacherGroupResponse{Group: group, Students: []*StudentModules{}}
+	for _, member := range group.Members {
+		modules, err := store.UserGetModules(member)
+		if err != nil {
+			return nil, err
+		}
+		if modules == nil {
+			modules = []*Module{}
+		}
+		resp.Students = append(resp.Students, &StudentModules{Username: member, Modules: modules})
+	}
+	return resp, nil
+}
+
+// GroupMembers returns a group with its members and their modules, to its
+// teacher and admins.
+func GroupMembers(w http.ResponseWriter, r *http.Request) {
+	group, err := store.GetGroup(chi.URLParam(r, "groupUID"))
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if !canManageGroup(authUser(r), group) {
+		render.Render(w, r, ErrStore(fmt.Errorf("group %s is taught by another user: %w", group.Uid, errForbidden)))
+		return
+	}
+
+	resp, err := newTeacherGroupResponse(group)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	render.Render(w, r, resp)
+}
+
+// groupModules returns the modules of the members of a group that the
+// authenticated user can see: all of them for the teacher and admins, the
+// user's own for a member.
+func groupModules(r *http.Request, group_uid string) ([]*Module, error) {
+	user := authUser(r)
+	group, err := store.GetGroup(group_uid)
+	if err != nil {
+		return nil, err
+	}
+	if !canManageGroup(user, group) {
+		if !hasString(group.Members, user.Username) {
+			return nil, fmt.Errorf("not a member of group %s: %w", group_uid, errForbidden)
+		}
+		return store.UserGetModules(user.Username)
+	}
+
+	var modules []*Module
+	for _, member := range group.Members {
+		member_modules, err := store.UserGetModules(member)
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, member_modules...)
+	}
+	return modules, nil
+}
+/****************************** End Groups ***********************************/
+
+/**************************** Start API keys *********************************/
+type APIKeyRequest struct {
+	Name		string	`json:"name,omitempty"`
+	ReadOnly	bool	`json:"read_only,omitempty"`
+}
+
+func (a *APIKeyRequest) Bind(r *http.Request) error {
+	a.Name = strings.TrimSpace(a.Name)
+	if a.Name == "" {
+		return errors.New("missing required name.")
+	}
+	return nil
+}
+
+type APIKeyResponse struct {
+	*APIKey
+	Key		string	`json:"key,omitempty"` // Only when it is created
+}
+
+func (rd *APIKeyResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	// Pre-processing before a response is marshalled and sent across the wire
+	rd.APIKey.KeyHash = ""
+	rd.APIKey.DgraphType = ""
+	return nil
+}
+
+// CreateAPIKey gives the authenticated user a new API key. The key is
+// returned once; the store only keeps its hash.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	data := &APIKeyRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	user := authUser(r)
+
+	keys, err := store.UserAPIKeys(user.Username)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	if len(keys) >= maxAPIKeys {
+		render.Render(w, r, ErrStore(fmt.Errorf("%s already has %d api keys: %w", user.Username, len(keys), errConflict)))
+		return
+	}
+
+	key, secret, err := newAPIKey(user.Username, data.Name, data.ReadOnly)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	auditf("api_key_created user=%q key=%s read_only=%t", user.Username, key.Uid, key.ReadOnly)
+
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &APIKeyResponse{APIKey: key, Key: secret})
+}
+
+// ListAPIKeys lists the API keys of the authenticated user, without their
+// secrets.
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := store.UserAPIKeys(authUser(r).Username)
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, key := range keys {
+		list = append(list, &APIKeyResponse{APIKey: key})
+	}
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// RevokeAPIKey deletes an API key of the authenticated user; it stops
+// working right away.
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := authUser(r)
+	key_uid := chi.URLParam(r, "keyUID")
+
+	key, err := store.GetAPIKey(key_uid)
+	if err == nil && key.Username != user.Username {
+		err = fmt.Errorf("api key %s: %w", key_uid, errNotFound)
+	}
+	if err == nil {
+		err = store.DeleteAPIKey(key_uid)
+	}
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	auditf("api_key_revoked user=%q key=%s", user.Username, key_uid)
+
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, &APIKeyResponse{APIKey: key})
+}
+/***************************** End API keys **********************************/
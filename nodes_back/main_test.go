@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useMemoryStore points the handlers at a new in-memory store and a default
+// config.
+func useMemoryStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	s := NewMemoryStore()
+	store = s
+	config = defaultConfig()
+	config.AuthSecret = strings.Repeat("s", minAuthSecret)
+	t.Cleanup(func() { store, config = nil, nil })
+	return s
+}
+
+// withUser is the context Authenticator leaves for user.
+func withUser(user *User) context.Context {
+	ctx := context.Background()
+	if user != nil {
+		ctx = context.WithValue(ctx, userCtxKey, user)
+	}
+	return ctx
+}
+
+// serveAs calls handler with a JSON body as user, nil for an anonymous
+// request, and returns the response.
+func serveAs(handler http.HandlerFunc, method string, body string, user *User) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, "/", reader).WithContext(withUser(user))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
@@ -0,0 +1,822 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in process memory. It backs the demo mode
+// (-store=memory), which starts empty on every restart, and behaves like
+// the other stores: "0x.." uids, cascading node and module deletes and
+// connections that live inside their port.
+type MemoryStore struct {
+	mu       sync.Mutex
+	lastUid  uint64
+	users    map[string]*User    // username -> user
+	modules  map[string]*Module  // uid -> module
+	nodes    map[string]*Node    // uid -> node
+	parents  map[string]string   // data/port/connection uid -> node uid
+	groups   map[string]*Group   // uid -> group
+	comments map[string]*Comment // uid -> comment
+	sessions map[string]*Session // uid -> session
+	apiKeys  map[string]*APIKey  // uid -> key
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:    make(map[string]*User),
+		modules:  make(map[string]*Module),
+		nodes:    make(map[string]*Node),
+		parents:  make(map[string]string),
+		groups:   make(map[string]*Group),
+		comments: make(map[string]*Comment),
+		sessions: make(map[string]*Session),
+		apiKeys:  make(map[string]*APIKey),
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) newUid() string {
+	s.lastUid++
+	return fmt.Sprintf("0x%x", s.lastUid)
+}
+
+// cloneNode deep copies a node so callers can't modify the stored one.
+func cloneNode(node *Node) *Node {
+	clone := *node
+	clone.InputsOutputs = make([]*InputOutput, 0, len(node.InputsOutputs))
+	for _, io := range node.InputsOutputs {
+		input_output := *io
+		input_output.Connections = make([]*Connection, 0, len(io.Connections))
+		for _, c := range io.Connections {
+			connection := *c
+			input_output.Connections = append(input_output.Connections, &connection)
+		}
+		clone.InputsOutputs = append(clone.InputsOutputs, &input_output)
+	}
+	return &clone
+}
+
+func (s *MemoryStore) deleteNode(node *Node) {
+	delete(s.parents, node.Data.Uid)
+	for _, input_output := range node.InputsOutputs {
+		for _, connection := range input_output.Connections {
+			delete(s.parents, connection.Uid)
+		}
+		delete(s.parents, input_output.Uid)
+	}
+	delete(s.nodes, node.Uid)
+}
+
+// deleteModule removes a module with its nodes and comments.
+func (s *MemoryStore) deleteModule(uid string) {
+	for _, node := range s.moduleNodes(uid) {
+		s.deleteNode(node)
+	}
+	for comment_uid, comment := range s.comments {
+		if comment.ModuleUID == uid {
+			delete(s.comments, comment_uid)
+		}
+	}
+	delete(s.modules, uid)
+}
+
+func (s *MemoryStore) moduleNodes(module_uid string) []*Node {
+	var nodes []*Node
+	for _, node := range s.nodes {
+		if node.ModuleUID == module_uid {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return uidLess(nodes[i].Uid, nodes[j].Uid) })
+	return nodes
+}
+
+func (s *MemoryStore) CreateUser(username string, password string) (string, error) {
+	if err := validateUser(username, password); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[username]; ok {
+		return "", fmt.Errorf("user %s: %w", username, errConflict)
+	}
+	user := &User{
+		Uid:        s.newUid(),
+		Username:   username,
+		Password:   password,
+		DgraphType: "User",
+	}
+	s.users[username] = user
+	return user.Uid, nil
+}
+
+func (s *MemoryStore) GetUsersByUsername(username string) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[username]; ok {
+		return []User{*user}, nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) UpdatePassword(username string, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+	user.Password = password
+	return nil
+}
+
+func (s *MemoryStore) UpdateRole(username string, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+	user.Role = role
+	return nil
+}
+
+func (s *MemoryStore) SetUserIdentity(username string, issuer string, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+	user.OIDCIssuer = issuer
+	user.OIDCSubject = subject
+	return nil
+}
+
+func (s *MemoryStore) UserByIdentity(issuer string, subject string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.OIDCIssuer == issuer && user.OIDCSubject == subject {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, fmt.Errorf("user of %s %s: %w", issuer, subject, errNotFound)
+}
+
+func (s *MemoryStore) ListUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []User
+	for _, user := range s.users {
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+// DeleteUser removes a user with all its modules, their nodes and comments,
+// the groups it teaches and its membership in the others.
+func (s *MemoryStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[username]; !ok {
+		return fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+	for uid, module := range s.modules {
+		if module.Owner == username {
+			s.deleteModule(uid)
+		}
+	}
+	for uid, group := range s.groups {
+		if group.Teacher == username {
+			delete(s.groups, uid)
+			continue
+		}
+		group.Members = removeString(group.Members, username)
+	}
+	s.deleteUserSessions(username)
+	for uid, key := range s.apiKeys {
+		if key.Username == username {
+			delete(s.apiKeys, uid)
+		}
+	}
+	delete(s.users, username)
+	return nil
+}
+
+func (s *MemoryStore) CreateSession(session *Session) (string, error) {
+	if err := validateSession(session); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[session.Username]; !ok {
+		return "", fmt.Errorf("user %s: %w", session.Username, errNotFound)
+	}
+	new_session := *session
+	new_session.Uid = s.newUid()
+	new_session.DgraphType = "Session"
+	s.sessions[new_session.Uid] = &new_session
+	return new_session.Uid, nil
+}
+
+func (s *MemoryStore) GetSession(uid string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[uid]
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", uid, errNotFound)
+	}
+	clone := *session
+	return &clone, nil
+}
+
+// UpdateSession stores the new token hash and expiry of a session.
+func (s *MemoryStore) UpdateSession(session *Session) error {
+	if err := validateSession(session); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[session.Uid]
+	if !ok {
+		return fmt.Errorf("session %s: %w", session.Uid, errNotFound)
+	}
+	stored.TokenHash = session.TokenHash
+	stored.ExpiresAt = session.ExpiresAt
+	return nil
+}
+
+func (s *MemoryStore) DeleteSession(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[uid]; !ok {
+		return fmt.Errorf("session %s: %w", uid, errNotFound)
+	}
+	delete(s.sessions, uid)
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserSessions(username string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteUserSessions(username), nil
+}
+
+func (s *MemoryStore) deleteUserSessions(username string) int {
+	deleted := 0
+	for uid, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, uid)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (s *MemoryStore) CreateAPIKey(key *APIKey) (string, error) {
+	if err := validateAPIKey(key); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[key.Username]; !ok {
+		return "", fmt.Errorf("user %s: %w", key.Username, errNotFound)
+	}
+	new_key := *key
+	new_key.Uid = s.newUid()
+	new_key.DgraphType = "APIKey"
+	s.apiKeys[new_key.Uid] = &new_key
+	return new_key.Uid, nil
+}
+
+func (s *MemoryStore) GetAPIKey(uid string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[uid]
+	if !ok {
+		return nil, fmt.Errorf("api key %s: %w", uid, errNotFound)
+	}
+	clone := *key
+	return &clone, nil
+}
+
+func (s *MemoryStore) UserAPIKeys(username string) ([]*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []*APIKey{}
+	for _, key := range s.apiKeys {
+		if key.Username == username {
+			clone := *key
+			keys = append(keys, &clone)
+		}
+	}
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+func (s *MemoryStore) DeleteAPIKey(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.apiKeys[uid]; !ok {
+		return fmt.Errorf("api key %s: %w", uid, errNotFound)
+	}
+	delete(s.apiKeys, uid)
+	return nil
+}
+
+func (s *MemoryStore) CreateGroup(group *Group) (*Group, error) {
+	if err := validateGroup(group); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, err := s.unusedJoinCode()
+	if err != nil {
+		return nil, err
+	}
+	new_group := &Group{
+		Uid:        s.newUid(),
+		Name:       group.Name,
+		Teacher:    group.Teacher,
+		Members:    append([]string(nil), group.Members...),
+		Code:       code,
+		DgraphType: "Group",
+	}
+	s.groups[new_group.Uid] = new_group
+	return cloneGroup(new_group), nil
+}
+
+func (s *MemoryStore) unusedJoinCode() (string, error) {
+	for i := 0; i < joinCodeAttempts; i++ {
+		code, err := newJoinCode()
+		if err != nil {
+			return "", err
+		}
+		if s.groupByCode(code) == nil {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("no unused join code: %w", errConflict)
+}
+
+func (s *MemoryStore) groupByCode(code string) *Group {
+	for _, group := range s.groups {
+		if group.Code == code {
+			return group
+		}
+	}
+	return nil
+}
+
+func cloneGroup(group *Group) *Group {
+	clone := *group
+	clone.Members = append([]string(nil), group.Members...)
+	return &clone
+}
+
+func (s *MemoryStore) GetGroups() ([]*Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var groups []*Group
+	for _, group := range s.groups {
+		groups = append(groups, cloneGroup(group))
+	}
+	sort.Slice(groups, func(i, j int) bool { return uidLess(groups[i].Uid, groups[j].Uid) })
+	return groups, nil
+}
+
+func (s *MemoryStore) GetGroup(uid string) (*Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.groups[uid]
+	if !ok {
+		return nil, fmt.Errorf("group %s: %w", uid, errNotFound)
+	}
+	return cloneGroup(group), nil
+}
+
+func (s *MemoryStore) GroupByCode(code string) (*Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group := s.groupByCode(code)
+	if group == nil {
+		return nil, fmt.Errorf("group code %s: %w", code, errNotFound)
+	}
+	return cloneGroup(group), nil
+}
+
+func (s *MemoryStore) AddGroupMember(group_uid string, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.groups[group_uid]
+	if !ok {
+		return fmt.Errorf("group %s: %w", group_uid, errNotFound)
+	}
+	if _, ok := s.users[username]; !ok {
+		return fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+	if !hasString(group.Members, username) {
+		group.Members = append(group.Members, username)
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateComment(comment *Comment) (string, error) {
+	if err := validateComment(comment); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.modules[comment.ModuleUID]; !ok {
+		return "", fmt.Errorf("module %s: %w", comment.ModuleUID, errNotFound)
+	}
+	new_comment := *comment
+	new_comment.Uid = s.newUid()
+	new_comment.DgraphType = "Comment"
+	s.comments[new_comment.Uid] = &new_comment
+	return new_comment.Uid, nil
+}
+
+func (s *MemoryStore) ModuleComments(module_uid string) ([]*Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var comments []*Comment
+	for _, comment := range s.comments {
+		if comment.ModuleUID == module_uid {
+			c := *comment
+			comments = append(comments, &c)
+		}
+	}
+	sortComments(comments)
+	return comments, nil
+}
+
+func (s *MemoryStore) GetModuleByName(name string, username string) ([]Module, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var modules []Module
+	for _, module := range s.modules {
+		if module.Name == name && module.Owner == username {
+			modules = append(modules, *module)
+		}
+	}
+	return modules, nil
+}
+
+func (s *MemoryStore) CreateModule(module *Module) (string, error) {
+	if err := validateModule(module); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	new_module := &Module{
+		Uid:        s.newUid(),
+		Name:       module.Name,
+		Owner:      module.Owner,
+		DgraphType: "Module",
+	}
+	s.modules[new_module.Uid] = new_module
+	return new_module.Uid, nil
+}
+
+func (s *MemoryStore) UserGetModules(username string) ([]*Module, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var modules []*Module
+	for _, module := range s.modules {
+		if module.Owner == username {
+			m := *module
+			modules = append(modules, &m)
+		}
+	}
+	sort.Slice(modules, func(i, j int) bool { return uidLess(modules[i].Uid, modules[j].Uid) })
+	return modules, nil
+}
+
+func (s *MemoryStore) DeleteModule(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.modules[uid]; !ok {
+		return fmt.Errorf("module %s: %w", uid, errNotFound)
+	}
+	s.deleteModule(uid)
+	return nil
+}
+
+func (s *MemoryStore) ClearModule(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.modules[uid]; !ok {
+		return fmt.Errorf("module %s: %w", uid, errNotFound)
+	}
+	for _, node := range s.moduleNodes(uid) {
+		s.deleteNode(node)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ModuleOf(uid string) (*Module, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	module_uid := uid
+	if _, ok := s.modules[uid]; !ok {
+		node_uid := uid
+		if parent, ok := s.parents[uid]; ok {
+			node_uid = parent
+		}
+		node, ok := s.nodes[node_uid]
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", uid, errNotFound)
+		}
+		module_uid = node.ModuleUID
+	}
+	module, ok := s.modules[module_uid]
+	if !ok {
+		return nil, fmt.Errorf("module of %s: %w", uid, errNotFound)
+	}
+	m := *module
+	return &m, nil
+}
+
+func (s *MemoryStore) CreateNode(node *Node) (Node, error) {
+	if err := validateNode(node); err != nil {
+		return Node{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := cloneNode(node)
+	created.Uid = s.newUid()
+	created.DgraphType = "Node"
+	created.Data.Uid = s.newUid()
+	created.Data.DgraphType = "Data"
+	s.parents[created.Data.Uid] = created.Uid
+	for _, input_output := range created.InputsOutputs {
+		input_output.Uid = s.newUid()
+		input_output.DgraphType = "InputOutput"
+		s.parents[input_output.Uid] = created.Uid
+		for _, connection := range input_output.Connections {
+			connection.Uid = s.newUid()
+			connection.DgraphType = "Connection"
+			s.parents[connection.Uid] = created.Uid
+		}
+	}
+	s.nodes[created.Uid] = created
+	return *cloneNode(created), nil
+}
+
+func (s *MemoryStore) SaveModuleGraph(module_uid string, nodes []*Node) (*GraphDiff, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.modules[module_uid]; !ok {
+		return nil, errModuleNotFound
+	}
+
+	// Merge everything first so nothing changes if a node can't be merged.
+	newUid := func() (string, error) { return s.newUid(), nil }
+	created, updated, deleted := diffGraph(s.moduleNodes(module_uid), nodes)
+	var merged []*Node
+	var removed []removedRef
+	for _, node := range created {
+		m, _, err := mergeNode(&Node{Uid: s.newUid(), ModuleUID: module_uid}, node, newUid)
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, m)
+	}
+	for _, pair := range updated {
+		m, r, err := mergeNode(pair[0], pair[1], newUid)
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, m)
+		removed = append(removed, r...)
+	}
+
+	for _, node := range deleted {
+		s.deleteNode(node)
+	}
+	for _, ref := range removed {
+		delete(s.parents, ref.Uid)
+	}
+	for _, node := range merged {
+		for _, uid := range nodeUids(node)[1:] {
+			s.parents[uid] = node.Uid
+		}
+		s.nodes[node.Uid] = node
+	}
+
+	diff := &GraphDiff{Created: len(created), Updated: len(updated), Deleted: len(deleted)}
+	for _, node := range s.moduleNodes(module_uid) {
+		diff.Nodes = append(diff.Nodes, cloneNode(node))
+	}
+	return diff, nil
+}
+
+func (s *MemoryStore) ModuleGetNodes(module_uid string) ([]*Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var nodes []*Node
+	for _, node := range s.moduleNodes(module_uid) {
+		nodes = append(nodes, cloneNode(node))
+	}
+	return nodes, nil
+}
+
+// DeleteNode removes a node and the connections of the other nodes of the
+// module that pointed at it.
+func (s *MemoryStore) DeleteNode(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[uid]
+	if !ok {
+		return fmt.Errorf("node %s: %w", uid, errNotFound)
+	}
+	nodes := s.moduleNodes(node.ModuleUID)
+	s.deleteNode(node)
+
+	refs := peerConnections(nodes, []*Node{node})
+	removeConnections(nodes, refs)
+	for _, ref := range refs {
+		delete(s.parents, ref.Uid)
+	}
+	return nil
+}
+
+func (s *MemoryStore) NodeUpdatePosition(node_uid string, pos_x float32, pos_y float32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[node_uid]
+	if !ok {
+		return fmt.Errorf("node %s: %w", node_uid, errNotFound)
+	}
+	node.PosX = pos_x
+	node.PosY = pos_y
+	return nil
+}
+
+func (s *MemoryStore) UpdateData(data *Data) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[s.parents[data.Uid]]
+	if !ok || node.Data.Uid != data.Uid {
+		return fmt.Errorf("data %s: %w", data.Uid, errNotFound)
+	}
+	node.Data.Name = data.Name
+	node.Data.Value = data.Value
+	node.Data.Operator = data.Operator
+	return nil
+}
+
+func (s *MemoryStore) AddConnection(input_output_uid string, connection *Connection) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[s.parents[input_output_uid]]
+	if !ok {
+		return "", fmt.Errorf("input/output %s: %w", input_output_uid, errNotFound)
+	}
+	for _, input_output := range node.InputsOutputs {
+		if input_output.Uid == input_output_uid {
+			new_connection := *connection
+			new_connection.Uid = s.newUid()
+			new_connection.DgraphType = "Connection"
+			input_output.Connections = append(input_output.Connections, &new_connection)
+			s.parents[new_connection.Uid] = node.Uid
+			return new_connection.Uid, nil
+		}
+	}
+	// The uid may be the data or a connection of the node
+	return "", fmt.Errorf("input/output %s: %w", input_output_uid, errNotFound)
+}
+
+func (s *MemoryStore) DeleteConnection(parent_uid string, connection *Connection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[s.parents[parent_uid]]
+	if !ok {
+		return fmt.Errorf("input/output %s: %w", parent_uid, errNotFound)
+	}
+	found := false
+	for _, input_output := range node.InputsOutputs {
+		if input_output.Uid != parent_uid {
+			continue
+		}
+		connections := input_output.Connections[:0]
+		for _, c := range input_output.Connections {
+			if c.Uid == connection.Uid {
+				found = true
+				continue
+			}
+			connections = append(connections, c)
+		}
+		input_output.Connections = connections
+	}
+	// Only a connection of the port, whose owner was checked, may go
+	if !found {
+		return fmt.Errorf("connection %s of input/output %s: %w", connection.Uid, parent_uid, errNotFound)
+	}
+	delete(s.parents, connection.Uid)
+	return nil
+}
+
+// CollectGarbage removes the nodes of modules that no longer exist, the
+// connections pointing at missing nodes and the connections that were
+// created but never attached to a port.
+func (s *MemoryStore) CollectGarbage(dryRun bool) (*GCReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	modules := make(map[string]bool, len(s.modules))
+	for uid := range s.modules {
+		modules[uid] = true
+	}
+	var nodes []*Node
+	for _, node := range s.nodes {
+		nodes = append(nodes, node)
+	}
+	orphans, dangling := gcScanNodes(modules, nodes)
+
+	report := &GCReport{DryRun: dryRun}
+	for _, node := range orphans {
+		report.OrphanNodes = append(report.OrphanNodes, node.Uid)
+	}
+	for _, ref := range dangling {
+		report.DanglingConnections = append(report.DanglingConnections, ref.Uid)
+	}
+	now := time.Now()
+	for uid, session := range s.sessions {
+		if session.ExpiresAt.Before(now) {
+			report.ExpiredSessions = append(report.ExpiredSessions, uid)
+		}
+	}
+	report.sortUids()
+	if dryRun {
+		return report, nil
+	}
+
+	for _, uid := range report.ExpiredSessions {
+		delete(s.sessions, uid)
+	}
+
+	for _, node := range orphans {
+		s.deleteNode(node)
+	}
+	removeConnections(nodes, dangling)
+	for _, ref := range dangling {
+		delete(s.parents, ref.Uid)
+	}
+	return report, nil
+}
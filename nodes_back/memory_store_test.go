@@ -0,0 +1,7 @@
+package main
+
+import "testing"
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return NewMemoryStore() })
+}
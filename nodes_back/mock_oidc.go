@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// runMockOIDC is the "mock-oidc" subcommand: a minimal OpenID provider to
+// try the provider login locally. Anybody can sign in as anyone, picking
+// the username and the groups on a form, so never expose it.
+func runMockOIDC(args []string) error {
+	fs := flag.NewFlagSet("mock-oidc", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:9998", "address to listen on")
+	issuer := fs.String("issuer", "", "issuer URL (default http://<listen>)")
+	fs.Parse(args)
+	if *issuer == "" {
+		*issuer = "http://" + *listen
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	m := &mockOIDC{issuer: strings.TrimSuffix(*issuer, "/"), key: key, codes: make(map[string]mockGrant)}
+
+	r := chi.NewRouter()
+	r.Get("/.well-known/openid-configuration", m.discovery)
+	r.Get("/jwks", m.jwks)
+	r.Get("/authorize", m.authorizeForm)
+	r.Post("/authorize", m.authorize)
+	r.Post("/token", m.token)
+
+	infof("Mock OpenID provider %s listening on %s", m.issuer, *listen)
+	return http.ListenAndServe(*listen, r)
+}
+
+// mockOIDC signs its ID tokens with a key made at startup, so the tokens
+// of a previous run are no longer valid.
+type mockOIDC struct {
+	issuer string
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+// mockGrant is what an authorization code stands for until /token
+// redeems it.
+type mockGrant struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	username    string
+	groups      []string
+	expires     time.Time
+}
+
+const mockKeyID = "mock"
+
+func (m *mockOIDC) discovery(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, map[string]interface{}{
+		"issuer":                                m.issuer,
+		"authorization_endpoint":                m.issuer + "/authorize",
+		"token_endpoint":                        m.issuer + "/token",
+		"jwks_uri":                              m.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "preferred_username", "email", "name", "groups"},
+	})
+}
+
+func (m *mockOIDC) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := m.key.PublicKey
+	render.JSON(w, r, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": mockKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var mockLoginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Mock OpenID provider</title></head>
+<body>
+<h1>Mock OpenID provider</h1>
+<p>Client {{.client_id}}</p>
+<form method="post">
+{{range $k, $v := .}}<input type="hidden" name="{{$k}}" value="{{$v}}">
+{{end}}<p><label>Username <input name="username" autofocus required></label></p>
+<p><label>Groups <input name="groups" placeholder="teachers, staff"></label></p>
+<p><button>Sign in</button></p>
+</form>
+</body></html>
+`))
+
+// authorizeForm asks who to sign in as, carrying the request parameters
+// over to the POST.
+func (m *mockOIDC) authorizeForm(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") == "" || q.Get("redirect_uri") == "" {
+		http.Error(w, "response_type=code, client_id and redirect_uri are required", http.StatusBadRequest)
+		return
+	}
+	params := map[string]string{}
+	for _, k := range []string{"client_id", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		params[k] = q.Get(k)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	mockLoginPage.Execute(w, params)
+}
+
+// authorize issues a code for the user of the form and sends the browser
+// back to the client.
+func (m *mockOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(r.PostForm.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	challenge := r.PostForm.Get("code_challenge")
+	if challenge != "" && r.PostForm.Get("code_challenge_method") != "S256" {
+		http.Error(w, "only the S256 code challenge is supported", http.StatusBadRequest)
+		return
+	}
+
+	var groups []string
+	for _, g := range strings.Split(r.PostForm.Get("groups"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code := hex.EncodeToString(b)
+
+	m.mu.Lock()
+	m.codes[code] = mockGrant{
+		clientID:    r.PostForm.Get("client_id"),
+		redirectURI: redirect.String(),
+		nonce:       r.PostForm.Get("nonce"),
+		challenge:   challenge,
+		username:    strings.TrimSpace(r.PostForm.Get("username")),
+		groups:      groups,
+		expires:     time.Now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+
+	q := redirect.Query()
+	q.Set("code", code)
+	if state := r.PostForm.Get("state"); state != "" {
+		q.Set("state", state)
+	}
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// mockTokenError answers /token the way RFC 6749 section 5.2 says.
+func mockTokenError(w http.ResponseWriter, r *http.Request, code string, description string) {
+	render.Status(r, http.StatusBadRequest)
+	render.JSON(w, r, map[string]string{"error": code, "error_description": description})
+}
+
+// token redeems a code, once, for a signed ID token. Any client secret is
+// accepted.
+func (m *mockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		mockTokenError(w, r, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		mockTokenError(w, r, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	client_id, _, ok := r.BasicAuth()
+	if !ok {
+		client_id = r.PostForm.Get("client_id")
+	}
+
+	code := r.PostForm.Get("code")
+	m.mu.Lock()
+	grant, found := m.codes[code]
+	delete(m.codes, code)
+	m.mu.Unlock()
+
+	switch {
+	case !found || time.Now().After(grant.expires):
+		mockTokenError(w, r, "invalid_grant", "unknown or expired code")
+		return
+	case grant.clientID != client_id:
+		mockTokenError(w, r, "invalid_client", "the code was issued to another client")
+		return
+	case grant.redirectURI != r.PostForm.Get("redirect_uri"):
+		mockTokenError(w, r, "invalid_grant", "redirect_uri does not match")
+		return
+	}
+	if grant.challenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(grant.challenge)) != 1 {
+			mockTokenError(w, r, "invalid_grant", "code_verifier does not match the challenge")
+			return
+		}
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                m.issuer,
+		"sub":                "mock|" + grant.username,
+		"aud":                grant.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"preferred_username": grant.username,
+		"email":              grant.username + "@example.com",
+		"name":               grant.username,
+	}
+	if grant.nonce != "" {
+		claims["nonce"] = grant.nonce
+	}
+	if grant.groups != nil {
+		claims["groups"] = grant.groups
+	}
+	id_token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	id_token.Header["kid"] = mockKeyID
+	signed, err := id_token.SignedString(m.key)
+	if err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, map[string]string{"error": "server_error", "error_description": err.Error()})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	render.JSON(w, r, map[string]interface{}{
+		"access_token": fmt.Sprintf("mock-%s", code),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
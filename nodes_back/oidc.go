@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-chi/render"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2"
+)
+
+// The OpenID Connect login is the authorization code flow with PKCE. The
+// state, nonce and code verifier travel in a short-lived signed cookie, so
+// the server keeps nothing between the redirect and the callback.
+const (
+	oidcCookie    = "nodes_oidc"
+	oidcCookieTTL = 10 * time.Minute
+)
+
+// oidcHTTPClient talks to the provider, for the discovery, the keys and
+// the code exchange.
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProvider is discovered on the first login and kept, so the server
+// starts even when the provider is down.
+var oidcProvider struct {
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func oidcEnabled() bool {
+	return config.OIDC.Issuer != ""
+}
+
+// oidcSetup returns the OAuth2 client and the ID token verifier of the
+// configured provider.
+func oidcSetup() (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	oidcProvider.mu.Lock()
+	defer oidcProvider.mu.Unlock()
+
+	if oidcProvider.provider == nil {
+		// The provider keeps this context to refresh its keys later on
+		ctx := oidc.ClientContext(context.Background(), oidcHTTPClient)
+		provider, err := oidc.NewProvider(ctx, config.OIDC.Issuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("oidc discovery: %w: %v", errUnavailable, err)
+		}
+		oidcProvider.provider = provider
+	}
+	provider := oidcProvider.provider
+
+	oauth := &oauth2.Config{
+		ClientID:     config.OIDC.ClientID,
+		ClientSecret: config.OIDC.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  config.OIDC.RedirectURL,
+		Scopes:       config.OIDC.Scopes,
+	}
+	return oauth, provider.Verifier(&oidc.Config{ClientID: config.OIDC.ClientID}), nil
+}
+
+// oidcStateClaims is the content of the cookie. The ID is the state.
+type oidcStateClaims struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+func oidcCookiePath() string {
+	if u, err := url.Parse(config.OIDC.RedirectURL); err == nil && u.Path != "" {
+		return u.Path
+	}
+	return "/"
+}
+
+// OIDCInfo tells the frontend whether to offer the provider login.
+func OIDCInfo(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, map[string]bool{"enabled": oidcEnabled()})
+}
+
+// OIDCLogin sends the browser to the provider.
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		render.Render(w, r, ErrStore(fmt.Errorf("oidc login: %w", errNotFound)))
+		return
+	}
+	oauth, _, err := oidcSetup()
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+
+	var state, nonce, verifier string
+	for _, s := range []*string{&state, &nonce, &verifier} {
+		if *s, err = randomString(); err != nil {
+			render.Render(w, r, ErrStore(err))
+			return
+		}
+	}
+	now := time.Now()
+	claims := oidcStateClaims{nonce, verifier, jwt.RegisteredClaims{
+		ID:        state,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(oidcCookieTTL)),
+	}}
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.AuthSecret))
+	if err != nil {
+		render.Render(w, r, ErrStore(err))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    cookie,
+		Path:     oidcCookiePath(),
+		MaxAge:   int(oidcCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.OIDC.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	http.Redirect(w, r, oauth.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), http.StatusFound)
+}
+
+// oidcState checks the cookie set by OIDCLogin against the state the
+// provider sent back, and removes it.
+func oidcState(w http.ResponseWriter, r *http.Request) (*oidcStateClaims, error) {
+	c, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no login in progress, start again", errUnauthorized)
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: oidcCookiePath(), MaxAge: -1})
+
+	claims := &oidcStateClaims{}
+	_, err = jwt.ParseWithClaims(c.Value, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(config.AuthSecret), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: login cookie: %v", errUnauthorized, err)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.ID), []byte(r.URL.Query().Get("state"))) != 1 {
+		return nil, fmt.Errorf("%w: state does not match", errUnauthorized)
+	}
+	return claims, nil
+}
+
+// OIDCCallback finishes the login: it trades the code for the ID token,
+// creates the user on its first login, updates its role from the claims
+// and opens a session.
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		render.Render(w, r, ErrStore(fmt.Errorf("oidc login: %w", errNotFound)))
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		oidcFail(w, r, fmt.Errorf("%w: provider: %s %s", errUnauthorized, e, r.URL.Query().Get("error_description")))
+		return
+	}
+	state, err := oidcState(w, r)
+	if err != nil {
+		oidcFail(w, r, err)
+		return
+	}
+	oauth, verifier, err := oidcSetup()
+	if err != nil {
+		oidcFail(w, r, err)
+		return
+	}
+
+	ctx := oidc.ClientContext(r.Context(), oidcHTTPClient)
+	token, err := oauth.Exchange(ctx, r.URL.Query().Get("code"), oauth2.SetAuthURLParam("code_verifier", state.Verifier))
+	if err != nil {
+		oidcFail(w, r, fmt.Errorf("%w: code exchange: %v", errUnauthorized, err))
+		return
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		oidcFail(w, r, fmt.Errorf("%w: no id_token in the token response", errUnauthorized))
+		return
+	}
+	id_token, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		oidcFail(w, r, fmt.Errorf("%w: id token: %v", errUnauthorized, err))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(id_token.Nonce), []byte(state.Nonce)) != 1 {
+		oidcFail(w, r, fmt.Errorf("%w: nonce does not match", errUnauthorized))
+		return
+	}
+
+	claims := map[string]interface{}{}
+	if err := id_token.Claims(&claims); err != nil {
+		oidcFail(w, r, err)
+		return
+	}
+	username, role, err := oidcIdentity(claims)
+	if err != nil {
+		oidcFail(w, r, err)
+		return
+	}
+	user, created, err := provisionUser(id_token.Issuer, id_token.Subject, username, role)
+	if err != nil {
+		oidcFail(w, r, err)
+		return
+	}
+	auditf("oidc_login user=%q subject=%q role=%s created=%t ip=%s", user.Username, id_token.Subject, roleOf(user), created, clientIP(r))
+
+	tokens, err := startSession(user.Username)
+	if err != nil {
+		oidcFail(w, r, err)
+		return
+	}
+	resp := &LoginResponse{Username: user.Username, Role: roleOf(user)}
+	resp.setTokens(tokens)
+
+	if config.OIDC.FrontendURL == "" {
+		render.Status(r, http.StatusCreated)
+		render.Render(w, r, resp)
+		return
+	}
+	fragment := url.Values{}
+	fragment.Set("token", resp.Token)
+	fragment.Set("expires_at", strconv.FormatInt(resp.ExpiresAt, 10))
+	fragment.Set("refresh_token", resp.RefreshToken)
+	fragment.Set("username", resp.Username)
+	fragment.Set("role", resp.Role)
+	http.Redirect(w, r, config.OIDC.FrontendURL+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// oidcFail logs a failed provider login and sends the browser back to the
+// frontend with the error, or answers it as JSON without a frontend.
+func oidcFail(w http.ResponseWriter, r *http.Request, err error) {
+	auditf("oidc_login_failed ip=%s error=%q", clientIP(r), err.Error())
+	if config.OIDC.FrontendURL == "" {
+		if errors.Is(err, errUnauthorized) {
+			render.Render(w, r, ErrUnauthorized(err))
+		} else {
+			render.Render(w, r, ErrStore(err))
+		}
+		return
+	}
+	fragment := url.Values{}
+	fragment.Set("error", err.Error())
+	http.Redirect(w, r, config.OIDC.FrontendURL+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// roleRank orders the roles, so a user in several mapped groups gets the
+// highest one.
+var roleRank = map[string]int{roleStudent: 0, roleTeacher: 1, roleAdmin: 2}
+
+// oidcIdentity maps the ID token claims onto a username and a role. An
+// email claim loses its domain, which must be one of the configured
+// domains. The role is empty when no role claim is configured: it is then
+// managed locally.
+func oidcIdentity(claims map[string]interface{}) (string, string, error) {
+	claim := config.OIDC.UsernameClaim
+	username, _ := claims[claim].(string)
+	if i := strings.LastIndexByte(username, '@'); i >= 0 {
+		if !oidcDomain(username[i+1:]) {
+			return "", "", fmt.Errorf("claim %s %q: domain not allowed: %w", claim, username, errForbidden)
+		}
+		username = username[:i]
+	}
+	if !usernamePattern.MatchString(username) {
+		return "", "", fmt.Errorf("claim %s %q is not a valid username: %w", claim, username, errValidation)
+	}
+
+	if config.OIDC.RoleClaim == "" {
+		return username, "", nil
+	}
+	var values []string
+	switch v := claims[config.OIDC.RoleClaim].(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	role := roleStudent
+	for _, value := range values {
+		if mapped, ok := config.OIDC.Roles[value]; ok && roleRank[mapped] > roleRank[role] {
+			role = mapped
+		}
+	}
+	return username, role, nil
+}
+
+func oidcDomain(domain string) bool {
+	for _, allowed := range config.OIDC.Domains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// provisionUser returns the user the provider created for its subject,
+// creating it on its first login. Its password is random and never told,
+// so it can only sign in through the provider. A login is never linked to
+// an account the provider didn't create, so taking the username of a
+// password account gets nowhere. A non-empty role replaces the stored one.
+func provisionUser(issuer string, subject string, username string, role string) (*User, bool, error) {
+	user, err := store.UserByIdentity(issuer, subject)
+	created := false
+	if errors.Is(err, errNotFound) {
+		user, err = createOIDCUser(issuer, subject, username)
+		created = true
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if role != "" && roleOf(user) != role {
+		if err := store.UpdateRole(user.Username, role); err != nil {
+			return nil, false, err
+		}
+		user.Role = role
+	}
+	return user, created, nil
+}
+
+// createOIDCUser creates the account of a subject seen for the first time.
+func createOIDCUser(issuer string, subject string, username string) (*User, error) {
+	secret, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(secret)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := store.CreateUser(username, hash); err != nil {
+		if errors.Is(err, errConflict) {
+			return nil, fmt.Errorf("username %s belongs to an account the provider didn't create: %w", username, errConflict)
+		}
+		return nil, err
+	}
+	if err := store.SetUserIdentity(username, issuer, subject); err != nil {
+		// An account without its identity could never sign in
+		store.DeleteUser(username)
+		return nil, err
+	}
+
+	users, err := store.GetUsersByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("user %s: %w", username, errNotFound)
+	}
+	return &users[0], nil
+}
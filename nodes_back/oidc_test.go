@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// useOIDC configures the provider login against issuer, with the groups
+// claim mapped onto the roles.
+func useOIDC(t *testing.T, issuer string) {
+	config.OIDC = OIDCConfig{
+		Issuer:        issuer,
+		ClientID:      "nodes",
+		ClientSecret:  "secret",
+		RedirectURL:   "http://localhost:3333/user/oidc/callback",
+		Scopes:        []string{"openid", "profile", "email"},
+		UsernameClaim: "preferred_username",
+		Domains:       []string{"school.edu"},
+		RoleClaim:     "groups",
+		Roles:         map[string]string{"teachers": roleTeacher, "staff": roleAdmin},
+	}
+	oidcProvider.provider = nil
+	t.Cleanup(func() { oidcProvider.provider = nil })
+}
+
+func TestOIDCIdentity(t *testing.T) {
+	useMemoryStore(t)
+	useOIDC(t, "https://id.example.com")
+
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		username string
+		role     string
+		err      error
+	}{
+		{"username", map[string]interface{}{"preferred_username": "alice"}, "alice", roleStudent, nil},
+		{"email of an allowed domain", map[string]interface{}{"preferred_username": "alice@School.edu"}, "alice", roleStudent, nil},
+		{"email of another domain", map[string]interface{}{"preferred_username": "alice@evil.com"}, "", "", errForbidden},
+		{"invalid username", map[string]interface{}{"preferred_username": "a b"}, "", "", errValidation},
+		{"no username", map[string]interface{}{"email": "alice@school.edu"}, "", "", errValidation},
+		{"one group", map[string]interface{}{"preferred_username": "tina", "groups": "teachers"}, "tina", roleTeacher, nil},
+		{"highest of the groups", map[string]interface{}{"preferred_username": "ada", "groups": []interface{}{"staff", "teachers", "chess"}}, "ada", roleAdmin, nil},
+		{"unmapped group", map[string]interface{}{"preferred_username": "bob", "groups": []interface{}{"chess"}}, "bob", roleStudent, nil},
+	}
+	for _, tt := range tests {
+		username, role, err := oidcIdentity(tt.claims)
+		if !isErr(err, tt.err) || username != tt.username || role != tt.role {
+			t.Errorf("%s: oidcIdentity = %q, %q, %v, want %q, %q, %v", tt.name, username, role, err, tt.username, tt.role, tt.err)
+		}
+	}
+
+	// Without a role claim the roles are managed locally
+	config.OIDC.RoleClaim = ""
+	if _, role, err := oidcIdentity(map[string]interface{}{"preferred_username": "tina", "groups": "teachers"}); err != nil || role != "" {
+		t.Errorf("oidcIdentity without a role claim = %q, %v, want no role", role, err)
+	}
+}
+
+func TestProvisionUser(t *testing.T) {
+	s := useMemoryStore(t)
+	const issuer = "https://id.example.com"
+
+	user, created, err := provisionUser(issuer, "sub-1", "olga", roleStudent)
+	if err != nil || !created || user.Username != "olga" || user.OIDCSubject != "sub-1" {
+		t.Fatalf("first login = %+v, %v, %v, want olga created", user, created, err)
+	}
+	if user.Password == "" {
+		t.Error("olga has no password hash, a login with an empty password could match")
+	}
+
+	// The subject finds its account even under another name, and the role follows the claims
+	user, created, err = provisionUser(issuer, "sub-1", "olga.r", roleTeacher)
+	if err != nil || created || user.Username != "olga" || roleOf(user) != roleTeacher {
+		t.Errorf("second login = %+v, %v, %v, want olga as a teacher", user, created, err)
+	}
+	if users, _ := s.GetUsersByUsername("olga"); len(users) != 1 || roleOf(&users[0]) != roleTeacher {
+		t.Errorf("stored olga = %+v, want a teacher", users)
+	}
+	// No role in the claims leaves the stored one
+	if user, _, err := provisionUser(issuer, "sub-1", "olga", ""); err != nil || roleOf(user) != roleTeacher {
+		t.Errorf("login without role = %+v, %v, want olga still a teacher", user, err)
+	}
+
+	// Neither a password account nor the account of another subject is taken over
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser("alice", hash); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ issuer, subject, username string }{
+		{issuer, "sub-2", "alice"},
+		{issuer, "sub-3", "olga"},
+		{"https://other.example.com", "sub-1", "olga"},
+	} {
+		if user, _, err := provisionUser(tt.issuer, tt.subject, tt.username, ""); !errors.Is(err, errConflict) {
+			t.Errorf("login of %s %s as %s = %+v, %v, want conflict", tt.issuer, tt.subject, tt.username, user, err)
+		}
+	}
+	if users, _ := s.GetUsersByUsername("alice"); len(users) != 1 || users[0].OIDCSubject != "" {
+		t.Errorf("alice = %+v, want her account unlinked", users)
+	}
+}
+
+// mockProvider serves the mock provider of the mock-oidc subcommand.
+func mockProvider(t *testing.T) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := chi.NewRouter()
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	m := &mockOIDC{issuer: srv.URL, key: key, codes: make(map[string]mockGrant)}
+	r.Get("/.well-known/openid-configuration", m.discovery)
+	r.Get("/jwks", m.jwks)
+	r.Post("/authorize", m.authorize)
+	r.Post("/token", m.token)
+	return srv
+}
+
+// oidcLogin goes through the whole provider login as username, the way a
+// browser would, and returns the response of the callback.
+func oidcLogin(t *testing.T, username string, groups string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	OIDCLogin(w, httptest.NewRequest("GET", "/user/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: status %d (%s), want a redirect", w.Code, w.Body)
+	}
+	cookies := w.Result().Cookies()
+	authorize, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The form of the provider
+	form := url.Values{"username": {username}, "groups": {groups}}
+	for k, v := range authorize.Query() {
+		form[k] = v
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.PostForm(config.OIDC.Issuer+"/authorize", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("provider: status %d, %v, want a redirect", resp.StatusCode, err)
+	}
+
+	r := httptest.NewRequest("GET", callback.RequestURI(), nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	OIDCCallback(w, r)
+	return w
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s := useMemoryStore(t)
+	useOIDC(t, mockProvider(t).URL)
+	captureAudit(t)
+
+	for _, groups := range []string{"teachers", ""} {
+		w := oidcLogin(t, "tina", groups)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("callback: status %d (%s), want 201", w.Code, w.Body)
+		}
+		var resp LoginResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"teachers": roleTeacher, "": roleStudent}[groups]
+		if resp.Username != "tina" || resp.Role != want || resp.Token == "" || resp.RefreshToken == "" {
+			t.Errorf("login with groups %q = %+v, want tina as %s with tokens", groups, resp, want)
+		}
+	}
+	users, err := s.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].OIDCIssuer != config.OIDC.Issuer || users[0].OIDCSubject != "mock|tina" {
+		t.Errorf("users = %+v, want tina linked to the mock provider", users)
+	}
+
+	// A password account keeps its name
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser("alice", hash); err != nil {
+		t.Fatal(err)
+	}
+	if w := oidcLogin(t, "alice", ""); w.Code != http.StatusConflict {
+		t.Errorf("login as the password account alice: status %d (%s), want 409", w.Code, w.Body)
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	useMemoryStore(t)
+	useOIDC(t, mockProvider(t).URL)
+	captureAudit(t)
+
+	w := httptest.NewRecorder()
+	OIDCCallback(w, httptest.NewRequest("GET", "/user/oidc/callback?code=x&state=y", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("callback without the login cookie: status %d, want 401", w.Code)
+	}
+
+	// A cookie for another state
+	w = httptest.NewRecorder()
+	OIDCLogin(w, httptest.NewRequest("GET", "/user/oidc/login", nil))
+	r := httptest.NewRequest("GET", "/user/oidc/callback?code=x&state=y", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	OIDCCallback(w, r)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "state") {
+		t.Errorf("callback with another state: status %d (%s), want 401", w.Code, w.Body)
+	}
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	useMemoryStore(t)
+
+	w := httptest.NewRecorder()
+	OIDCInfo(w, httptest.NewRequest("GET", "/user/oidc", nil))
+	var info map[string]bool
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || !reflect.DeepEqual(info, map[string]bool{"enabled": false}) {
+		t.Errorf("OIDCInfo = %s, want disabled", w.Body)
+	}
+	for name, handler := range map[string]http.HandlerFunc{"login": OIDCLogin, "callback": OIDCCallback} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s without a provider: status %d, want 404", name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package program
+
+import "strings"
+
+func init() {
+	Register("c", cEmitter{})
+}
+
+// cEmitter writes a C program. The variables are global doubles, so they
+// start at 0.
+type cEmitter struct{}
+
+// cReserved are the keywords, main and the names <stdio.h> declares,
+// including the POSIX ones and the macros gcc defines by default.
+var cReserved = reserved(`auto break case char const continue default do double else
+	enum extern float for goto if inline int long register restrict return short signed
+	sizeof static struct switch typedef union unsigned void volatile while main
+	BUFSIZ EOF FILENAME_MAX FOPEN_MAX L_ctermid L_tmpnam NULL P_tmpdir SEEK_CUR SEEK_END
+	SEEK_SET TMP_MAX FILE fpos_t off_t size_t ssize_t va_list stderr stdin stdout linux unix
+	clearerr clearerr_unlocked ctermid dprintf fclose fdopen feof feof_unlocked ferror
+	ferror_unlocked fflush fflush_unlocked fgetc fgetc_unlocked fgetpos fgets fileno
+	fileno_unlocked flockfile fmemopen fopen fprintf fputc fputc_unlocked fputs fread
+	fread_unlocked freopen fscanf fseek fseeko fsetpos ftell ftello ftrylockfile funlockfile
+	fwrite fwrite_unlocked getc getc_unlocked getchar getchar_unlocked getdelim getline gets
+	getw open_memstream pclose perror popen printf putc putc_unlocked putchar
+	putchar_unlocked puts putw remove rename renameat rewind scanf setbuf setbuffer
+	setlinebuf setvbuf snprintf sprintf sscanf tempnam tmpfile tmpnam tmpnam_r ungetc
+	vdprintf vfprintf vfscanf vprintf vscanf vsnprintf vsprintf vsscanf`)
+
+// cImplementation tells whether name is kept for the compiler and the C
+// library: the variables are globals, and at file scope every name starting
+// with an underscore is.
+func cImplementation(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+func (cEmitter) Indent() string                   { return "    " }
+func (cEmitter) Reserved(name string) bool        { return cReserved[name] || cImplementation(name) }
+func (cEmitter) Number(digits string) string      { return digits + ".0" }
+func (cEmitter) Boolean(comparison string) string { return "(" + comparison + ")" }
+func (cEmitter) Condition(value string) string    { return value + " != 0" }
+func (cEmitter) Print(name string) string         { return `printf("%g\n", ` + name + ");" }
+func (cEmitter) If(condition string) string       { return "if (" + condition + ") {" }
+func (cEmitter) Else() string                     { return "} else {" }
+func (cEmitter) While(condition string) string    { return "while (" + condition + ") {" }
+func (cEmitter) End() string                      { return "}" }
+func (cEmitter) Empty() string                    { return "" }
+
+func (cEmitter) Arithmetic(left string, operator string, right string) string {
+	return left + " " + operator + " " + right
+}
+
+func (cEmitter) Comparison(left string, operator string, right string) string {
+	return left + " " + operator + " " + right
+}
+
+func (cEmitter) Assign(name string, value string) string {
+	return name + " = " + value + ";"
+}
+
+func (e cEmitter) File(code *Code) string {
+	var b strings.Builder
+	b.WriteString("#include <stdio.h>\n\n")
+	if len(code.Variables) > 0 {
+		b.WriteString("double " + strings.Join(code.Variables, ", ") + ";\n\n")
+	}
+	b.WriteString("int main(void) {\n")
+	for _, line := range indentLines(code.Lines, e.Indent()) {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString(e.Indent() + "return 0;\n}\n")
+	return b.String()
+}
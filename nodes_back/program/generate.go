@@ -0,0 +1,380 @@
+package program
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Emitter writes the statements of a program in one language. Generate
+// walks the trees and asks the emitter for every piece of code, so all the
+// languages share the node semantics. Values are numbers; the languages
+// with types use doubles.
+type Emitter interface {
+	// Indent is one level of indentation.
+	Indent() string
+	// Reserved tells whether a variable cannot take name.
+	Reserved(name string) bool
+	// Number is the literal of a decimal integer.
+	Number(digits string) string
+	// Arithmetic is "left operator right", operator being + - * or /.
+	Arithmetic(left string, operator string, right string) string
+	// Comparison is the condition "left operator right".
+	Comparison(left string, operator string, right string) string
+	// Boolean turns a comparison into a value.
+	Boolean(comparison string) string
+	// Condition turns a value into a condition.
+	Condition(value string) string
+	// Assign and Print are statements.
+	Assign(name string, value string) string
+	Print(name string) string
+	// If, Else and While open blocks, End closes them; End and Empty (the
+	// statement of an empty block) may be "", then no line is written.
+	If(condition string) string
+	Else() string
+	While(condition string) string
+	End() string
+	Empty() string
+	// File lays out the whole program.
+	File(code *Code) string
+}
+
+// Code is what Generate hands to the emitter to lay out the program.
+type Code struct {
+	// Lines are the statements, indented from level 0.
+	Lines []string
+	// Variables are the names assigned, in order of first assignment.
+	Variables []string
+	// Prints and Booleans tell whether Print and Boolean were used.
+	Prints   bool
+	Booleans bool
+}
+
+var emitters = map[string]Emitter{}
+
+// Register makes an emitter available to Generate under the name of its
+// language.
+func Register(lang string, emitter Emitter) {
+	emitters[lang] = emitter
+}
+
+// Languages returns the names of the registered languages, sorted.
+func Languages() []string {
+	var names []string
+	for name := range emitters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Supported tells whether lang is registered.
+func Supported(lang string) bool {
+	_, ok := emitters[lang]
+	return ok
+}
+
+// Generate returns the source of the graph in the given language. Every
+// number and operation node becomes a "constant_<id>" variable, and assign
+// nodes print the value they assign. The operands of the condition of a
+// while node are computed once, before the loop, so only the variables the
+// body assigns change it.
+func Generate(lang string, nodes []*Node) (string, error) {
+	emitter, ok := emitters[lang]
+	if !ok {
+		return "", fmt.Errorf("unknown language %q", lang)
+	}
+	trees, err := Build(nodes)
+	if err != nil {
+		return "", err
+	}
+	if err := checkVariables(trees); err != nil {
+		return "", err
+	}
+
+	g := &generator{emitter: emitter, code: &Code{}, assigned: map[string]bool{}}
+	for i, tree := range trees {
+		if i > 0 {
+			g.code.Lines = append(g.code.Lines, "")
+		}
+		if err := g.statement(tree, 0); err != nil {
+			return "", err
+		}
+	}
+	return emitter.File(g.code), nil
+}
+
+// indentLines indents every non-empty line by prefix.
+func indentLines(lines []string, prefix string) []string {
+	indented := make([]string, len(lines))
+	for i, line := range lines {
+		if line != "" {
+			line = prefix + line
+		}
+		indented[i] = line
+	}
+	return indented
+}
+
+type generator struct {
+	emitter  Emitter
+	code     *Code
+	assigned map[string]bool
+}
+
+func (g *generator) line(depth int, line string) {
+	if line != "" {
+		g.code.Lines = append(g.code.Lines, strings.Repeat(g.emitter.Indent(), depth)+line)
+	}
+}
+
+func (g *generator) assign(depth int, name string, value string) {
+	if !g.assigned[name] {
+		g.assigned[name] = true
+		g.code.Variables = append(g.code.Variables, name)
+	}
+	g.line(depth, g.emitter.Assign(name, value))
+}
+
+// binaryOperators are the operators of the arithmetic nodes.
+var binaryOperators = map[string]string{
+	TypeAddition:       "+",
+	TypeSubtraction:    "-",
+	TypeMultiplication: "*",
+	TypeDivision:       "/",
+}
+
+// comparisonOperators are the operators a comparation node may hold.
+var comparisonOperators = map[string]bool{"==": true, "!=": true, "<": true, ">": true, "<=": true, ">=": true}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// constantPattern matches the names of the values of the nodes, which the
+// variables can't take.
+var constantPattern = regexp.MustCompile(`^constant_[0-9]+$`)
+
+// validName rejects "_" too: Go can't read it back and C keeps it for
+// the compiler.
+func validName(name string) bool {
+	return identifierPattern.MatchString(name) && !constantPattern.MatchString(name) && name != "_"
+}
+
+// variableName checks the name of a variable or assign node.
+func (g *generator) variableName(t *Tree) (string, error) {
+	if !validName(t.Name) || g.emitter.Reserved(t.Name) {
+		return "", fmt.Errorf("%w: %s node %d has an invalid variable name %q", ErrInvalidGraph, t.Type, t.ID, t.Name)
+	}
+	return t.Name, nil
+}
+
+// operand is the name holding the value of t.
+func operand(t *Tree) string {
+	if t.Type == TypeVariable || t.Type == TypeAssign {
+		return t.Name
+	}
+	return fmt.Sprintf("constant_%d", t.ID)
+}
+
+// operands checks that t has two inputs connected and returns them.
+func operands(t *Tree) (*Tree, *Tree, error) {
+	if len(t.Children) != 2 {
+		return nil, nil, fmt.Errorf("%w: %s node %d needs 2 inputs connected, it has %d", ErrInvalidGraph, t.Type, t.ID, len(t.Children))
+	}
+	return t.Children[0], t.Children[1], nil
+}
+
+func comparisonOperator(t *Tree) (string, error) {
+	if !comparisonOperators[t.Operator] {
+		return "", fmt.Errorf("%w: comparation node %d has an invalid operator %q", ErrInvalidGraph, t.ID, t.Operator)
+	}
+	return t.Operator, nil
+}
+
+// statement writes the code of t at the given indentation.
+func (g *generator) statement(t *Tree, depth int) error {
+	e := g.emitter
+	switch t.Type {
+	case TypeNumber:
+		g.assign(depth, operand(t), e.Number(t.Number()))
+
+	case TypeVariable:
+		name, err := g.variableName(t)
+		if err != nil {
+			return err
+		}
+		// Without an input the node reads the variable, nothing to write
+		switch len(t.Children) {
+		case 0:
+		case 1:
+			if err := g.statement(t.Children[0], depth); err != nil {
+				return err
+			}
+			g.assign(depth, name, operand(t.Children[0]))
+		default:
+			g.assign(depth, name, e.Number("0"))
+		}
+
+	case TypeAddition, TypeSubtraction, TypeMultiplication, TypeDivision:
+		left, right, err := g.operands(t, depth)
+		if err != nil {
+			return err
+		}
+		g.assign(depth, operand(t), e.Arithmetic(left, binaryOperators[t.Type], right))
+
+	case TypeComparation:
+		comparison, err := g.comparison(t, depth)
+		if err != nil {
+			return err
+		}
+		g.code.Booleans = true
+		g.assign(depth, operand(t), e.Boolean(comparison))
+
+	case TypeAssign:
+		name, err := g.variableName(t)
+		if err != nil {
+			return err
+		}
+		if len(t.Children) == 0 {
+			return fmt.Errorf("%w: assign node %d has no input connected", ErrInvalidGraph, t.ID)
+		}
+		if err := g.statement(t.Children[0], depth); err != nil {
+			return err
+		}
+		g.assign(depth, name, operand(t.Children[0]))
+		g.code.Prints = true
+		g.line(depth, e.Print(name))
+
+	case TypeIf, TypeWhile:
+		condition, err := g.condition(t, depth)
+		if err != nil {
+			return err
+		}
+		if t.Type == TypeWhile {
+			g.line(depth, e.While(condition))
+		} else {
+			g.line(depth, e.If(condition))
+		}
+		if err := g.block(t.Child(PortBody), depth+1); err != nil {
+			return err
+		}
+		if t.Type == TypeIf {
+			if otherwise := t.Child(PortElse); otherwise != nil {
+				g.line(depth, e.Else())
+				if err := g.block(otherwise, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		g.line(depth, e.End())
+
+	default:
+		return fmt.Errorf("%w: node %d has unknown type %q", ErrInvalidGraph, t.ID, t.Type)
+	}
+	return nil
+}
+
+// operands writes the code of the two inputs of t and returns their names.
+func (g *generator) operands(t *Tree, depth int) (string, string, error) {
+	left, right, err := operands(t)
+	if err != nil {
+		return "", "", err
+	}
+	if err := g.statement(left, depth); err != nil {
+		return "", "", err
+	}
+	if err := g.statement(right, depth); err != nil {
+		return "", "", err
+	}
+	return operand(left), operand(right), nil
+}
+
+// comparison writes the inputs of a comparation node and returns the
+// comparison.
+func (g *generator) comparison(t *Tree, depth int) (string, error) {
+	operator, err := comparisonOperator(t)
+	if err != nil {
+		return "", err
+	}
+	left, right, err := g.operands(t, depth)
+	if err != nil {
+		return "", err
+	}
+	return g.emitter.Comparison(left, operator, right), nil
+}
+
+// condition writes what the condition of an if or while node needs and
+// returns the condition to test.
+func (g *generator) condition(t *Tree, depth int) (string, error) {
+	condition := t.Child(PortCondition)
+	if condition == nil {
+		return "", fmt.Errorf("%w: %s node %d has no condition connected", ErrInvalidGraph, t.Type, t.ID)
+	}
+	if condition.Type == TypeComparation {
+		return g.comparison(condition, depth)
+	}
+	if err := g.statement(condition, depth); err != nil {
+		return "", err
+	}
+	return g.emitter.Condition(operand(condition)), nil
+}
+
+// block writes the body of an if or while node, or the empty statement.
+func (g *generator) block(t *Tree, depth int) error {
+	written := len(g.code.Lines)
+	if t != nil {
+		if err := g.statement(t, depth); err != nil {
+			return err
+		}
+	}
+	if len(g.code.Lines) == written {
+		g.line(depth, g.emitter.Empty())
+	}
+	return nil
+}
+
+// checkVariables rejects the graphs that read a variable nothing assigns:
+// Python would fail when it gets there, and Go and C would not compile.
+func checkVariables(trees []*Tree) error {
+	assigned := map[string]bool{}
+	reads := map[string]int{}
+	var walk func(t *Tree, read bool)
+	walk = func(t *Tree, read bool) {
+		switch {
+		case t.Type == TypeAssign || (t.Type == TypeVariable && len(t.Children) > 0):
+			assigned[t.Name] = true
+		case t.Type == TypeVariable && read:
+			if _, ok := reads[t.Name]; !ok {
+				reads[t.Name] = t.ID
+			}
+		}
+		for _, child := range t.Children {
+			// The body of an if or while node is a statement, not a value
+			walk(child, !((t.Type == TypeIf || t.Type == TypeWhile) && child.Port != PortCondition))
+		}
+	}
+	for _, tree := range trees {
+		walk(tree, false)
+	}
+
+	var names []string
+	for name := range reads {
+		if !assigned[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%w: variable %q is read by node %d but never assigned", ErrInvalidGraph, names[0], reads[names[0]])
+}
+
+// reserved builds the set of names a variable cannot take in a language.
+func reserved(names string) map[string]bool {
+	set := map[string]bool{}
+	for _, name := range strings.Fields(names) {
+		set[name] = true
+	}
+	return set
+}
@@ -0,0 +1,333 @@
+package program
+
+import (
+	"errors"
+	"go/format"
+	"strings"
+	"testing"
+)
+
+// graph builds the nodes of a test program.
+type graph struct {
+	nodes []*Node
+	by_id map[int]*Node
+}
+
+func newGraph() *graph {
+	return &graph{by_id: map[int]*Node{}}
+}
+
+func (g *graph) add(node *Node) *graph {
+	g.nodes = append(g.nodes, node)
+	g.by_id[node.ID] = node
+	return g
+}
+
+func (g *graph) number(id int, value string) *graph {
+	return g.add(&Node{ID: id, Type: TypeNumber, Value: value})
+}
+
+func (g *graph) variable(id int, name string) *graph {
+	return g.add(&Node{ID: id, Type: TypeVariable, Name: name})
+}
+
+func (g *graph) assign(id int, name string) *graph {
+	return g.add(&Node{ID: id, Type: TypeAssign, Name: name})
+}
+
+func (g *graph) node(id int, typ string, operator string) *graph {
+	return g.add(&Node{ID: id, Type: typ, Operator: operator})
+}
+
+// connect links output_1 of from to the given input of to, as the editor
+// does on both ends.
+func (g *graph) connect(from int, to int, input string) *graph {
+	src, dst := g.by_id[from], g.by_id[to]
+	src.Outputs = append(src.Outputs, Port{Name: "output_1", Links: []Link{{Node: to, Port: input}}})
+	dst.Inputs = append(dst.Inputs, Port{Name: input, Links: []Link{{Node: from, Port: "output_1"}}})
+	return g
+}
+
+// countdown is
+//
+//	x = 3
+//	while x > 0:
+//	    x = x - 1
+func countdown() []*Node {
+	return newGraph().
+		number(1, "3").assign(2, "x").connect(1, 2, "input_1").
+		variable(3, "x").number(4, "0").node(5, TypeComparation, ">").
+		connect(3, 5, "input_1").connect(4, 5, "input_2").
+		variable(6, "x").number(7, "1").node(8, TypeSubtraction, "").
+		connect(6, 8, "input_1").connect(7, 8, "input_2").
+		assign(9, "x").connect(8, 9, "input_1").
+		node(10, TypeWhile, "").connect(5, 10, PortCondition).connect(9, 10, PortBody).
+		nodes
+}
+
+// branch is
+//
+//	a = 2
+//	if a:
+//	    b = a / 4
+//	else:
+//	    b = 0
+func branch() []*Node {
+	return newGraph().
+		number(1, "2").assign(2, "a").connect(1, 2, "input_1").
+		variable(3, "a").
+		variable(4, "a").number(5, "4").node(6, TypeDivision, "").
+		connect(4, 6, "input_1").connect(5, 6, "input_2").
+		assign(7, "b").connect(6, 7, "input_1").
+		number(8, "0").assign(9, "b").connect(8, 9, "input_1").
+		node(10, TypeIf, "").connect(3, 10, PortCondition).connect(7, 10, PortBody).connect(9, 10, PortElse).
+		nodes
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		lang  string
+		nodes []*Node
+		want  string
+	}{
+		{"python", countdown(), `constant_1 = 3
+x = constant_1
+print(x)
+
+constant_4 = 0
+while x > constant_4:
+    constant_7 = 1
+    constant_8 = x - constant_7
+    x = constant_8
+    print(x)
+`},
+		{"python", branch(), `constant_1 = 2
+a = constant_1
+print(a)
+
+if a:
+    constant_5 = 4
+    constant_6 = a / constant_5
+    b = constant_6
+    print(b)
+else:
+    constant_8 = 0
+    b = constant_8
+    print(b)
+`},
+		{"python", nil, ""},
+		{"javascript", countdown(), `let constant_1, x, constant_4, constant_7, constant_8;
+
+constant_1 = 3;
+x = constant_1;
+console.log(x);
+
+constant_4 = 0;
+while (x > constant_4) {
+  constant_7 = 1;
+  constant_8 = x - constant_7;
+  x = constant_8;
+  console.log(x);
+}
+`},
+		{"go", countdown(), `package main
+
+import "fmt"
+
+var constant_1, x, constant_4, constant_7, constant_8 float64
+
+func main() {
+	constant_1 = 3
+	x = constant_1
+	fmt.Println(x)
+
+	constant_4 = 0
+	for x > constant_4 {
+		constant_7 = 1
+		constant_8 = x - constant_7
+		x = constant_8
+		fmt.Println(x)
+	}
+}
+`},
+		{"go", branch(), `package main
+
+import "fmt"
+
+var constant_1, a, constant_5, constant_6, b, constant_8 float64
+
+func main() {
+	constant_1 = 2
+	a = constant_1
+	fmt.Println(a)
+
+	if a != 0 {
+		constant_5 = 4
+		constant_6 = a / constant_5
+		b = constant_6
+		fmt.Println(b)
+	} else {
+		constant_8 = 0
+		b = constant_8
+		fmt.Println(b)
+	}
+}
+`},
+		{"c", countdown(), `#include <stdio.h>
+
+double constant_1, x, constant_4, constant_7, constant_8;
+
+int main(void) {
+    constant_1 = 3.0;
+    x = constant_1;
+    printf("%g\n", x);
+
+    constant_4 = 0.0;
+    while (x > constant_4) {
+        constant_7 = 1.0;
+        constant_8 = x - constant_7;
+        x = constant_8;
+        printf("%g\n", x);
+    }
+    return 0;
+}
+`},
+	}
+	for _, tt := range tests {
+		got, err := Generate(tt.lang, tt.nodes)
+		if err != nil {
+			t.Errorf("Generate(%s): %v", tt.lang, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Generate(%s) =\n%s\nwant\n%s", tt.lang, got, tt.want)
+		}
+	}
+}
+
+// The Go programs parse and are gofmt'd.
+func TestGenerateGoFormat(t *testing.T) {
+	for name, nodes := range map[string][]*Node{"countdown": countdown(), "branch": branch(), "empty": nil} {
+		code, err := Generate("go", nodes)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		formatted, err := format.Source([]byte(code))
+		if err != nil {
+			t.Errorf("%s: %v\n%s", name, err, code)
+		} else if string(formatted) != code {
+			t.Errorf("%s is not gofmt'd:\n%s", name, code)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	// unassigned reads y in "x = y"
+	unassigned := newGraph().variable(1, "y").assign(2, "x").connect(1, 2, "input_1").nodes
+	// named assigns the result of a number to a variable called name
+	named := func(name string) []*Node {
+		return newGraph().number(1, "1").assign(2, name).connect(1, 2, "input_1").nodes
+	}
+	cycle := newGraph().node(1, TypeAddition, "").node(2, TypeAddition, "").assign(3, "x").
+		connect(1, 2, "input_1").connect(2, 1, "input_1").connect(2, 3, "input_1").nodes
+	unknown := newGraph().number(1, "1").node(2, "sqrt", "").connect(1, 2, "input_1").nodes
+	operator := newGraph().number(1, "1").number(2, "2").node(3, TypeComparation, "=").
+		connect(1, 3, "input_1").connect(2, 3, "input_2").nodes
+	one_operand := newGraph().number(1, "1").node(2, TypeAddition, "").connect(1, 2, "input_1").nodes
+
+	tests := []struct {
+		name  string
+		langs []string
+		nodes []*Node
+		want  string
+	}{
+		{"unassigned variable", Languages(), unassigned, `variable "y" is read by node 1 but never assigned`},
+		{"constant name", Languages(), named("constant_1"), `invalid variable name "constant_1"`},
+		{"not an identifier", Languages(), named("2x"), `invalid variable name "2x"`},
+		{"python keyword", []string{"python"}, named("print"), `invalid variable name "print"`},
+		{"javascript keyword", []string{"javascript"}, named("console"), `invalid variable name "console"`},
+		{"go keyword", []string{"go"}, named("fmt"), `invalid variable name "fmt"`},
+		{"c keyword", []string{"c"}, named("printf"), `invalid variable name "printf"`},
+		{"blank identifier", Languages(), named("_"), `invalid variable name "_"`},
+		{"go init", []string{"go"}, named("init"), `invalid variable name "init"`},
+		{"c library function", []string{"c"}, named("remove"), `invalid variable name "remove"`},
+		{"c predefined macro", []string{"c"}, named("linux"), `invalid variable name "linux"`},
+		{"c reserved identifier", []string{"c"}, named("_x"), `invalid variable name "_x"`},
+		{"cycle", Languages(), cycle, "is part of a cycle"},
+		{"unknown type", Languages(), unknown, `unknown type "sqrt"`},
+		{"comparison operator", Languages(), operator, `invalid operator "="`},
+		{"missing operand", Languages(), one_operand, "needs 2 inputs connected, it has 1"},
+	}
+	for _, tt := range tests {
+		for _, lang := range tt.langs {
+			_, err := Generate(lang, tt.nodes)
+			if !errors.Is(err, ErrInvalidGraph) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: Generate(%s) error = %v, want %q", tt.name, lang, err, tt.want)
+			}
+		}
+	}
+
+	// Names reserved by one language are fine in the others
+	for _, tt := range []struct{ lang, name string }{{"python", "init"}, {"go", "remove"}, {"c", "print"}, {"javascript", "_x"}} {
+		if _, err := Generate(tt.lang, named(tt.name)); err != nil {
+			t.Errorf("Generate(%s) of a variable %q: %v", tt.lang, tt.name, err)
+		}
+	}
+
+	if _, err := Generate("cobol", countdown()); err == nil {
+		t.Error("Generate(cobol) succeeded, want unknown language")
+	}
+}
+
+func TestCheckVariables(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []*Node
+		ok    bool
+	}{
+		{"assigned before", countdown(), true},
+		{"assigned by a variable node", newGraph().
+			number(1, "5").variable(2, "x").connect(1, 2, "input_1").
+			variable(3, "x").assign(4, "y").connect(3, 4, "input_1").nodes, true},
+		{"read as a condition", newGraph().
+			variable(1, "x").number(2, "1").assign(3, "y").connect(2, 3, "input_1").
+			node(4, TypeIf, "").connect(1, 4, PortCondition).connect(3, 4, PortBody).nodes, false},
+		// A variable node alone in a body reads nothing
+		{"body of an if", newGraph().
+			number(1, "1").assign(2, "c").connect(1, 2, "input_1").
+			variable(3, "c").variable(4, "x").
+			node(5, TypeIf, "").connect(3, 5, PortCondition).connect(4, 5, PortBody).nodes, true},
+	}
+	for _, tt := range tests {
+		trees, err := Build(tt.nodes)
+		if err != nil {
+			t.Fatalf("%s: Build: %v", tt.name, err)
+		}
+		if err := checkVariables(trees); (err == nil) != tt.ok {
+			t.Errorf("%s: checkVariables = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"42", "42"},
+		{" 007 ", "7"},
+		{"-3", "-3"},
+		{"+3", "3"},
+		{"3.5", "3"},
+		{"12abc", "12"},
+		{"abc", "0"},
+		{"", "0"},
+		{"-", "0"},
+		{"123456789012345678901234567890", "123456789012345678901234567890"},
+	}
+	for _, tt := range tests {
+		if got := (&Node{Value: tt.value}).Number(); got != tt.want {
+			t.Errorf("Number(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package program
+
+import "strings"
+
+func init() {
+	Register("go", goEmitter{})
+}
+
+// goEmitter writes a Go program. The variables are float64 and declared at
+// package level, so the ones never read don't stop it from compiling.
+type goEmitter struct{}
+
+// goReserved are the keywords, the predeclared identifiers and the names
+// the program uses itself.
+var goReserved = reserved(`break case chan const continue default defer else fallthrough
+	for func go goto if import interface map package range return select struct switch
+	type var bool byte complex64 complex128 error float32 float64 int int8 int16 int32
+	int64 rune string uint uint8 uint16 uint32 uint64 uintptr true false iota nil append
+	cap close complex copy delete imag len make new panic print println real recover
+	fmt main init boolean`)
+
+func (goEmitter) Indent() string                   { return "\t" }
+func (goEmitter) Reserved(name string) bool        { return goReserved[name] }
+func (goEmitter) Number(digits string) string      { return digits }
+func (goEmitter) Boolean(comparison string) string { return "boolean(" + comparison + ")" }
+func (goEmitter) Condition(value string) string    { return value + " != 0" }
+func (goEmitter) Print(name string) string         { return "fmt.Println(" + name + ")" }
+func (goEmitter) If(condition string) string       { return "if " + condition + " {" }
+func (goEmitter) Else() string                     { return "} else {" }
+func (goEmitter) While(condition string) string    { return "for " + condition + " {" }
+func (goEmitter) End() string                      { return "}" }
+func (goEmitter) Empty() string                    { return "" }
+
+func (goEmitter) Arithmetic(left string, operator string, right string) string {
+	return left + " " + operator + " " + right
+}
+
+func (goEmitter) Comparison(left string, operator string, right string) string {
+	return left + " " + operator + " " + right
+}
+
+func (goEmitter) Assign(name string, value string) string {
+	return name + " = " + value
+}
+
+func (e goEmitter) File(code *Code) string {
+	var b strings.Builder
+	b.WriteString("package main\n\n")
+	if code.Prints {
+		b.WriteString("import \"fmt\"\n\n")
+	}
+	if len(code.Variables) > 0 {
+		b.WriteString("var " + strings.Join(code.Variables, ", ") + " float64\n\n")
+	}
+	if code.Booleans {
+		b.WriteString("// boolean is 1 for true and 0 for false, like in Python\n")
+		b.WriteString("func boolean(b bool) float64 {\n\tif b {\n\t\treturn 1\n\t}\n\treturn 0\n}\n\n")
+	}
+	b.WriteString("func main() {\n")
+	for _, line := range indentLines(code.Lines, e.Indent()) {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
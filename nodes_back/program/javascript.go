@@ -0,0 +1,63 @@
+package program
+
+import "strings"
+
+func init() {
+	Register("javascript", javascriptEmitter{})
+}
+
+// javascriptEmitter writes a script for Node.js or the browser console.
+type javascriptEmitter struct{}
+
+// javascriptReserved are the keywords, the literals and console, which the
+// assign nodes call.
+var javascriptReserved = reserved(`break case catch class const continue debugger default
+	delete do else enum export extends false finally for function if import in instanceof
+	let new null return super switch this throw true try typeof var void while with yield
+	await implements interface package private protected public static arguments eval
+	undefined NaN Infinity console`)
+
+func (javascriptEmitter) Indent() string                   { return "  " }
+func (javascriptEmitter) Reserved(name string) bool        { return javascriptReserved[name] }
+func (javascriptEmitter) Number(digits string) string      { return digits }
+func (javascriptEmitter) Boolean(comparison string) string { return comparison }
+func (javascriptEmitter) Condition(value string) string    { return value }
+func (javascriptEmitter) Print(name string) string         { return "console.log(" + name + ");" }
+func (javascriptEmitter) If(condition string) string       { return "if (" + condition + ") {" }
+func (javascriptEmitter) Else() string                     { return "} else {" }
+func (javascriptEmitter) While(condition string) string    { return "while (" + condition + ") {" }
+func (javascriptEmitter) End() string                      { return "}" }
+func (javascriptEmitter) Empty() string                    { return "" }
+
+func (javascriptEmitter) Arithmetic(left string, operator string, right string) string {
+	return left + " " + operator + " " + right
+}
+
+// Comparison uses the strict operators; the values are always numbers.
+func (javascriptEmitter) Comparison(left string, operator string, right string) string {
+	switch operator {
+	case "==":
+		operator = "==="
+	case "!=":
+		operator = "!=="
+	}
+	return left + " " + operator + " " + right
+}
+
+func (javascriptEmitter) Assign(name string, value string) string {
+	return name + " = " + value + ";"
+}
+
+func (javascriptEmitter) File(code *Code) string {
+	var b strings.Builder
+	if len(code.Variables) > 0 {
+		b.WriteString("let " + strings.Join(code.Variables, ", ") + ";\n")
+		if len(code.Lines) > 0 {
+			b.WriteString("\n")
+		}
+	}
+	for _, line := range code.Lines {
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,526 @@
+package program
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// The interpreter runs the program Generate writes, without writing it:
+// Compile turns the trees into a list of instructions, each coming from
+// one node, and a Machine executes them one at a time.
+
+type opcode int
+
+const (
+	opSet        opcode = iota // dst = value
+	opCopy                     // dst = a
+	opArithmetic               // dst = a operator b
+	opCompare                  // dst = a operator b, a bool
+	opPrint                    // print(a)
+	opJumpUnless               // if not (a operator b), or not a without operator: goto target
+	opJump                     // goto target
+)
+
+// ref names where a value is kept: a variable, or the "constant_<id>" of
+// a node when name is empty.
+type ref struct {
+	name string
+	node int
+}
+
+func (r ref) String() string {
+	if r.name != "" {
+		return r.name
+	}
+	return fmt.Sprintf("constant_%d", r.node)
+}
+
+type instr struct {
+	op       opcode
+	node     int
+	dst      ref
+	a, b     ref
+	operator string
+	value    Value
+	target   int
+}
+
+// Limits keep a program from running forever or filling the memory of the
+// server. A zero field means no limit.
+type Limits struct {
+	// Steps is the number of instructions a machine may execute.
+	Steps int
+	// Timeout is how long one call to Run may take.
+	Timeout time.Duration
+	// ValueBits is the size of the largest integer a program may hold; with
+	// the number of variables, fixed by the graph, it bounds the memory.
+	ValueBits int
+	// Output is the number of bytes a program may print.
+	Output int
+}
+
+// Names of the limits in LimitError and Result.
+const (
+	LimitSteps     = "steps"
+	LimitTime      = "time"
+	LimitValueSize = "value_size"
+	LimitOutput    = "output"
+	// LimitCanceled is not a limit: the context of Run was canceled, e.g.
+	// because the client went away.
+	LimitCanceled = "canceled"
+)
+
+// LimitError is the error of a program stopped by its Limits.
+type LimitError struct {
+	Limit   string
+	Message string
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+// Compile checks the graph and prepares a machine to run it within limits.
+func Compile(nodes []*Node, limits Limits) (*Machine, error) {
+	trees, err := Build(nodes)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVariables(trees); err != nil {
+		return nil, err
+	}
+	c := &compiler{}
+	for _, tree := range trees {
+		if err := c.statement(tree); err != nil {
+			return nil, err
+		}
+	}
+	return &Machine{code: c.code, limits: limits, vars: map[string]Value{}, consts: map[int]Value{}}, nil
+}
+
+type compiler struct {
+	code []instr
+}
+
+func (c *compiler) emit(i instr) int {
+	c.code = append(c.code, i)
+	return len(c.code) - 1
+}
+
+// operandRef is where the value of t is kept, see operand.
+func operandRef(t *Tree) ref {
+	if t.Type == TypeVariable || t.Type == TypeAssign {
+		return ref{name: t.Name}
+	}
+	return ref{node: t.ID}
+}
+
+// checkName checks the name of a variable or assign node as the Python
+// generator does.
+func checkName(t *Tree) error {
+	if !validName(t.Name) || pythonReserved[t.Name] {
+		return fmt.Errorf("%w: %s node %d has an invalid variable name %q", ErrInvalidGraph, t.Type, t.ID, t.Name)
+	}
+	return nil
+}
+
+// statement compiles t the way generator.statement writes it.
+func (c *compiler) statement(t *Tree) error {
+	switch t.Type {
+	case TypeNumber:
+		c.emit(instr{op: opSet, node: t.ID, dst: operandRef(t), value: numberValue(t.Number())})
+
+	case TypeVariable:
+		if err := checkName(t); err != nil {
+			return err
+		}
+		switch len(t.Children) {
+		case 0:
+		case 1:
+			if err := c.statement(t.Children[0]); err != nil {
+				return err
+			}
+			c.emit(instr{op: opCopy, node: t.ID, dst: operandRef(t), a: operandRef(t.Children[0])})
+		default:
+			c.emit(instr{op: opSet, node: t.ID, dst: operandRef(t), value: numberValue("0")})
+		}
+
+	case TypeAddition, TypeSubtraction, TypeMultiplication, TypeDivision:
+		left, right, err := c.operands(t)
+		if err != nil {
+			return err
+		}
+		c.emit(instr{op: opArithmetic, node: t.ID, dst: operandRef(t), a: left, b: right, operator: binaryOperators[t.Type]})
+
+	case TypeComparation:
+		operator, err := comparisonOperator(t)
+		if err != nil {
+			return err
+		}
+		left, right, err := c.operands(t)
+		if err != nil {
+			return err
+		}
+		c.emit(instr{op: opCompare, node: t.ID, dst: operandRef(t), a: left, b: right, operator: operator})
+
+	case TypeAssign:
+		if err := checkName(t); err != nil {
+			return err
+		}
+		if len(t.Children) == 0 {
+			return fmt.Errorf("%w: assign node %d has no input connected", ErrInvalidGraph, t.ID)
+		}
+		if err := c.statement(t.Children[0]); err != nil {
+			return err
+		}
+		c.emit(instr{op: opCopy, node: t.ID, dst: operandRef(t), a: operandRef(t.Children[0])})
+		c.emit(instr{op: opPrint, node: t.ID, a: operandRef(t)})
+
+	case TypeIf:
+		test, err := c.condition(t)
+		if err != nil {
+			return err
+		}
+		jump_else := c.emit(test)
+		if body := t.Child(PortBody); body != nil {
+			if err := c.statement(body); err != nil {
+				return err
+			}
+		}
+		if otherwise := t.Child(PortElse); otherwise != nil {
+			jump_end := c.emit(instr{op: opJump, node: t.ID})
+			c.code[jump_else].target = len(c.code)
+			if err := c.statement(otherwise); err != nil {
+				return err
+			}
+			c.code[jump_end].target = len(c.code)
+		} else {
+			c.code[jump_else].target = len(c.code)
+		}
+
+	case TypeWhile:
+		test, err := c.condition(t)
+		if err != nil {
+			return err
+		}
+		loop := c.emit(test)
+		if body := t.Child(PortBody); body != nil {
+			if err := c.statement(body); err != nil {
+				return err
+			}
+		}
+		c.emit(instr{op: opJump, node: t.ID, target: loop})
+		c.code[loop].target = len(c.code)
+
+	default:
+		return fmt.Errorf("%w: node %d has unknown type %q", ErrInvalidGraph, t.ID, t.Type)
+	}
+	return nil
+}
+
+// operands compiles the two inputs of t and returns their refs.
+func (c *compiler) operands(t *Tree) (ref, ref, error) {
+	left, right, err := operands(t)
+	if err != nil {
+		return ref{}, ref{}, err
+	}
+	if err := c.statement(left); err != nil {
+		return ref{}, ref{}, err
+	}
+	if err := c.statement(right); err != nil {
+		return ref{}, ref{}, err
+	}
+	return operandRef(left), operandRef(right), nil
+}
+
+// condition compiles what the condition of an if or while node needs and
+// returns the test that jumps out when it is false; the caller sets the
+// target.
+func (c *compiler) condition(t *Tree) (instr, error) {
+	condition := t.Child(PortCondition)
+	if condition == nil {
+		return instr{}, fmt.Errorf("%w: %s node %d has no condition connected", ErrInvalidGraph, t.Type, t.ID)
+	}
+	if condition.Type == TypeComparation {
+		operator, err := comparisonOperator(condition)
+		if err != nil {
+			return instr{}, err
+		}
+		left, right, err := c.operands(condition)
+		if err != nil {
+			return instr{}, err
+		}
+		return instr{op: opJumpUnless, node: t.ID, a: left, b: right, operator: operator}, nil
+	}
+	if err := c.statement(condition); err != nil {
+		return instr{}, err
+	}
+	return instr{op: opJumpUnless, node: t.ID, a: operandRef(condition)}, nil
+}
+
+// Machine runs a compiled program.
+type Machine struct {
+	code   []instr
+	limits Limits
+	pc     int
+	vars   map[string]Value
+	consts map[int]Value
+	output []string
+	// printed is the size of output in bytes
+	printed int
+	steps   int
+	err     *RuntimeError
+}
+
+// RuntimeError is an error raised by the program, like the exception the
+// Python code would raise.
+type RuntimeError struct {
+	Node int
+	Err  error
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("node %d: %v", e.Node, e.Err)
+}
+
+// Done tells whether the program has ended, normally or with an error.
+func (m *Machine) Done() bool {
+	return m.err != nil || m.pc >= len(m.code)
+}
+
+func (m *Machine) load(r ref) (Value, error) {
+	var v Value
+	var ok bool
+	if r.name != "" {
+		v, ok = m.vars[r.name]
+	} else {
+		v, ok = m.consts[r.node]
+	}
+	if !ok {
+		return Value{}, fmt.Errorf("NameError: name '%s' is not defined", r)
+	}
+	return v, nil
+}
+
+func (m *Machine) store(r ref, v Value) error {
+	if max := m.limits.ValueBits; max > 0 && v.kind == kindInt && v.i.BitLen() > max {
+		return &LimitError{LimitValueSize, fmt.Sprintf("value size limit exceeded: %s needs more than %d bits", r, max)}
+	}
+	if r.name != "" {
+		m.vars[r.name] = v
+	} else {
+		m.consts[r.node] = v
+	}
+	return nil
+}
+
+// Node returns the node whose code runs next, 0 when the machine is done.
+func (m *Machine) Node() int {
+	if m.Done() {
+		return 0
+	}
+	return m.code[m.pc].node
+}
+
+// Nodes returns the ids of the nodes that run code, sorted. The others,
+// like a variable node that is only read or the comparation node tested by
+// an if or while node, never become the current node.
+func (m *Machine) Nodes() []int {
+	seen := map[int]bool{}
+	var nodes []int
+	for _, i := range m.code {
+		if !seen[i.node] {
+			seen[i.node] = true
+			nodes = append(nodes, i.node)
+		}
+	}
+	sort.Ints(nodes)
+	return nodes
+}
+
+// step executes the next instruction. It returns the error the program
+// raised, if any; the machine is then done.
+func (m *Machine) step() error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.pc >= len(m.code) {
+		return nil
+	}
+	i := m.code[m.pc]
+	if max := m.limits.Steps; max > 0 && m.steps >= max {
+		m.err = &RuntimeError{Node: i.node, Err: &LimitError{LimitSteps, fmt.Sprintf("step limit exceeded: more than %d steps", max)}}
+		return m.err
+	}
+	m.pc++
+	m.steps++
+	if err := m.execute(i); err != nil {
+		m.err = &RuntimeError{Node: i.node, Err: err}
+		return m.err
+	}
+	return nil
+}
+
+func (m *Machine) execute(i instr) error {
+	switch i.op {
+	case opSet:
+		return m.store(i.dst, i.value)
+
+	case opCopy:
+		v, err := m.load(i.a)
+		if err != nil {
+			return err
+		}
+		return m.store(i.dst, v)
+
+	case opArithmetic, opCompare:
+		a, err := m.load(i.a)
+		if err != nil {
+			return err
+		}
+		b, err := m.load(i.b)
+		if err != nil {
+			return err
+		}
+		if i.op == opCompare {
+			return m.store(i.dst, boolValue(compare(i.operator, a, b)))
+		}
+		v, err := arithmetic(i.operator, a, b)
+		if err != nil {
+			return err
+		}
+		return m.store(i.dst, v)
+
+	case opPrint:
+		v, err := m.load(i.a)
+		if err != nil {
+			return err
+		}
+		line := v.String()
+		if max := m.limits.Output; max > 0 && m.printed+len(line)+1 > max {
+			return &LimitError{LimitOutput, fmt.Sprintf("output limit exceeded: more than %d bytes printed", max)}
+		}
+		m.printed += len(line) + 1
+		m.output = append(m.output, line)
+
+	case opJumpUnless:
+		a, err := m.load(i.a)
+		if err != nil {
+			return err
+		}
+		ok := a.truth()
+		if i.operator != "" {
+			b, err := m.load(i.b)
+			if err != nil {
+				return err
+			}
+			ok = compare(i.operator, a, b)
+		}
+		if !ok {
+			m.pc = i.target
+		}
+
+	case opJump:
+		m.pc = i.target
+	}
+	return nil
+}
+
+// Result is what a run leaves: the printed lines, the variables and the
+// error that stopped it, if any.
+type Result struct {
+	Output    []string         `json:"output"`
+	Variables map[string]Value `json:"variables"`
+	Steps     int              `json:"steps"`
+	Error     string           `json:"error,omitempty"`
+	// Limit is set when Error is a LimitError.
+	Limit string `json:"limit,omitempty"`
+	// Node is the node that raised Error.
+	Node int `json:"node,omitempty"`
+}
+
+// Result returns the state of the machine.
+func (m *Machine) Result() *Result {
+	r := &Result{
+		Output:    append([]string{}, m.output...),
+		Variables: make(map[string]Value, len(m.vars)),
+		Steps:     m.steps,
+	}
+	for name, v := range m.vars {
+		r.Variables[name] = v
+	}
+	if m.err != nil {
+		r.Error = m.err.Err.Error()
+		r.Node = m.err.Node
+		var limit *LimitError
+		if errors.As(m.err.Err, &limit) {
+			r.Limit = limit.Limit
+		}
+	}
+	return r
+}
+
+// checkEvery is how many steps Run executes between looks at its context.
+const checkEvery = 1024
+
+// Run executes the program to its end, or until a limit stops it or ctx is
+// done.
+func (m *Machine) Run(ctx context.Context) *Result {
+	return m.run(ctx, nil)
+}
+
+// Step executes the code of the current node, within the limits like Run.
+func (m *Machine) Step(ctx context.Context) *Result {
+	node := m.Node()
+	return m.run(ctx, func() bool { return m.Node() != node })
+}
+
+// Continue runs until the code of a node in breakpoints is about to run,
+// not counting the current node, or like Run. The code of a while node
+// runs when the loop starts and again after every pass of the body, so a
+// breakpoint on it stops once per iteration.
+func (m *Machine) Continue(ctx context.Context, breakpoints map[int]bool) *Result {
+	last := m.Node()
+	return m.run(ctx, func() bool {
+		node := m.Node()
+		if node == last {
+			return false
+		}
+		last = node
+		return breakpoints[node]
+	})
+}
+
+// run executes instructions until the machine is done or stop, called
+// after each one, says so.
+func (m *Machine) run(ctx context.Context, stop func() bool) *Result {
+	if m.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.limits.Timeout)
+		defer cancel()
+	}
+	for n := 0; !m.Done(); n++ {
+		if n%checkEvery == 0 && ctx.Err() != nil {
+			m.err = &RuntimeError{Node: m.code[m.pc].node, Err: interrupted(ctx.Err(), m.limits.Timeout)}
+			break
+		}
+		m.step()
+		if stop != nil && stop() {
+			break
+		}
+	}
+	return m.Result()
+}
+
+func interrupted(err error, timeout time.Duration) *LimitError {
+	if errors.Is(err, context.DeadlineExceeded) {
+		if timeout > 0 {
+			return &LimitError{LimitTime, fmt.Sprintf("time limit exceeded: running for more than %v", timeout)}
+		}
+		return &LimitError{LimitTime, "time limit exceeded: the request timed out"}
+	}
+	return &LimitError{LimitCanceled, "interrupted: " + err.Error()}
+}
@@ -0,0 +1,214 @@
+package program
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// doubling is
+//
+//	x = 1
+//	while 1:
+//	    x = x * 2
+func doubling() []*Node {
+	return newGraph().
+		number(1, "1").assign(2, "x").connect(1, 2, "input_1").
+		number(3, "1").
+		variable(4, "x").number(5, "2").node(6, TypeMultiplication, "").
+		connect(4, 6, "input_1").connect(5, 6, "input_2").
+		assign(8, "x").connect(6, 8, "input_1").
+		node(7, TypeWhile, "").connect(3, 7, PortCondition).connect(8, 7, PortBody).
+		nodes
+}
+
+// spin loops forever without printing nor growing a value:
+//
+//	while 1:
+//	    y = 1
+func spin() []*Node {
+	return newGraph().
+		number(1, "1").number(2, "1").variable(3, "y").connect(2, 3, "input_1").
+		node(4, TypeWhile, "").connect(1, 4, PortCondition).connect(3, 4, PortBody).
+		nodes
+}
+
+// variables returns the variables of r as their Python repr.
+func variables(r *Result) map[string]string {
+	vars := map[string]string{}
+	for name, v := range r.Variables {
+		vars[name] = v.String()
+	}
+	return vars
+}
+
+func TestRun(t *testing.T) {
+	divide := newGraph().number(1, "1").number(2, "0").node(3, TypeDivision, "").
+		connect(1, 3, "input_1").connect(2, 3, "input_2").
+		assign(4, "x").connect(3, 4, "input_1").nodes
+	compare := newGraph().number(1, "7").number(2, "7").node(3, TypeComparation, "==").
+		connect(1, 3, "input_1").connect(2, 3, "input_2").
+		assign(4, "same").connect(3, 4, "input_1").nodes
+
+	tests := []struct {
+		name   string
+		nodes  []*Node
+		output []string
+		vars   map[string]string
+		err    string
+		node   int
+	}{
+		{"countdown", countdown(), []string{"3", "2", "1", "0"}, map[string]string{"x": "0"}, "", 0},
+		{"branch", branch(), []string{"2", "0.5"}, map[string]string{"a": "2", "b": "0.5"}, "", 0},
+		{"comparison", compare, []string{"True"}, map[string]string{"same": "True"}, "", 0},
+		{"division by zero", divide, []string{}, map[string]string{}, "ZeroDivisionError: division by zero", 3},
+		{"empty", nil, []string{}, map[string]string{}, "", 0},
+	}
+	for _, tt := range tests {
+		m, err := Compile(tt.nodes, Limits{})
+		if err != nil {
+			t.Errorf("%s: Compile: %v", tt.name, err)
+			continue
+		}
+		r := m.Run(context.Background())
+		if !reflect.DeepEqual(r.Output, tt.output) {
+			t.Errorf("%s: output = %q, want %q", tt.name, r.Output, tt.output)
+		}
+		if got := variables(r); !reflect.DeepEqual(got, tt.vars) {
+			t.Errorf("%s: variables = %v, want %v", tt.name, got, tt.vars)
+		}
+		if r.Error != tt.err || r.Node != tt.node {
+			t.Errorf("%s: error = %q at node %d, want %q at node %d", tt.name, r.Error, r.Node, tt.err, tt.node)
+		}
+		if !m.Done() {
+			t.Errorf("%s: machine not done after Run", tt.name)
+		}
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		nodes  []*Node
+		limits Limits
+		ctx    context.Context
+		limit  string
+	}{
+		{"steps", doubling(), Limits{Steps: 10}, context.Background(), LimitSteps},
+		{"output", doubling(), Limits{Output: 20}, context.Background(), LimitOutput},
+		{"value size", doubling(), Limits{ValueBits: 64}, context.Background(), LimitValueSize},
+		{"time", spin(), Limits{Timeout: 20 * time.Millisecond}, context.Background(), LimitTime},
+		{"canceled", spin(), Limits{}, canceled, LimitCanceled},
+		{"within limits", countdown(), Limits{Steps: 100, Output: 100, ValueBits: 8, Timeout: time.Second}, context.Background(), ""},
+	}
+	for _, tt := range tests {
+		m, err := Compile(tt.nodes, tt.limits)
+		if err != nil {
+			t.Fatalf("%s: Compile: %v", tt.name, err)
+		}
+		r := m.Run(tt.ctx)
+		if r.Limit != tt.limit {
+			t.Errorf("%s: limit = %q (error %q), want %q", tt.name, r.Limit, r.Error, tt.limit)
+		}
+		if tt.limit != "" && (r.Error == "" || r.Node == 0 || !m.Done()) {
+			t.Errorf("%s: error %q at node %d, done %v: want an error at a node", tt.name, r.Error, r.Node, m.Done())
+		}
+	}
+
+	m, _ := Compile(doubling(), Limits{Steps: 10})
+	if r := m.Run(context.Background()); r.Steps != 10 {
+		t.Errorf("steps = %d, want 10", r.Steps)
+	}
+	m, _ = Compile(doubling(), Limits{Output: 20})
+	if r := m.Run(context.Background()); len(strings.Join(r.Output, "\n"))+1 > 20 {
+		t.Errorf("printed %q, more than 20 bytes", r.Output)
+	}
+}
+
+func TestLimitError(t *testing.T) {
+	m, _ := Compile(doubling(), Limits{Steps: 1})
+	m.Run(context.Background())
+	var limit *LimitError
+	if m.err == nil || !errors.As(m.err.Err, &limit) || limit.Limit != LimitSteps {
+		t.Errorf("error %v is not a steps LimitError", m.err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []*Node
+	}{
+		{"unassigned variable", newGraph().variable(1, "y").assign(2, "x").connect(1, 2, "input_1").nodes},
+		{"constant name", newGraph().number(1, "1").assign(2, "constant_2").connect(1, 2, "input_1").nodes},
+		{"reserved name", newGraph().number(1, "1").assign(2, "while").connect(1, 2, "input_1").nodes},
+		{"missing node", newGraph().assign(1, "x").add(&Node{ID: 2, Type: TypeAssign, Name: "y",
+			Inputs: []Port{{Name: "input_1", Links: []Link{{Node: 9, Port: "output_1"}}}}}).nodes},
+		{"no condition", newGraph().number(1, "1").assign(2, "x").connect(1, 2, "input_1").
+			node(3, TypeIf, "").connect(2, 3, PortBody).nodes},
+	}
+	for _, tt := range tests {
+		if _, err := Compile(tt.nodes, Limits{}); !errors.Is(err, ErrInvalidGraph) {
+			t.Errorf("%s: Compile error = %v, want ErrInvalidGraph", tt.name, err)
+		}
+	}
+}
+
+func TestStepContinue(t *testing.T) {
+	m, err := Compile(countdown(), Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if got, want := m.Nodes(), []int{1, 2, 4, 7, 8, 9, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Nodes() = %v, want %v", got, want)
+	}
+
+	steps := []struct {
+		run    func() *Result
+		node   int
+		output []string
+	}{
+		{m.Result, 1, []string{}},
+		{func() *Result { return m.Step(ctx) }, 2, []string{}},
+		{func() *Result { return m.Step(ctx) }, 4, []string{"3"}},
+		{func() *Result { return m.Continue(ctx, map[int]bool{9: true}) }, 9, []string{"3"}},
+		{func() *Result { return m.Continue(ctx, map[int]bool{9: true}) }, 9, []string{"3", "2"}},
+		{func() *Result { return m.Continue(ctx, nil) }, 0, []string{"3", "2", "1", "0"}},
+	}
+	for i, s := range steps {
+		r := s.run()
+		if m.Node() != s.node || !reflect.DeepEqual(r.Output, s.output) {
+			t.Errorf("step %d: at node %d with output %q, want node %d with %q", i, m.Node(), r.Output, s.node, s.output)
+		}
+	}
+	if !m.Done() {
+		t.Error("machine not done after continuing without breakpoints")
+	}
+}
+
+func TestValueString(t *testing.T) {
+	tests := []struct {
+		v    Value
+		want string
+	}{
+		{numberValue("12345678901234567890"), "12345678901234567890"},
+		{floatValue(0.5), "0.5"},
+		{floatValue(2), "2.0"},
+		{floatValue(1e16), "1e+16"},
+		{floatValue(1e-5), "1e-05"},
+		{floatValue(0.0001), "0.0001"},
+		{boolValue(true), "True"},
+	}
+	for _, tt := range tests {
+		if got := tt.v.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
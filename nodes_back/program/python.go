@@ -0,0 +1,47 @@
+package program
+
+import "strings"
+
+func init() {
+	Register("python", pythonEmitter{})
+}
+
+// pythonEmitter writes the program the editor runs with Brython, minus the
+// code that shows the results in the page.
+type pythonEmitter struct{}
+
+// pythonReserved are the keywords and print, which the assign nodes call.
+var pythonReserved = reserved(`False None True and as assert async await break class
+	continue def del elif else except finally for from global if import in is lambda
+	nonlocal not or pass raise return try while with yield print`)
+
+func (pythonEmitter) Indent() string                   { return "    " }
+func (pythonEmitter) Reserved(name string) bool        { return pythonReserved[name] }
+func (pythonEmitter) Number(digits string) string      { return digits }
+func (pythonEmitter) Boolean(comparison string) string { return comparison }
+func (pythonEmitter) Condition(value string) string    { return value }
+func (pythonEmitter) Print(name string) string         { return "print(" + name + ")" }
+func (pythonEmitter) If(condition string) string       { return "if " + condition + ":" }
+func (pythonEmitter) Else() string                     { return "else:" }
+func (pythonEmitter) While(condition string) string    { return "while " + condition + ":" }
+func (pythonEmitter) End() string                      { return "" }
+func (pythonEmitter) Empty() string                    { return "pass" }
+
+func (pythonEmitter) Arithmetic(left string, operator string, right string) string {
+	return left + " " + operator + " " + right
+}
+
+func (pythonEmitter) Comparison(left string, operator string, right string) string {
+	return left + " " + operator + " " + right
+}
+
+func (pythonEmitter) Assign(name string, value string) string {
+	return name + " = " + value
+}
+
+func (pythonEmitter) File(code *Code) string {
+	if len(code.Lines) == 0 {
+		return ""
+	}
+	return strings.Join(code.Lines, "\n") + "\n"
+}
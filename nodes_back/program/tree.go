@@ -0,0 +1,184 @@
+// Package program reads the node graph of a module as a program. Data flows
+// from the output of a node into the input of another, so the graph is a
+// set of expression trees: the children of a node are the nodes connected
+// to its inputs, and a root is a node whose outputs go nowhere.
+//
+// The trees are built the way the editor (Drawflow.vue) builds them before
+// turning them into Python. Generate writes them in any language with a
+// registered Emitter: Python, JavaScript, Go and C. Compile prepares a
+// Machine that runs them as the Python code would.
+package program
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidGraph is wrapped by the errors about graphs that are not a
+// program: missing inputs, unknown node types, cycles.
+var ErrInvalidGraph = errors.New("invalid graph")
+
+// Node types, the names of the nodes in the editor.
+const (
+	TypeNumber         = "number"
+	TypeVariable       = "variable"
+	TypeAssign         = "assign"
+	TypeAddition       = "addition"
+	TypeSubtraction    = "subtraction"
+	TypeMultiplication = "multiplication"
+	TypeDivision       = "division"
+	TypeComparation    = "comparation"
+	TypeIf             = "ifstatement"
+	TypeWhile          = "myfor"
+)
+
+// Input ports of the if and while nodes.
+const (
+	PortCondition = "input_1"
+	PortBody      = "input_2"
+	PortElse      = "input_3"
+)
+
+// Node is a node of the graph, as stored.
+type Node struct {
+	ID       int
+	Type     string
+	Name     string // of variable and assign nodes
+	Value    string // of number nodes
+	Operator string // of comparation nodes
+	Inputs   []Port
+	Outputs  []Port
+}
+
+// Port is an input or an output of a node with its links.
+type Port struct {
+	Name  string
+	Links []Link
+}
+
+// Link is the other end of a connection: the node id and its port.
+type Link struct {
+	Node int
+	Port string
+}
+
+// Tree is a node with the subtrees connected to its inputs.
+type Tree struct {
+	*Node
+	// Port is the input of the parent this node is connected to.
+	Port     string
+	Children []*Tree
+}
+
+// Child returns the child connected to the given input, nil if there is
+// none.
+func (t *Tree) Child(port string) *Tree {
+	for _, child := range t.Children {
+		if child.Port == port {
+			return child
+		}
+	}
+	return nil
+}
+
+// Build returns the trees of the graph, ordered by the id of their root.
+// A root has no output connected and at least one input connected, so the
+// nodes that are not linked to anything are left out.
+func Build(nodes []*Node) ([]*Tree, error) {
+	by_id := make(map[int]*Node, len(nodes))
+	for _, node := range nodes {
+		by_id[node.ID] = node
+	}
+
+	var roots []*Node
+	for _, node := range nodes {
+		if !linked(node.Outputs) && linked(node.Inputs) {
+			roots = append(roots, node)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i].ID < roots[j].ID })
+
+	var trees []*Tree
+	for _, root := range roots {
+		tree, err := build(by_id, root, "", map[int]bool{})
+		if err != nil {
+			return nil, err
+		}
+		trees = append(trees, tree)
+	}
+	return trees, nil
+}
+
+// sortedPorts orders ports by their number, so input_10 comes after
+// input_2.
+func sortedPorts(ports []Port) []Port {
+	sorted := append([]Port(nil), ports...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Name, sorted[j].Name
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	return sorted
+}
+
+func linked(ports []Port) bool {
+	for _, port := range ports {
+		if len(port.Links) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// build makes the tree of node, connected to the input port of its parent.
+// path holds the nodes above it, to stop on cycles.
+func build(by_id map[int]*Node, node *Node, port string, path map[int]bool) (*Tree, error) {
+	if path[node.ID] {
+		return nil, fmt.Errorf("%w: node %d is part of a cycle", ErrInvalidGraph, node.ID)
+	}
+	path[node.ID] = true
+	defer delete(path, node.ID)
+
+	tree := &Tree{Node: node, Port: port}
+	for _, input := range sortedPorts(node.Inputs) {
+		for _, link := range input.Links {
+			child, ok := by_id[link.Node]
+			if !ok {
+				return nil, fmt.Errorf("%w: node %d is connected to missing node %d", ErrInvalidGraph, node.ID, link.Node)
+			}
+			subtree, err := build(by_id, child, input.Name, path)
+			if err != nil {
+				return nil, err
+			}
+			tree.Children = append(tree.Children, subtree)
+		}
+	}
+	return tree, nil
+}
+
+// Number returns the value of a number node as a decimal
+// integer. Like parseInt in the editor it reads the leading digits, so
+// "3.5" is 3, and anything else is 0.
+func (n *Node) Number() string {
+	s := strings.TrimSpace(n.Value)
+	sign := ""
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		if s[0] == '-' {
+			sign = "-"
+		}
+		s = s[1:]
+	}
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	digits := strings.TrimLeft(s[:end], "0")
+	if digits == "" {
+		return "0"
+	}
+	return sign + digits
+}
@@ -0,0 +1,219 @@
+package program
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// kind is the Python type of a value.
+type kind int
+
+const (
+	kindInt kind = iota
+	kindFloat
+	kindBool
+)
+
+// Value is a value of a running program. It behaves like the Python value
+// the generated code would hold: integers don't overflow, division always
+// gives a float and comparisons give a bool.
+type Value struct {
+	kind kind
+	i    *big.Int
+	f    float64
+	b    bool
+}
+
+func intValue(i *big.Int) Value  { return Value{kind: kindInt, i: i} }
+func floatValue(f float64) Value { return Value{kind: kindFloat, f: f} }
+func boolValue(b bool) Value     { return Value{kind: kindBool, b: b} }
+func numberValue(digits string) Value {
+	i, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		i = new(big.Int)
+	}
+	return intValue(i)
+}
+
+// String is the Python repr of the value, what print shows.
+func (v Value) String() string {
+	switch v.kind {
+	case kindFloat:
+		return pythonFloat(v.f)
+	case kindBool:
+		if v.b {
+			return "True"
+		}
+		return "False"
+	}
+	return v.i.String()
+}
+
+// MarshalJSON writes numbers as JSON numbers; inf and nan, which JSON
+// lacks, are strings.
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch {
+	case v.kind == kindBool:
+		return json.Marshal(v.b)
+	case v.kind == kindFloat && (math.IsInf(v.f, 0) || math.IsNaN(v.f)):
+		return json.Marshal(v.String())
+	}
+	return []byte(v.String()), nil
+}
+
+// pythonFloat formats f like repr() in Python: the shortest digits that
+// read back as f, in positional notation between 1e-4 and 1e16 and with
+// ".0" when it is whole.
+func pythonFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	e := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exponent := e[:strings.IndexByte(e, 'e')], e[strings.IndexByte(e, 'e')+1:]
+	exp, _ := strconv.Atoi(exponent)
+	if decpt := exp + 1; decpt > -4 && decpt <= 16 {
+		s := strconv.FormatFloat(f, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	}
+	sign := "+"
+	if exp < 0 {
+		sign, exp = "-", -exp
+	}
+	digits := strconv.Itoa(exp)
+	if len(digits) < 2 {
+		digits = "0" + digits
+	}
+	return mantissa + "e" + sign + digits
+}
+
+// truth is the value tested by if and while.
+func (v Value) truth() bool {
+	switch v.kind {
+	case kindFloat:
+		return v.f != 0
+	case kindBool:
+		return v.b
+	}
+	return v.i.Sign() != 0
+}
+
+// integer returns bools as the integers 1 and 0, as Python does in
+// arithmetic.
+func (v Value) integer() *big.Int {
+	if v.kind == kindBool {
+		if v.b {
+			return big.NewInt(1)
+		}
+		return big.NewInt(0)
+	}
+	return v.i
+}
+
+var errIntTooLarge = errors.New("OverflowError: int too large to convert to float")
+
+func (v Value) float() (float64, error) {
+	if v.kind == kindFloat {
+		return v.f, nil
+	}
+	f, _ := new(big.Float).SetInt(v.integer()).Float64()
+	if math.IsInf(f, 0) {
+		return 0, errIntTooLarge
+	}
+	return f, nil
+}
+
+// arithmetic applies + - * or / to a and b.
+func arithmetic(operator string, a Value, b Value) (Value, error) {
+	if a.kind != kindFloat && b.kind != kindFloat {
+		x, y := a.integer(), b.integer()
+		switch operator {
+		case "+":
+			return intValue(new(big.Int).Add(x, y)), nil
+		case "-":
+			return intValue(new(big.Int).Sub(x, y)), nil
+		case "*":
+			return intValue(new(big.Int).Mul(x, y)), nil
+		}
+		if y.Sign() == 0 {
+			return Value{}, errors.New("ZeroDivisionError: division by zero")
+		}
+		f, _ := new(big.Rat).SetFrac(x, y).Float64()
+		if math.IsInf(f, 0) {
+			return Value{}, errors.New("OverflowError: integer division result too large for a float")
+		}
+		return floatValue(f), nil
+	}
+
+	x, err := a.float()
+	if err != nil {
+		return Value{}, err
+	}
+	y, err := b.float()
+	if err != nil {
+		return Value{}, err
+	}
+	switch operator {
+	case "+":
+		return floatValue(x + y), nil
+	case "-":
+		return floatValue(x - y), nil
+	case "*":
+		return floatValue(x * y), nil
+	}
+	if y == 0 {
+		return Value{}, errors.New("ZeroDivisionError: float division by zero")
+	}
+	return floatValue(x / y), nil
+}
+
+// compare applies a comparison operator. Integers and floats compare by
+// their exact values.
+func compare(operator string, a Value, b Value) bool {
+	var c int
+	if a.kind != kindFloat && b.kind != kindFloat {
+		c = a.integer().Cmp(b.integer())
+	} else {
+		if (a.kind == kindFloat && math.IsNaN(a.f)) || (b.kind == kindFloat && math.IsNaN(b.f)) {
+			return operator == "!="
+		}
+		c = exact(a).Cmp(exact(b))
+	}
+	switch operator {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case ">":
+		return c > 0
+	case "<=":
+		return c <= 0
+	}
+	return c >= 0
+}
+
+// exact converts a number that is not NaN to a big.Float without rounding.
+func exact(v Value) *big.Float {
+	if v.kind == kindFloat {
+		return new(big.Float).SetFloat64(v.f)
+	}
+	i := v.integer()
+	prec := uint(i.BitLen())
+	if prec < 64 {
+		prec = 64
+	}
+	return new(big.Float).SetPrec(prec).SetInt(i)
+}
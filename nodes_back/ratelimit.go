@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// loginWindow is the period the per-IP limit of login attempts applies to.
+const loginWindow = time.Minute
+
+// loginLimiter throttles /user/login: every IP gets a number of attempts
+// per minute, and a username is locked for a while after a number of
+// failed logins in a row. The counters live in memory, so they are per
+// process and reset on restart.
+type loginLimiter struct {
+	mu          sync.Mutex
+	ipLimit     int
+	maxFailures int
+	lockout     time.Duration
+	ips         map[string]*ipAttempts
+	users       map[string]*userFailures
+	lastSweep   time.Time
+}
+
+type ipAttempts struct {
+	count int
+	start time.Time
+}
+
+type userFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func newLoginLimiter(c *Config) *loginLimiter {
+	return &loginLimiter{
+		ipLimit:     c.LoginIPLimit,
+		maxFailures: c.LoginMaxFailures,
+		lockout:     c.LoginLockout,
+		ips:         make(map[string]*ipAttempts),
+		users:       make(map[string]*userFailures),
+	}
+}
+
+// logins is the limiter of the server, set up in main().
+var logins *loginLimiter
+
+// allowIP counts an attempt from ip. Once the limit of the current window
+// is reached it returns how long to wait.
+func (l *loginLimiter) allowIP(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	a, ok := l.ips[ip]
+	if !ok || now.Sub(a.start) >= loginWindow {
+		a = &ipAttempts{start: now}
+		l.ips[ip] = a
+	}
+	if a.count >= l.ipLimit {
+		return false, a.start.Add(loginWindow).Sub(now)
+	}
+	a.count++
+	return true, 0
+}
+
+// locked tells how long username stays locked, 0 if it isn't.
+func (l *loginLimiter) locked(username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.users[username]; ok {
+		if wait := time.Until(f.lockedUntil); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// fail records a failed login for username and tells whether it locked
+// the username. Failures older than the lockout period are forgotten.
+func (l *loginLimiter) fail(username string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	f, ok := l.users[username]
+	if !ok || now.Sub(f.last) >= l.lockout {
+		f = &userFailures{}
+		l.users[username] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= l.maxFailures {
+		f.count = 0
+		f.lockedUntil = now.Add(l.lockout)
+		return true
+	}
+	return false
+}
+
+// succeed forgets the failures of username after a good login.
+func (l *loginLimiter) succeed(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.users, username)
+}
+
+// sweep drops the expired counters, at most once per window, so the maps
+// don't grow with every IP and username ever seen.
+func (l *loginLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < loginWindow {
+		return
+	}
+	l.lastSweep = now
+	for ip, a := range l.ips {
+		if now.Sub(a.start) >= loginWindow {
+			delete(l.ips, ip)
+		}
+	}
+	for username, f := range l.users {
+		if now.Sub(f.last) >= l.lockout && now.After(f.lockedUntil) {
+			delete(l.users, username)
+		}
+	}
+}
+
+// clientIP is the address the request comes from. X-Forwarded-For is not
+// trusted, since any client can set it.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// LoginRateLimit rejects the login attempts of an IP over the per-minute
+// limit with 429 Too Many Requests.
+func LoginRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if ok, wait := logins.allowIP(ip); !ok {
+			auditf("login_throttled ip=%s", ip)
+			render.Render(w, r, ErrRateLimited(fmt.Errorf("too many login attempts from %s: %w", ip, errRateLimited), wait))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLimiter is a limiter with the given settings.
+func testLimiter(ipLimit int, maxFailures int, lockout time.Duration) *loginLimiter {
+	return newLoginLimiter(&Config{LoginIPLimit: ipLimit, LoginMaxFailures: maxFailures, LoginLockout: lockout})
+}
+
+// captureAudit collects the audit entries of the test.
+func captureAudit(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	audit.SetOutput(&buf)
+	t.Cleanup(func() { audit.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestLoginLimiterIP(t *testing.T) {
+	l := testLimiter(3, 5, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allowIP("10.0.0.1"); !ok {
+			t.Fatalf("attempt %d refused, want 3 allowed", i+1)
+		}
+	}
+	ok, wait := l.allowIP("10.0.0.1")
+	if ok || wait <= 0 || wait > loginWindow {
+		t.Errorf("4th attempt = %v, %v, want refused for up to a minute", ok, wait)
+	}
+	if ok, _ := l.allowIP("10.0.0.2"); !ok {
+		t.Error("another IP was refused")
+	}
+
+	// The window is over
+	l.ips["10.0.0.1"].start = time.Now().Add(-loginWindow)
+	if ok, _ := l.allowIP("10.0.0.1"); !ok {
+		t.Error("attempt in a new window refused")
+	}
+}
+
+func TestLoginLimiterLockout(t *testing.T) {
+	l := testLimiter(10, 3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if l.fail("alice") {
+			t.Fatalf("failure %d locked alice, want 3 allowed", i+1)
+		}
+	}
+	if l.locked("alice") != 0 {
+		t.Error("alice is locked before her 3rd failure")
+	}
+	if !l.fail("alice") {
+		t.Error("3rd failure didn't lock alice")
+	}
+	if wait := l.locked("alice"); wait <= 0 || wait > time.Minute {
+		t.Errorf("alice is locked for %v, want up to a minute", wait)
+	}
+	if l.locked("bob") != 0 {
+		t.Error("bob is locked by alice's failures")
+	}
+
+	// A good login forgets the failures
+	l.succeed("alice")
+	l.fail("alice")
+	l.fail("alice")
+	l.succeed("alice")
+	if l.fail("alice") {
+		t.Error("failures before a good login still count")
+	}
+
+	// So does a lockout period without failures
+	l.fail("alice")
+	l.users["alice"].last = time.Now().Add(-time.Minute)
+	if l.fail("alice") {
+		t.Error("old failures still count")
+	}
+}
+
+func TestLoginLimiterSweep(t *testing.T) {
+	l := testLimiter(10, 3, time.Minute)
+	l.allowIP("10.0.0.1")
+	l.fail("alice")
+	l.fail("bob")
+
+	old := time.Now().Add(-2 * time.Minute)
+	l.ips["10.0.0.1"].start = old
+	l.users["alice"].last = old
+	l.lastSweep = old
+	l.allowIP("10.0.0.2")
+
+	if _, ok := l.ips["10.0.0.1"]; ok {
+		t.Error("the expired IP counter is kept")
+	}
+	if _, ok := l.users["alice"]; ok {
+		t.Error("alice's old failures are kept")
+	}
+	if _, ok := l.users["bob"]; !ok {
+		t.Error("bob's recent failure is gone")
+	}
+}
+
+func TestSignInLockout(t *testing.T) {
+	s := useMemoryStore(t)
+	config.LoginMaxFailures = 2
+	logins = newLoginLimiter(config)
+	t.Cleanup(func() { logins = nil })
+	events := captureAudit(t)
+
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser("alice", hash); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := serveAs(SignIn, "POST", `{"username": "alice", "password": "wrong"}`, nil)
+		if !strings.Contains(w.Body.String(), "errors") {
+			t.Fatalf("wrong password %d: %s, want an error", i+1, w.Body)
+		}
+	}
+	// Locked, even with the right password
+	w := serveAs(SignIn, "POST", `{"username": "alice", "password": "correct horse"}`, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("login of a locked user: status %d, Retry-After %q, want 429 with the wait", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	for _, event := range []string{`login_failed user="alice" ip=192.0.2.1 reason=wrong_password`, `login_locked user="alice"`, `reason=locked`} {
+		if !strings.Contains(events.String(), event) {
+			t.Errorf("audit log has no %q:\n%s", event, events)
+		}
+	}
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	useMemoryStore(t)
+	config.LoginIPLimit = 1
+	logins = newLoginLimiter(config)
+	t.Cleanup(func() { logins = nil })
+	captureAudit(t)
+
+	handler := LoginRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/user/login", nil))
+		if w.Code != want {
+			t.Errorf("attempt %d: status %d, want %d", i+1, w.Code, want)
+		}
+	}
+}
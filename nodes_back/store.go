@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+)
+
+// Store is the persistence layer behind the REST handlers. Users, modules,
+// nodes, their inputs/outputs (ports) and connections are all read and
+// written through it, so the handlers don't depend on a specific database.
+// Failures are reported with the domain errors in errors.go.
+type Store interface {
+	// Users
+	CreateUser(username string, password string) (string, error)
+	GetUsersByUsername(username string) ([]User, error)
+	UpdatePassword(username string, password string) error
+	UpdateRole(username string, role string) error
+	ListUsers() ([]User, error)
+	DeleteUser(username string) error
+	// SetUserIdentity links a user to the subject of an OpenID provider,
+	// UserByIdentity finds it back.
+	SetUserIdentity(username string, issuer string, subject string) error
+	UserByIdentity(issuer string, subject string) (*User, error)
+
+	// Sessions. GetSession returns expired sessions too, the caller checks
+	// ExpiresAt.
+	CreateSession(session *Session) (string, error)
+	GetSession(uid string) (*Session, error)
+	UpdateSession(session *Session) error
+	DeleteSession(uid string) error
+	DeleteUserSessions(username string) (int, error)
+
+	// API keys
+	CreateAPIKey(key *APIKey) (string, error)
+	GetAPIKey(uid string) (*APIKey, error)
+	UserAPIKeys(username string) ([]*APIKey, error)
+	DeleteAPIKey(uid string) error
+
+	// Groups. CreateGroup gives the group a new join code.
+	CreateGroup(group *Group) (*Group, error)
+	GetGroups() ([]*Group, error)
+	GetGroup(uid string) (*Group, error)
+	GroupByCode(code string) (*Group, error)
+	AddGroupMember(group_uid string, username string) error
+
+	// Modules
+	GetModuleByName(name string, username string) ([]Module, error)
+	CreateModule(module *Module) (string, error)
+	UserGetModules(username string) ([]*Module, error)
+	DeleteModule(uid string) error
+	ClearModule(uid string) error
+	// ModuleOf returns the module a module, node, data, input/output or
+	// connection uid belongs to.
+	ModuleOf(uid string) (*Module, error)
+
+	// Comments
+	CreateComment(comment *Comment) (string, error)
+	ModuleComments(module_uid string) ([]*Comment, error)
+
+	// Nodes
+	CreateNode(node *Node) (Node, error)
+	ModuleGetNodes(module_uid string) ([]*Node, error)
+	DeleteNode(uid string) error
+	NodeUpdatePosition(node_uid string, pos_x float32, pos_y float32) error
+	UpdateData(data *Data) error
+	SaveModuleGraph(module_uid string, nodes []*Node) (*GraphDiff, error)
+
+	// Ports and connections. AddConnection creates the connection inside
+	// its port in one transaction, so the garbage collector never sees it
+	// unattached.
+	AddConnection(input_output_uid string, connection *Connection) (string, error)
+	DeleteConnection(parent_uid string, connection *Connection) error
+
+	// Maintenance
+	CollectGarbage(dryRun bool) (*GCReport, error)
+
+	Close() error
+}
+
+// store is the Store used by the handlers, set up in main().
+var store Store
+
+// newStore opens the backend selected in the config.
+func newStore(c *Config) (Store, error) {
+	switch c.Store {
+	case "bolt":
+		return NewBoltStore(c.DBPath)
+	case "dgraph":
+		return NewDgraphStore(c.Dgraph)
+	case "memory":
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store %q, use bolt, dgraph or memory", c.Store)
+}
+
+// validateUser, validateSession, validateAPIKey, validateModule,
+// validateGroup, validateComment and validateNode check the fields every store needs
+// before writing.
+func validateUser(username string, password string) error {
+	if username == "" {
+		return fmt.Errorf("username: %w", errValidation)
+	}
+	if password == "" {
+		return fmt.Errorf("password: %w", errValidation)
+	}
+	return nil
+}
+
+func validateSession(session *Session) error {
+	if session.Username == "" || session.TokenHash == "" {
+		return fmt.Errorf("session username and token: %w", errValidation)
+	}
+	return nil
+}
+
+func validateAPIKey(key *APIKey) error {
+	if key.Username == "" || key.KeyHash == "" {
+		return fmt.Errorf("api key username and hash: %w", errValidation)
+	}
+	if key.Name == "" {
+		return fmt.Errorf("api key name: %w", errValidation)
+	}
+	return nil
+}
+
+func validateModule(module *Module) error {
+	if module.Name == "" {
+		return fmt.Errorf("module name: %w", errValidation)
+	}
+	if module.Owner == "" {
+		return fmt.Errorf("module owner: %w", errValidation)
+	}
+	return nil
+}
+
+func validateGroup(group *Group) error {
+	if group.Name == "" {
+		return fmt.Errorf("group name: %w", errValidation)
+	}
+	if group.Teacher == "" {
+		return fmt.Errorf("group teacher: %w", errValidation)
+	}
+	return nil
+}
+
+func validateComment(comment *Comment) error {
+	if comment.ModuleUID == "" || comment.Author == "" {
+		return fmt.Errorf("comment module and author: %w", errValidation)
+	}
+	if comment.Body == "" {
+		return fmt.Errorf("comment body: %w", errValidation)
+	}
+	return nil
+}
+
+func validateNode(node *Node) error {
+	if node.ModuleUID == "" {
+		return fmt.Errorf("node module_uid: %w", errValidation)
+	}
+	return nil
+}
+
+// nodeHasUid tells whether a node from a client carries a uid anywhere: in
+// itself, its data, its ports or their connections. The stores assign them.
+func nodeHasUid(node *Node) bool {
+	if node.Uid != "" || node.Data.Uid != "" {
+		return true
+	}
+	for _, io := range node.InputsOutputs {
+		if io == nil {
+			continue
+		}
+		if io.Uid != "" {
+			return true
+		}
+		for _, connection := range io.Connections {
+			if connection != nil && connection.Uid != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clearNodeUids drops every uid of a new node, so a mutation can't reach
+// objects that already exist.
+func clearNodeUids(node *Node) {
+	node.Uid = ""
+	node.Data.Uid = ""
+	for _, io := range node.InputsOutputs {
+		if io == nil {
+			continue
+		}
+		io.Uid = ""
+		for _, connection := range io.Connections {
+			if connection != nil {
+				connection.Uid = ""
+			}
+		}
+	}
+}
+
+// joinCodeAlphabet leaves out the characters that are easy to mistake for
+// one another (0/O, 1/I).
+const joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const joinCodeLength = 8
+
+// joinCodeAttempts is how many random codes CreateGroup tries before giving
+// up on finding an unused one.
+const joinCodeAttempts = 5
+
+// newJoinCode returns a random code for students to join a group.
+func newJoinCode() (string, error) {
+	b := make([]byte, joinCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = joinCodeAlphabet[int(b[i])%len(joinCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+func hasString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// removeString returns list without the occurrences of v.
+func removeString(list []string, v string) []string {
+	kept := list[:0]
+	for _, item := range list {
+		if item != v {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// sortComments orders comments oldest first.
+func sortComments(comments []*Comment) {
+	sort.Slice(comments, func(i, j int) bool {
+		if !comments[i].CreatedAt.Equal(comments[j].CreatedAt) {
+			return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+		}
+		return uidLess(comments[i].Uid, comments[j].Uid)
+	})
+}
+
+// sortAPIKeys orders keys oldest first.
+func sortAPIKeys(keys []*APIKey) {
+	sort.Slice(keys, func(i, j int) bool { return uidLess(keys[i].Uid, keys[j].Uid) })
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// storeTests check the behaviour every Store shares. Each one gets a new
+// empty store.
+var storeTests = []struct {
+	name string
+	run  func(t *testing.T, s Store)
+}{
+	{"Users", testStoreUsers},
+	{"UsernameOfSessionsAndKeys", testStoreUsernameOfSessionsAndKeys},
+	{"Uids", testStoreUids},
+	{"AddConnection", testStoreAddConnection},
+	{"DeleteConnection", testStoreDeleteConnection},
+	{"DeleteNode", testStoreDeleteNode},
+	{"DeleteModule", testStoreDeleteModule},
+	{"SaveModuleGraph", testStoreSaveModuleGraph},
+	{"CollectGarbage", testStoreCollectGarbage},
+}
+
+// testStore runs storeTests on the stores returned by open.
+func testStore(t *testing.T, open func(t *testing.T) Store) {
+	for _, tt := range storeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, open(t))
+		})
+	}
+}
+
+// isErr tells whether err is want, or no error for a nil want.
+func isErr(err error, want error) bool {
+	if want == nil {
+		return err == nil
+	}
+	return errors.Is(err, want)
+}
+
+// twoNodes stores a module with node 1 linked to node 2 on both ends.
+func twoNodes(t *testing.T, s Store) (string, Node, Node) {
+	t.Helper()
+	module_uid, err := s.CreateModule(&Module{Name: "sum", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.CreateNode(&Node{ModuleUID: module_uid, Id: 1, Name: "number", InputsOutputs: []*InputOutput{
+		testPort("", "output_1", "output", testConnection("", "2", "input_1")),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.CreateNode(&Node{ModuleUID: module_uid, Id: 2, Name: "assign", InputsOutputs: []*InputOutput{
+		testPort("", "input_1", "input", testConnection("", "1", "output_1")),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return module_uid, first, second
+}
+
+// moduleNode returns the stored node with the given Drawflow id.
+func moduleNode(t *testing.T, s Store, module_uid string, id int) *Node {
+	t.Helper()
+	nodes, err := s.ModuleGetNodes(module_uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		if node.Id == id {
+			return node
+		}
+	}
+	t.Fatalf("module %s has no node %d", module_uid, id)
+	return nil
+}
+
+func testStoreUsers(t *testing.T, s Store) {
+	if _, err := s.CreateUser("alice", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser("alice", "other"); !errors.Is(err, errConflict) {
+		t.Errorf("creating alice twice = %v, want conflict", err)
+	}
+	if _, err := s.CreateUser("bob", ""); !errors.Is(err, errValidation) {
+		t.Errorf("creating a user without password = %v, want validation error", err)
+	}
+
+	users, err := s.GetUsersByUsername("alice")
+	if err != nil || len(users) != 1 || users[0].Password != "hash" {
+		t.Errorf("GetUsersByUsername(alice) = %+v, %v, want alice with her hash", users, err)
+	}
+	if users, err := s.GetUsersByUsername("bob"); err != nil || len(users) != 0 {
+		t.Errorf("GetUsersByUsername(bob) = %+v, %v, want nobody", users, err)
+	}
+
+	if err := s.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser("alice", "again"); err != nil {
+		t.Errorf("the name of a deleted user is still taken: %v", err)
+	}
+}
+
+// Sessions and API keys carry the username too, they are not users.
+func testStoreUsernameOfSessionsAndKeys(t *testing.T, s Store) {
+	if _, err := s.CreateUser("carol", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateSession(&Session{Username: "carol", TokenHash: "token", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateAPIKey(&APIKey{Username: "carol", Name: "script", KeyHash: "key"}); err != nil {
+		t.Fatal(err)
+	}
+	if users, err := s.GetUsersByUsername("carol"); err != nil || len(users) != 1 {
+		t.Errorf("GetUsersByUsername(carol) = %+v, %v, want one user", users, err)
+	}
+	if _, err := s.CreateUser("carol", "other"); !errors.Is(err, errConflict) {
+		t.Errorf("creating carol twice = %v, want conflict", err)
+	}
+	if _, err := s.CreateUser("dave", "hash"); err != nil {
+		t.Errorf("creating another user next to carol's session and key: %v", err)
+	}
+}
+
+func testStoreUids(t *testing.T, s Store) {
+	module_uid, first, second := twoNodes(t, s)
+
+	seen := map[string]bool{module_uid: true}
+	for _, uid := range append(nodeUids(&first), nodeUids(&second)...) {
+		if uid == "" || seen[uid] {
+			t.Errorf("uid %q is empty or given twice", uid)
+		}
+		seen[uid] = true
+		if module, err := s.ModuleOf(uid); err != nil || module.Uid != module_uid {
+			t.Errorf("ModuleOf(%s) = %v, %v, want module %s", uid, module, err, module_uid)
+		}
+	}
+	if _, err := s.ModuleOf("0xdead"); !errors.Is(err, errNotFound) {
+		t.Errorf("ModuleOf a missing uid = %v, want not found", err)
+	}
+}
+
+func testStoreAddConnection(t *testing.T, s Store) {
+	module_uid, first, second := twoNodes(t, s)
+	output := first.InputsOutputs[0]
+
+	uid, err := s.AddConnection(output.Uid, &Connection{Uid: "0x1", NodeNumber: "2", Port: "input_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid == "" || uid == "0x1" {
+		t.Errorf("AddConnection kept the uid of the client: %q", uid)
+	}
+	if module, err := s.ModuleOf(uid); err != nil || module.Uid != module_uid {
+		t.Errorf("ModuleOf the new connection = %v, %v, want module %s", module, err, module_uid)
+	}
+	if connections := moduleNode(t, s, module_uid, 1).InputsOutputs[0].Connections; len(connections) != 2 {
+		t.Errorf("output_1 has %d connections, want 2", len(connections))
+	}
+
+	// Only a port takes connections, nothing changes otherwise
+	for name, parent := range map[string]string{
+		"data":       first.Data.Uid,
+		"connection": output.Connections[0].Uid,
+		"node":       second.Uid,
+		"module":     module_uid,
+		"missing":    "0xdead",
+	} {
+		if _, err := s.AddConnection(parent, &Connection{NodeNumber: "2", Port: "input_1"}); !errors.Is(err, errNotFound) {
+			t.Errorf("AddConnection to a %s = %v, want not found", name, err)
+		}
+	}
+	if connections := moduleNode(t, s, module_uid, 1).InputsOutputs[0].Connections; len(connections) != 2 {
+		t.Errorf("output_1 has %d connections after the failed adds, want 2", len(connections))
+	}
+}
+
+func testStoreDeleteConnection(t *testing.T, s Store) {
+	_, first, second := twoNodes(t, s)
+	output := first.InputsOutputs[0]
+	input := second.InputsOutputs[0]
+
+	tests := []struct {
+		name       string
+		parent     string
+		connection string
+		want       error
+	}{
+		{"connection of another port", input.Uid, output.Connections[0].Uid, errNotFound},
+		{"missing port", "0xdead", output.Connections[0].Uid, errNotFound},
+		{"connection of the port", output.Uid, output.Connections[0].Uid, nil},
+		{"already deleted", output.Uid, output.Connections[0].Uid, errNotFound},
+	}
+	for _, tt := range tests {
+		err := s.DeleteConnection(tt.parent, &Connection{Uid: tt.connection})
+		if !isErr(err, tt.want) {
+			t.Errorf("%s: DeleteConnection = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := s.ModuleOf(output.Connections[0].Uid); !errors.Is(err, errNotFound) {
+		t.Errorf("ModuleOf a deleted connection = %v, want not found", err)
+	}
+	if _, err := s.ModuleOf(input.Connections[0].Uid); err != nil {
+		t.Errorf("the connection of the other end is gone too: %v", err)
+	}
+}
+
+func testStoreDeleteNode(t *testing.T, s Store) {
+	module_uid, first, second := twoNodes(t, s)
+
+	if err := s.DeleteNode(first.Uid); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range append(nodeUids(&first), second.InputsOutputs[0].Connections[0].Uid) {
+		if _, err := s.ModuleOf(uid); !errors.Is(err, errNotFound) {
+			t.Errorf("ModuleOf(%s) after deleting node 1 = %v, want not found", uid, err)
+		}
+	}
+	nodes, err := s.ModuleGetNodes(module_uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Uid != second.Uid || len(nodes[0].InputsOutputs[0].Connections) != 0 {
+		t.Errorf("module has %+v, want node 2 without its link to node 1", nodes)
+	}
+	if err := s.DeleteNode(first.Uid); !errors.Is(err, errNotFound) {
+		t.Errorf("deleting node 1 again = %v, want not found", err)
+	}
+}
+
+func testStoreDeleteModule(t *testing.T, s Store) {
+	module_uid, first, second := twoNodes(t, s)
+
+	if err := s.DeleteModule(module_uid); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range append([]string{module_uid}, append(nodeUids(&first), nodeUids(&second)...)...) {
+		if _, err := s.ModuleOf(uid); !errors.Is(err, errNotFound) {
+			t.Errorf("ModuleOf(%s) after deleting the module = %v, want not found", uid, err)
+		}
+	}
+	if err := s.DeleteModule(module_uid); !errors.Is(err, errNotFound) {
+		t.Errorf("deleting the module again = %v, want not found", err)
+	}
+}
+
+func testStoreSaveModuleGraph(t *testing.T, s Store) {
+	module_uid, first, _ := twoNodes(t, s)
+
+	// Node 1 moves to the origin and loses its class and its connection,
+	// node 2 goes and node 3 comes
+	diff, err := s.SaveModuleGraph(module_uid, []*Node{
+		{Id: 1, Name: "number", Data: Data{Name: "x"}, InputsOutputs: []*InputOutput{testPort("", "output_1", "output")}},
+		{Id: 3, Name: "print", Class: "print", PosX: 10, PosY: 20},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Created != 1 || diff.Updated != 1 || diff.Deleted != 1 || len(diff.Nodes) != 2 {
+		t.Errorf("SaveModuleGraph = %+v, want 1 created, updated and deleted, and 2 nodes", diff)
+	}
+
+	node := moduleNode(t, s, module_uid, 1)
+	if node.Uid != first.Uid || node.InputsOutputs[0].Uid != first.InputsOutputs[0].Uid {
+		t.Errorf("node 1 got new uids: %+v", node)
+	}
+	if node.Class != "" || node.PosX != 0 || node.PosY != 0 || node.Data.Name != "x" {
+		t.Errorf("node 1 = %+v, want no class, at the origin, with data x", node)
+	}
+	if len(node.InputsOutputs[0].Connections) != 0 {
+		t.Errorf("node 1 kept its connections: %+v", node.InputsOutputs[0].Connections)
+	}
+	if _, err := s.ModuleOf(first.InputsOutputs[0].Connections[0].Uid); !errors.Is(err, errNotFound) {
+		t.Errorf("ModuleOf the removed connection = %v, want not found", err)
+	}
+	if node := moduleNode(t, s, module_uid, 3); node.Class != "print" || node.PosX != 10 || node.PosY != 20 {
+		t.Errorf("node 3 = %+v, want class print at 10, 20", node)
+	}
+
+	if _, err := s.SaveModuleGraph("0xdead", nil); !errors.Is(err, errNotFound) {
+		t.Errorf("saving the graph of a missing module = %v, want not found", err)
+	}
+}
+
+func testStoreCollectGarbage(t *testing.T, s Store) {
+	module_uid, _, second := twoNodes(t, s)
+
+	// A connection of node 2 to a node that doesn't exist
+	dangling, err := s.AddConnection(second.InputsOutputs[0].Uid, &Connection{NodeNumber: "9", Port: "output_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.CollectGarbage(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total() != 1 || len(report.DanglingConnections) != 1 || report.DanglingConnections[0] != dangling {
+		t.Errorf("dry run = %+v, want the connection to node 9 only", report)
+	}
+	if _, err := s.ModuleOf(dangling); err != nil {
+		t.Errorf("the dry run removed the connection: %v", err)
+	}
+
+	if _, err := s.CollectGarbage(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ModuleOf(dangling); !errors.Is(err, errNotFound) {
+		t.Errorf("ModuleOf the collected connection = %v, want not found", err)
+	}
+	if connections := moduleNode(t, s, module_uid, 2).InputsOutputs[0].Connections; len(connections) != 1 {
+		t.Errorf("node 2 has %d connections after the gc, want its link to node 1", len(connections))
+	}
+	if report, err := s.CollectGarbage(true); err != nil || report.Total() != 0 {
+		t.Errorf("second gc = %+v, %v, want nothing left", report, err)
+	}
+}
@@ -1,4 +1,5 @@
 import Vue from 'vue'
+import axios from 'axios'
 import App from './App.vue'
 import router from "./router"
 import store from './store'
@@ -7,6 +8,57 @@ import './assets/app.css'
 
 Vue.config.productionTip = false
 
+const refreshURL = 'http://localhost:3333/user/refresh';
+
+// Send the access token with every request. When the backend rejects it
+// (it only lasts a few minutes) trade the refresh token for a new pair and
+// retry once; go back to the login page if the session has ended.
+axios.interceptors.request.use(config => {
+  const user = JSON.parse(localStorage.getItem('user'));
+  if (user && user.token && config.url !== refreshURL) {
+    config.headers.Authorization = 'Bearer ' + user.token;
+  }
+  return config;
+});
+
+// Requests failing at the same time share one refresh
+let refreshing = null;
+
+function refreshTokens() {
+  const user = JSON.parse(localStorage.getItem('user'));
+  if (!user || !user.refresh_token) {
+    return Promise.reject(new Error('no refresh token'));
+  }
+  if (!refreshing) {
+    refreshing = axios.post(refreshURL, { refresh_token: user.refresh_token }).then(response => {
+      user.token = response.data.token;
+      user.refresh_token = response.data.refresh_token;
+      localStorage.setItem('user', JSON.stringify(user));
+    }).finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+}
+
+axios.interceptors.response.use(response => response, error => {
+  const config = error.config;
+  if (!error.response || error.response.status !== 401 || router.currentRoute.path === '/login') {
+    return Promise.reject(error);
+  }
+  if (config.url !== refreshURL && !config._retried) {
+    config._retried = true;
+    return refreshTokens().then(() => axios(config), () => {
+      localStorage.removeItem('user');
+      router.push('/login');
+      return Promise.reject(error);
+    });
+  }
+  localStorage.removeItem('user');
+  router.push('/login');
+  return Promise.reject(error);
+});
+
 new Vue({
   router,
   store,
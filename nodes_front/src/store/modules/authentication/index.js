@@ -5,7 +5,8 @@ const state = {
     token: '',
     errors: [],
     username_error: false,
-    password_error: false
+    password_error: false,
+    oidc_enabled: false
 }
 
 const mutations = {
@@ -20,6 +21,9 @@ const mutations = {
     },
     UPDATE_PASSWORD_ERROR(state, payload) {
         state.password_error = payload;
+    },
+    UPDATE_OIDC_ENABLED(state, payload) {
+        state.oidc_enabled = payload;
     }
 }
 
@@ -31,6 +35,48 @@ const actions = {
             commit('UPDATE_PASSWORD_ERROR', false);
         }
     },
+    logOut({ commit }){
+        // End the session on the server too, so the refresh token stops working
+        const user = JSON.parse(localStorage.getItem('user'));
+        if(user && user.refresh_token){
+            axios({
+                method: 'POST',
+                url: 'http://localhost:3333/user/logout',
+                data: { refresh_token: user.refresh_token }
+            }).catch(() => {});
+        }
+        localStorage.clear();
+        commit('UPDATE_TOKEN', "");
+        router.push('login');
+    },
+    checkOIDC({ commit }){
+        axios.get('http://localhost:3333/user/oidc').then(response => {
+            commit('UPDATE_OIDC_ENABLED', response.data.enabled);
+        }).catch(() => {});
+    },
+    // The backend sends the browser back from the school login with the
+    // tokens, or the error, in the fragment of the URL
+    oidcSignIn({ commit }, fragment){
+        const params = new URLSearchParams(fragment);
+        window.history.replaceState(null, '', window.location.pathname);
+        if(params.get('error')){
+            commit('UPDATE_ERRORS', [{
+                field: "password",
+                message: "No se pudo entrar con la cuenta del colegio"
+            }]);
+            commit('UPDATE_PASSWORD_ERROR', true);
+            return;
+        }
+        if(params.get('token')){
+            let user = {}
+            user["username"] = params.get('username');
+            user["token"] = params.get('token');
+            user["refresh_token"] = params.get('refresh_token');
+            localStorage.setItem('user', JSON.stringify(user));
+            commit('UPDATE_TOKEN', params.get('token'));
+            router.push('dashboard');
+        }
+    },
     signIn({ commit }, payload){
         commit('UPDATE_TOKEN', "");
         commit('UPDATE_USERNAME_ERROR', false);
@@ -47,6 +93,7 @@ const actions = {
                 let user = {}
                 user["username"] = response.data.username;
                 user["token"] = response.data.token;
+                user["refresh_token"] = response.data.refresh_token;
                 localStorage.setItem('user', JSON.stringify(user));
                 commit('UPDATE_TOKEN', response.data.token);
                 router.push('dashboard');
@@ -62,7 +109,17 @@ const actions = {
                 });
                 commit('UPDATE_ERRORS', response.data.errors);
             }
-        })        
+        }).catch(error => {
+            // Too many attempts: the username or this IP is locked for a while
+            if(error.response && error.response.status === 429){
+                let minutes = Math.ceil((error.response.headers['retry-after'] || 60) / 60);
+                commit('UPDATE_PASSWORD_ERROR', true);
+                commit('UPDATE_ERRORS', [{
+                    field: "password",
+                    message: "Demasiados intentos, vuelva a intentarlo en " + minutes + " min"
+                }]);
+            }
+        })
     }
 }
 
@@ -70,7 +127,8 @@ const getters = {
     token: state => state.token,
     errors: state => state.errors,
     username_error: state => state.username_error,
-    password_error: state => state.password_error
+    password_error: state => state.password_error,
+    oidc_enabled: state => state.oidc_enabled
 }
 
 const authenticationModule = {
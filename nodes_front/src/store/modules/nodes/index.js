@@ -18,7 +18,6 @@ const actions = {
             method: 'POST',
             url: 'http://localhost:3333/nodes/create',
             data: {
-                token:"12345",
                 node: payload,
             }
         }).then(response => {
@@ -31,7 +30,6 @@ const actions = {
             method: 'DELETE',
             url: 'http://localhost:3333/nodes/'+payload.uid,
             data: {
-                token:"12345",
                 module: payload,
             }
         }).then(response => {
@@ -44,7 +42,6 @@ const actions = {
             method: 'PUT',
             url: 'http://localhost:3333/nodes/'+payload.uid,
             data: {
-                token:"12345",
                 pos_x: payload.pos_x,
                 pos_y: payload.pos_y
             }
@@ -58,7 +55,6 @@ const actions = {
             method: 'POST',
             url: 'http://localhost:3333/nodes/data',
             data: {
-                token:"12345",
                 node_data: payload
             }
         }).then(response => {
@@ -71,7 +67,6 @@ const actions = {
             method: 'POST',
             url: 'http://localhost:3333/nodes/connections/create',
             data: {
-                token:"12345",
                 output_connection: payload.output_connection,
                 input_connection: payload.input_connection,
                 output_input_output_uid: payload.output_input_output_uid,
@@ -87,7 +82,6 @@ const actions = {
             method: 'POST',
             url: 'http://localhost:3333/nodes/connections/delete',
             data: {
-                token:"12345",
                 output_connection: payload.output_connection, 
                 input_connection: payload.input_connection,
                 output_connection_parent_uid: payload.output_connection_parent_uid,
@@ -103,7 +97,6 @@ const actions = {
             method: 'POST',
             url: 'http://localhost:3333/modules/search',
             data: {
-                token:"12345",
                 module: payload,
             }
         }).then(response => {
@@ -118,7 +111,6 @@ const actions = {
             method: 'POST',
             url: 'http://localhost:3333/modules/create',
             data: {
-                token:"12345",
                 module: payload,
             }
         }).then(response => {
@@ -130,9 +122,6 @@ const actions = {
         return axios({
             method: 'PUT',
             url: 'http://localhost:3333/modules/'+payload,
-            data: {
-                token:"12345",
-            }
         }).then(response => {
             return response;
         }) 
@@ -143,7 +132,6 @@ const actions = {
             method: 'POST',
             url: 'http://localhost:3333/modules',
             data: {
-                token:"12345",
                 username: payload,
             }
         }).then(response => {
@@ -156,7 +144,6 @@ const actions = {
             method: 'DELETE',
             url: 'http://localhost:3333/modules/'+payload.uid,
             data: {
-                token:"12345",
                 module: payload,
             }
         }).then(response => {